@@ -1,10 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 
 	"github.com/arrowinaknee/switchman/pkg/api"
+	"github.com/arrowinaknee/switchman/pkg/appconfig"
+	"github.com/arrowinaknee/switchman/pkg/lsp"
 	"github.com/arrowinaknee/switchman/pkg/runtime"
 )
 
@@ -12,6 +21,39 @@ func main() {
 	if len(os.Args) < 2 {
 		log.Fatal("Missing config file argument")
 	}
+
+	switch os.Args[1] {
+	case "lsp":
+		// language server for config editors, speaks over stdio
+		err := lsp.Serve(os.Stdin, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "schema":
+		// describe the config language for tools
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(appconfig.ServerSchema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "config":
+		err := configCommand(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "convert":
+		// convert a config file between formats
+		err := convert(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	config_path := os.Args[1]
 
 	runtime := runtime.New()
@@ -27,3 +69,99 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// switchman convert [-from format] [-to format] input [output]
+//
+// Formats are guessed by file extensions when not set, output is written to stdout if no
+// output file is given
+func convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fromName := flags.String("from", "", "format of the input config: native, json or yaml")
+	toName := flags.String("to", "", "format of the output config: native, json or yaml")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: switchman convert [-from format] [-to format] input [output]")
+	}
+	inputPath, outputPath := flags.Arg(0), flags.Arg(1)
+
+	from := appconfig.FormatFromPath(inputPath)
+	if *fromName != "" {
+		var err error
+		if from, err = appconfig.ParseFormat(*fromName); err != nil {
+			return err
+		}
+	}
+	to := appconfig.FormatNative
+	if outputPath != "" {
+		to = appconfig.FormatFromPath(outputPath)
+	}
+	if *toName != "" {
+		var err error
+		if to, err = appconfig.ParseFormat(*toName); err != nil {
+			return err
+		}
+	}
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	// convert to memory first, so that a failed conversion doesn't leave a broken output file
+	var output bytes.Buffer
+	if err = appconfig.Convert(input, from, to, &output); err != nil {
+		return fmt.Errorf("%s:%w", inputPath, err)
+	}
+	if outputPath == "" {
+		_, err = os.Stdout.Write(output.Bytes())
+		return err
+	}
+	return os.WriteFile(outputPath, output.Bytes(), 0644)
+}
+
+// switchman config dump [-api address] [path]
+//
+// Prints the effective config as JSON: of the config file if the path is given, otherwise of
+// the server running with the API at the address
+func configCommand(args []string) error {
+	if len(args) < 1 || args[0] != "dump" {
+		return errors.New("usage: switchman config dump [-api address] [path]")
+	}
+	flags := flag.NewFlagSet("config dump", flag.ContinueOnError)
+	address := flags.String("api", "localhost:3315", "address of the running server API")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("usage: switchman config dump [-api address] [path]")
+	}
+
+	if path := flags.Arg(0); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		srv, err := appconfig.ParseServerFormat(file, appconfig.FormatFromPath(path))
+		if err != nil {
+			return fmt.Errorf("%s:%w", path, err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(srv)
+	}
+
+	resp, err := http.Get("http://" + *address + "/config/effective")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("api responded with %s", resp.Status)
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
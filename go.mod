@@ -1,5 +1,11 @@
 module github.com/arrowinaknee/switchman
 
-go 1.21.6
+go 1.24.0
 
-require github.com/rs/cors v1.10.1
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/rs/cors v1.10.1
+	golang.org/x/net v0.25.0
+	golang.org/x/text v0.15.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/arrowinaknee/switchman/pkg/appconfig"
 	"github.com/arrowinaknee/switchman/pkg/runtime"
+	httpserver "github.com/arrowinaknee/switchman/pkg/servers/http"
 	"github.com/rs/cors"
 )
 
@@ -28,7 +30,12 @@ func Start(runtime *runtime.Runtime, address string) {
 	handler := c.Handler(mux)
 
 	mux.HandleFunc("/config", api.handleConfig)
+	mux.HandleFunc("/config/effective", api.handleEffectiveConfig)
 	mux.HandleFunc("/verify", api.handleVerify)
+	mux.HandleFunc("/schema", api.handleSchema)
+	mux.HandleFunc("/upstreams", api.handleUpstreams)
+	mux.HandleFunc("/metrics", api.handleMetrics)
+	mux.HandleFunc("/cache/purge", api.handleCachePurge)
 	go http.ListenAndServe(address, handler)
 }
 
@@ -43,6 +50,7 @@ func (api *Api) handleConfig(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprint(w, "Error reading config file")
 			return
 		}
+		w.Header().Set("Content-Type", appconfig.FormatFromPath(path).ContentType())
 		_, err = io.Copy(w, file)
 		if err != nil {
 			log.Printf("api: error reading config file '%s': %s", path, err)
@@ -60,13 +68,30 @@ func (api *Api) handleConfig(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprint(w, "Error updating config file")
 			return
 		}
+		format, err := requestFormat(r, path)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
 		// first parse the config, if code is valid first update the file, then update srv in runtime
-		srv, err := appconfig.ParseServer(bytes.NewReader(body))
+		srv, err := appconfig.ParseServerFormat(bytes.NewReader(body), format)
 		if err != nil {
 			w.WriteHeader(http.StatusUnprocessableEntity)
 			fmt.Fprint(w, err.Error())
 			return
 		}
+		// the file keeps its format, configs in other formats are converted
+		if fileFormat := appconfig.FormatFromPath(path); format != fileFormat {
+			var converted bytes.Buffer
+			err = appconfig.Convert(bytes.NewReader(body), format, fileFormat, &converted)
+			if err != nil {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				fmt.Fprint(w, err.Error())
+				return
+			}
+			body = converted.Bytes()
+		}
 		file, err := os.Create(path)
 		if err != nil {
 			log.Printf("api: error updating config file '%s': %s", path, err)
@@ -90,10 +115,97 @@ func (api *Api) handleConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Running server with all snippets expanded and defaults applied
+func (api *Api) handleEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		server := api.runtime.GetServer()
+		if server == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "No config is loaded")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(server)
+		if err != nil {
+			log.Printf("api: error writing effective config: %s", err)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Health of the servers of upstream pools of the running server
+func (api *Api) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status := []httpserver.UpstreamStatus{}
+		if server, ok := api.runtime.GetServer().(*httpserver.Server); ok {
+			status = server.UpstreamStatus()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(status)
+		if err != nil {
+			log.Printf("api: error writing upstream status: %s", err)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (api *Api) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		metrics := []httpserver.ProxyMetrics{}
+		if server, ok := api.runtime.GetServer().(*httpserver.Server); ok {
+			metrics = server.ProxyMetrics()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(metrics)
+		if err != nil {
+			log.Printf("api: error writing proxy metrics: %s", err)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Remove responses stored by caches of proxies. The 'host' and 'path' query parameters select
+// the responses, a path ending with '*' is a prefix. All of them are removed if none are given
+func (api *Api) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		purged := 0
+		if server, ok := api.runtime.GetServer().(*httpserver.Server); ok {
+			purged = server.PurgeCache(r.URL.Query().Get("host"), r.URL.Query().Get("path"))
+		}
+		log.Printf("api: purged %d cached responses", purged)
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+		if err != nil {
+			log.Printf("api: error writing purge result: %s", err)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (api *Api) handleVerify(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		_, err := appconfig.ParseServer(r.Body)
+		format, err := requestFormat(r, api.runtime.GetConfigPath())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		_, err = appconfig.ParseServerFormat(r.Body, format)
 		if err != nil {
 			fmt.Fprint(w, err.Error())
 			return
@@ -103,3 +215,28 @@ func (api *Api) handleVerify(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+// Format of a config sent in request body. It is set by the 'format' query parameter or by the
+// content type, configs of unknown types are expected to be in the format of the config file
+func requestFormat(r *http.Request, path string) (appconfig.Format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		return appconfig.ParseFormat(name)
+	}
+	if format, ok := appconfig.FormatFromContentType(r.Header.Get("Content-Type")); ok {
+		return format, nil
+	}
+	return appconfig.FormatFromPath(path), nil
+}
+
+func (api *Api) handleSchema(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(appconfig.ServerSchema)
+		if err != nil {
+			log.Printf("api: error writing config schema: %s", err)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,266 @@
+package appconfig
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/arrowinaknee/switchman/pkg/config"
+)
+
+// Document is a config tree independent of its format. Blocks are nodes with fields, lists
+// have scalar items and other values are scalars. Entries of keyed blocks have a block with a
+// single field named after the variant, so '/path: files {...}' is the same as
+// {"/path": {"files": {...}}} in JSON
+type docNode struct {
+	pos    config.TokenPosition
+	block  bool
+	value  string
+	fields []docField
+	list   bool
+	items  []*docNode
+}
+
+type docField struct {
+	name  string
+	pos   config.TokenPosition
+	value *docNode
+}
+
+func docErrorf(pos config.TokenPosition, format string, a ...any) error {
+	return &config.Error{Pos: pos, Msg: fmt.Sprintf(format, a...)}
+}
+
+// Read a native config into a document with the parser, snippets are expanded. The document
+// has what the parser read, so it can't differ from the config that is served
+func readNativeDocument(conf *config.Reader) (*docNode, error) {
+	b := &docBuilder{}
+	conf.Listen(b)
+	if _, err := readConfig(conf); err != nil {
+		return nil, err
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.root, nil
+}
+
+// Builds a document from the structure reported by the config reader. Values read for a field
+// become its value, a name followed by a value is a variant like 'files {...}' of an endpoint
+type docBuilder struct {
+	root   *docNode
+	blocks []*docBuilderBlock
+	err    error
+}
+
+type docBuilderBlock struct {
+	node *docNode
+	// field that is being read and its values
+	field  *docField
+	values []*docNode
+}
+
+func (b *docBuilder) top() *docBuilderBlock {
+	return b.blocks[len(b.blocks)-1]
+}
+
+func (b *docBuilder) BeginStruct(pos config.TokenPosition) {
+	node := &docNode{pos: pos, block: true}
+	if len(b.blocks) == 0 {
+		// the server block is read after its keyword, which is not a field
+		b.root = &docNode{pos: pos, block: true}
+		b.blocks = append(b.blocks, &docBuilderBlock{node: b.root, field: &docField{name: "server", pos: pos}})
+	}
+	top := b.top()
+	top.values = append(top.values, node)
+	b.blocks = append(b.blocks, &docBuilderBlock{node: node})
+}
+
+func (b *docBuilder) EndStruct(pos config.TokenPosition) {
+	b.endField(b.top())
+	b.blocks = b.blocks[:len(b.blocks)-1]
+	if len(b.blocks) == 1 {
+		b.endField(b.top())
+	}
+}
+
+func (b *docBuilder) Field(name config.Token, pos config.TokenPosition) {
+	top := b.top()
+	b.endField(top)
+	// keys like locations can be quoted
+	if unescaped, err := name.Unescaped(); err == nil {
+		name = unescaped
+	}
+	top.field = &docField{name: name.String(), pos: pos}
+}
+
+func (b *docBuilder) Value(value config.Token, pos config.TokenPosition) {
+	top := b.top()
+	top.values = append(top.values, &docNode{pos: pos, value: value.String()})
+}
+
+func (b *docBuilder) List(items []config.Token, pos config.TokenPosition) {
+	top := b.top()
+	list := &docNode{pos: pos, list: true}
+	for _, item := range items {
+		list.items = append(list.items, &docNode{pos: pos, value: item.String()})
+	}
+	top.values = append(top.values, list)
+}
+
+func (b *docBuilder) endField(block *docBuilderBlock) {
+	f, values := block.field, block.values
+	block.field, block.values = nil, nil
+	if f == nil {
+		return
+	}
+	switch {
+	case len(values) == 1:
+		f.value = values[0]
+	case len(values) == 2 && !values[0].block && !values[0].list:
+		variant := docField{name: values[0].value, pos: values[0].pos, value: values[1]}
+		f.value = &docNode{pos: variant.pos, block: true, fields: []docField{variant}}
+	default:
+		if b.err == nil {
+			b.err = docErrorf(f.pos, "%s can't be converted, it has %d values", config.Token(f.name).Quote(), len(values))
+		}
+		return
+	}
+	block.node.fields = append(block.node.fields, *f)
+}
+
+// Name for variants of a keyed block, like 'endpoint type'
+func variantKind(schema *Block) string {
+	return strings.TrimSuffix(schema.Name, "s") + " type"
+}
+
+// Convert a document to tokens of the native config language
+func documentTokens(doc *docNode) (tokens []config.PositionedToken, err error) {
+	err = emitDocument(doc, func(t config.Token, pos config.TokenPosition) {
+		tokens = append(tokens, config.PositionedToken{Token: t, Pos: pos})
+	}, func() {})
+	return
+}
+
+// Pass tokens of the native config language for the document to emit, endField is called after
+// the last token of each field. The schema is used to write entries of keyed blocks, unknown
+// fields are written as is for the parser to report them
+func emitDocument(doc *docNode, emit func(t config.Token, pos config.TokenPosition), endField func()) error {
+	var emitBlock func(node *docNode, schema *Block) error
+	var emitValue func(f docField, schema *Block) error
+	emitField := func(f docField, schema *Block) error {
+		if err := emitValue(f, schema); err != nil {
+			return err
+		}
+		endField()
+		return nil
+	}
+	emitValue = func(f docField, schema *Block) error {
+		if f.value.list {
+			emit(config.Literal(f.name), f.pos)
+			emit(":", f.pos)
+			emit("[", f.value.pos)
+			for i, item := range f.value.items {
+				if i > 0 {
+					emit(",", item.pos)
+				}
+				emit(config.Literal(item.value), item.pos)
+			}
+			emit("]", f.value.pos)
+			return nil
+		}
+		if !f.value.block {
+			emit(config.Literal(f.name), f.pos)
+			emit(":", f.pos)
+			emit(config.Literal(f.value.value), f.value.pos)
+			return nil
+		}
+		if schema != nil && schema.Key != "" {
+			if len(f.value.fields) != 1 || f.value.fields[0].value.list {
+				return docErrorf(f.pos, "%s %s must contain a single %s", schema.Key, config.Token(f.name).Quote(), variantKind(schema))
+			}
+			entry := f.value.fields[0]
+			emit(config.Literal(f.name), f.pos)
+			emit(":", f.pos)
+			emit(config.Literal(entry.name), entry.pos)
+			// variants like error pages have a value instead of a block
+			if !entry.value.block {
+				emit(config.Literal(entry.value.value), entry.value.pos)
+				return nil
+			}
+			return emitBlock(entry.value, schema.Variant(entry.name))
+		}
+		var inner *Block
+		if schema != nil {
+			if p := schema.Property(f.name); p != nil {
+				inner = p.Block
+			}
+		}
+		emit(config.Literal(f.name), f.pos)
+		return emitBlock(f.value, inner)
+	}
+	emitBlock = func(node *docNode, schema *Block) error {
+		emit("{", node.pos)
+		for _, f := range node.fields {
+			if err := emitField(f, schema); err != nil {
+				return err
+			}
+		}
+		emit("}", node.pos)
+		return nil
+	}
+
+	for _, f := range doc.fields {
+		var schema *Block
+		if f.name == "server" {
+			schema = &Block{Properties: []*Property{{Name: "server", Block: ServerSchema}}}
+		}
+		if err := emitField(f, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write a document as a native config, one field per line
+func writeNative(w io.Writer, doc *docNode) error {
+	var b strings.Builder
+	depth := 0
+	lineStart := true
+	var last config.Token
+	err := emitDocument(doc, func(t config.Token, pos config.TokenPosition) {
+		switch t {
+		case "{":
+			b.WriteString(" {\n")
+			depth += 1
+			lineStart = true
+		case "}":
+			depth -= 1
+			b.WriteString(strings.Repeat("\t", depth) + "}")
+		case ":":
+			b.WriteString(": ")
+		case ",":
+			b.WriteString(", ")
+		case "[", "]":
+			b.WriteString(t.String())
+		default:
+			if lineStart {
+				b.WriteString(strings.Repeat("\t", depth))
+			} else if last.IsLiteral() {
+				// the value of a variant like 'file' in '404: file path'
+				b.WriteString(" ")
+			}
+			b.WriteString(t.String())
+			lineStart = false
+		}
+		last = t
+	}, func() {
+		b.WriteString("\n")
+		lineStart = true
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,381 @@
+package appconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/arrowinaknee/switchman/pkg/config"
+	"github.com/arrowinaknee/switchman/pkg/servers/http"
+	"gopkg.in/yaml.v3"
+)
+
+// Format of a config source. JSON and YAML documents follow the structure of the native
+// config, keyed blocks map keys to an object with a single variant:
+//
+//	{"server": {"endpoints": {"/": {"files": {"sources": "/var/www"}}}}}
+type Format string
+
+const (
+	FormatNative Format = "native"
+	FormatJSON   Format = "json"
+	FormatYAML   Format = "yaml"
+)
+
+// Formats lists all supported formats
+var Formats = []Format{FormatNative, FormatJSON, FormatYAML}
+
+// Find a format by its name, file extension names like 'yml' are accepted
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "native", "conf", "config":
+		return FormatNative, nil
+	case "json":
+		return FormatJSON, nil
+	case "yaml", "yml":
+		return FormatYAML, nil
+	}
+	return "", fmt.Errorf("unknown config format '%s'", name)
+}
+
+// Format of a config file by its extension, native for unknown extensions
+func FormatFromPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return FormatNative
+}
+
+// Format of a config by the media type, ok is false if the type is not a known config format.
+// Plain text is not recognized as browsers send it for any text
+func FormatFromContentType(contentType string) (format Format, ok bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	switch mediaType {
+	case "application/json":
+		return FormatJSON, true
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return FormatYAML, true
+	}
+	return "", false
+}
+
+// Media type used to send configs of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatJSON:
+		return "application/json"
+	case FormatYAML:
+		return "application/yaml"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Parse a server config written in the given format. Errors are reported at the line and
+// column of the source document
+func ParseServerFormat(source io.Reader, format Format) (*http.Server, error) {
+	if format == FormatNative || format == "" {
+		return ParseServer(source)
+	}
+	doc, err := readDocument(source, format)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := documentTokens(doc)
+	if err != nil {
+		return nil, err
+	}
+	return readConfig(config.NewTokensReader(tokens))
+}
+
+// Convert a server config from one format to another. The config is validated first, snippets
+// of native configs are expanded and comments are not preserved
+func Convert(source io.Reader, from Format, to Format, w io.Writer) error {
+	data, err := io.ReadAll(source)
+	if err != nil {
+		return err
+	}
+	// native configs are read into the document by the parser, other documents are parsed to
+	// check them
+	if from != FormatNative && from != "" {
+		if _, err = ParseServerFormat(bytes.NewReader(data), from); err != nil {
+			return err
+		}
+	}
+	doc, err := readDocument(bytes.NewReader(data), from)
+	if err != nil {
+		return err
+	}
+
+	switch to {
+	case FormatJSON:
+		var b bytes.Buffer
+		writeJSON(&b, doc, "")
+		b.WriteString("\n")
+		_, err = w.Write(b.Bytes())
+		return err
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err = enc.Encode(yamlNode(doc)); err != nil {
+			return err
+		}
+		return enc.Close()
+	case FormatNative:
+		return writeNative(w, doc)
+	}
+	return fmt.Errorf("unknown config format '%s'", to)
+}
+
+func readDocument(source io.Reader, format Format) (*docNode, error) {
+	switch format {
+	case FormatJSON:
+		data, err := io.ReadAll(source)
+		if err != nil {
+			return nil, err
+		}
+		return readJSON(data)
+	case FormatYAML:
+		return readYAML(source)
+	case FormatNative, "":
+		return readNativeDocument(config.NewReader(source))
+	}
+	return nil, fmt.Errorf("unknown config format '%s'", format)
+}
+
+// JSON is read token by token to keep the order of fields and their positions
+func readJSON(data []byte) (doc *docNode, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	// position of the next token, skipping whitespace and separators that the decoder
+	// handles internally
+	nextPos := func() config.TokenPosition {
+		offset := int(dec.InputOffset())
+		for offset < len(data) && strings.ContainsRune(" \t\r\n,:", rune(data[offset])) {
+			offset += 1
+		}
+		return offsetPosition(data, offset)
+	}
+	errorAt := func(pos config.TokenPosition, err error) error {
+		if syntax, ok := err.(*json.SyntaxError); ok {
+			return docErrorf(offsetPosition(data, int(syntax.Offset)), "%s", syntax.Error())
+		}
+		if err == io.EOF {
+			return docErrorf(pos, "unexpected EOF")
+		}
+		return docErrorf(pos, "%s", err.Error())
+	}
+
+	var readValue func() (*docNode, error)
+	readValue = func() (*docNode, error) {
+		pos := nextPos()
+		t, err := dec.Token()
+		if err != nil {
+			return nil, errorAt(pos, err)
+		}
+		switch t := t.(type) {
+		case json.Delim:
+			if t == '[' {
+				node := &docNode{pos: pos, list: true}
+				for dec.More() {
+					item, err := readValue()
+					if err != nil {
+						return nil, err
+					}
+					if item.block || item.list {
+						return nil, docErrorf(item.pos, "lists can only contain strings")
+					}
+					node.items = append(node.items, item)
+				}
+				endPos := nextPos()
+				if _, err := dec.Token(); err != nil {
+					return nil, errorAt(endPos, err)
+				}
+				return node, nil
+			}
+			node := &docNode{pos: pos, block: true}
+			for dec.More() {
+				fieldPos := nextPos()
+				key, err := dec.Token()
+				if err != nil {
+					return nil, errorAt(fieldPos, err)
+				}
+				field := docField{name: key.(string), pos: fieldPos}
+				if field.value, err = readValue(); err != nil {
+					return nil, err
+				}
+				node.fields = append(node.fields, field)
+			}
+			endPos := nextPos()
+			if _, err := dec.Token(); err != nil {
+				return nil, errorAt(endPos, err)
+			}
+			return node, nil
+		case string:
+			return &docNode{pos: pos, value: t}, nil
+		case json.Number:
+			return &docNode{pos: pos, value: t.String()}, nil
+		case bool:
+			return &docNode{pos: pos, value: fmt.Sprint(t)}, nil
+		default:
+			return nil, docErrorf(pos, "null values are not supported in configs")
+		}
+	}
+
+	if doc, err = readValue(); err != nil {
+		return nil, err
+	}
+	if !doc.block {
+		return nil, docErrorf(doc.pos, "config must be an object")
+	}
+	pos := nextPos()
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, docErrorf(pos, "unexpected data after the config")
+	}
+	return doc, nil
+}
+
+// Line and column of a byte offset, columns count runes like the native tokenizer
+func offsetPosition(data []byte, offset int) config.TokenPosition {
+	offset = min(offset, len(data))
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return config.TokenPosition{Line: line, Col: utf8.RuneCount(before[lineStart:]) + 1}
+}
+
+func readYAML(source io.Reader) (*docNode, error) {
+	var root yaml.Node
+	if err := yaml.NewDecoder(source).Decode(&root); err != nil {
+		if err == io.EOF {
+			return nil, docErrorf(config.TokenPosition{Line: 1, Col: 1}, "config is empty")
+		}
+		return nil, err
+	}
+	doc, err := yamlDocument(root.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	if !doc.block {
+		return nil, docErrorf(doc.pos, "config must be a mapping")
+	}
+	return doc, nil
+}
+
+func yamlDocument(n *yaml.Node) (*docNode, error) {
+	pos := config.TokenPosition{Line: n.Line, Col: n.Column}
+	switch n.Kind {
+	case yaml.AliasNode:
+		return yamlDocument(n.Alias)
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return nil, docErrorf(pos, "null values are not supported in configs")
+		}
+		return &docNode{pos: pos, value: n.Value}, nil
+	case yaml.MappingNode:
+		node := &docNode{pos: pos, block: true}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			inner, err := yamlDocument(value)
+			if err != nil {
+				return nil, err
+			}
+			// merge keys insert fields of another mapping
+			if key.Tag == "!!merge" {
+				if !inner.block {
+					return nil, docErrorf(inner.pos, "only mappings can be merged")
+				}
+				node.fields = append(node.fields, inner.fields...)
+				continue
+			}
+			if key.Kind != yaml.ScalarNode {
+				return nil, docErrorf(config.TokenPosition{Line: key.Line, Col: key.Column}, "keys must be strings")
+			}
+			node.fields = append(node.fields, docField{
+				name:  key.Value,
+				pos:   config.TokenPosition{Line: key.Line, Col: key.Column},
+				value: inner,
+			})
+		}
+		return node, nil
+	case yaml.SequenceNode:
+		node := &docNode{pos: pos, list: true}
+		for _, n := range n.Content {
+			item, err := yamlDocument(n)
+			if err != nil {
+				return nil, err
+			}
+			if item.block || item.list {
+				return nil, docErrorf(item.pos, "lists can only contain strings")
+			}
+			node.items = append(node.items, item)
+		}
+		return node, nil
+	}
+	return nil, docErrorf(pos, "unsupported YAML value")
+}
+
+func writeJSON(b *bytes.Buffer, node *docNode, indent string) {
+	if node.list {
+		b.WriteString("[")
+		for i, item := range node.items {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeJSON(b, item, indent)
+		}
+		b.WriteString("]")
+		return
+	}
+	if !node.block {
+		value, _ := json.Marshal(node.value)
+		b.Write(value)
+		return
+	}
+	if len(node.fields) == 0 {
+		b.WriteString("{}")
+		return
+	}
+	b.WriteString("{\n")
+	for i, f := range node.fields {
+		key, _ := json.Marshal(f.name)
+		b.WriteString(indent + "  ")
+		b.Write(key)
+		b.WriteString(": ")
+		writeJSON(b, f.value, indent+"  ")
+		if i+1 < len(node.fields) {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(indent + "}")
+}
+
+func yamlNode(node *docNode) *yaml.Node {
+	if node.list {
+		n := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, item := range node.items {
+			n.Content = append(n.Content, yamlNode(item))
+		}
+		return n
+	}
+	if !node.block {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: node.value}
+	}
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range node.fields {
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: f.name}, yamlNode(f.value))
+	}
+	return n
+}
@@ -0,0 +1,629 @@
+package appconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/arrowinaknee/switchman/pkg/config"
+	"github.com/arrowinaknee/switchman/pkg/servers/http"
+)
+
+var formatsServer = &http.Server{
+	Endpoints: []http.Endpoint{
+		{Location: "/test", Function: &http.EndpointFiles{Source: "E:/test website/", Index: []string{"index.html", "main page.htm"}}},
+		{Location: "/redirect", Function: &http.EndpointRedirect{URL: "/test"}},
+		{Location: "/api", Function: &http.EndpointProxy{Proto: "http", Host: "localhost:8000", Path: "/api"}},
+		{Location: "/health", Function: &http.EndpointRespond{Status: 200, Headers: map[string]string{"Cache-Control": "no-store"}, Body: "ok"}},
+	},
+}
+
+var formatsSources = map[Format]string{
+	FormatNative: `server {
+	endpoints {
+		/test: files {
+			sources: "E:/test website/"
+			index: [index.html, "main page.htm"]
+		}
+		/redirect: redirect {
+			url: /test
+		}
+		/api: proxy {
+			url: "localhost:8000/api"
+		}
+		/health: respond {
+			status: 200
+			headers {
+				Cache-Control: no-store
+			}
+			body: ok
+		}
+	}
+}
+`,
+	FormatJSON: `{
+  "server": {
+    "endpoints": {
+      "/test": {
+        "files": {
+          "sources": "E:/test website/",
+          "index": ["index.html", "main page.htm"]
+        }
+      },
+      "/redirect": {
+        "redirect": {
+          "url": "/test"
+        }
+      },
+      "/api": {
+        "proxy": {
+          "url": "localhost:8000/api"
+        }
+      },
+      "/health": {
+        "respond": {
+          "status": "200",
+          "headers": {
+            "Cache-Control": "no-store"
+          },
+          "body": "ok"
+        }
+      }
+    }
+  }
+}
+`,
+	FormatYAML: `server:
+  endpoints:
+    /test:
+      files:
+        sources: E:/test website/
+        index: [index.html, main page.htm]
+    /redirect:
+      redirect:
+        url: /test
+    /api:
+      proxy:
+        url: localhost:8000/api
+    /health:
+      respond:
+        status: "200"
+        headers:
+          Cache-Control: no-store
+        body: ok
+`,
+}
+
+func TestParseServerFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		source  string
+		result  *http.Server
+		wantErr bool
+	}{
+		{
+			name:   "native",
+			format: FormatNative,
+			source: formatsSources[FormatNative],
+			result: formatsServer,
+		}, {
+			name:   "json",
+			format: FormatJSON,
+			source: formatsSources[FormatJSON],
+			result: formatsServer,
+		}, {
+			name:   "yaml",
+			format: FormatYAML,
+			source: formatsSources[FormatYAML],
+			result: formatsServer,
+		}, {
+			name:   "yaml_merge",
+			format: FormatYAML,
+			source: `
+server:
+  endpoints:
+    /a:
+      files: &site
+        sources: /srv
+    /b:
+      files:
+        <<: *site
+`,
+			result: &http.Server{
+				Endpoints: []http.Endpoint{
+					{Location: "/a", Function: &http.EndpointFiles{Source: "/srv"}},
+					{Location: "/b", Function: &http.EndpointFiles{Source: "/srv"}},
+				},
+			},
+		}, {
+			name:   "yaml_anchor",
+			format: FormatYAML,
+			source: `
+server:
+  endpoints:
+    /a:
+      files: &site
+        sources: /srv
+    /b:
+      files: *site
+`,
+			result: &http.Server{
+				Endpoints: []http.Endpoint{
+					{Location: "/a", Function: &http.EndpointFiles{Source: "/srv"}},
+					{Location: "/b", Function: &http.EndpointFiles{Source: "/srv"}},
+				},
+			},
+		}, {
+			name:   "yaml_error_pages",
+			format: FormatYAML,
+			source: `
+server:
+  errors:
+    404:
+      file: /srv/404.html
+    5xx:
+      proxy: localhost:9000/errors
+`,
+			result: &http.Server{
+				Errors: http.ErrorPages{
+					"404": {File: "/srv/404.html"},
+					"5xx": {Proxy: &http.EndpointProxy{Proto: "http", Host: "localhost:9000", Path: "/errors"}},
+				},
+			},
+		}, {
+			name:   "yaml_upstreams",
+			format: FormatYAML,
+			source: `
+server:
+  endpoints:
+    /api:
+      proxy:
+        upstream: backend
+  upstreams:
+    backend:
+      pool:
+        servers:
+          "10.0.0.1:8080": 2
+          "10.0.0.2:8080": 1
+        balance: weighted
+`,
+			result: &http.Server{
+				Endpoints: []http.Endpoint{
+					{Location: "/api", Function: &http.EndpointProxy{
+						Proto: "http",
+						Host:  "localhost:80",
+						Path:  "/",
+						Upstream: &http.UpstreamPool{
+							Name: "backend",
+							Servers: []*http.Upstream{
+								{Proto: "http", Host: "10.0.0.1:8080", Weight: 2},
+								{Proto: "http", Host: "10.0.0.2:8080", Weight: 1},
+							},
+							Balance: http.BalanceWeighted,
+						},
+					}},
+				},
+			},
+		}, {
+			name:    "json_list",
+			format:  FormatJSON,
+			source:  `{"server": {"endpoints": []}}`,
+			wantErr: true,
+		}, {
+			name:    "json_two_types",
+			format:  FormatJSON,
+			source:  `{"server": {"endpoints": {"/": {"files": {}, "proxy": {}}}}}`,
+			wantErr: true,
+		}, {
+			name:    "json_syntax",
+			format:  FormatJSON,
+			source:  `{"server": {`,
+			wantErr: true,
+		}, {
+			name:    "json_trailing",
+			format:  FormatJSON,
+			source:  `{"server": {}} {}`,
+			wantErr: true,
+		}, {
+			name:    "yaml_empty",
+			format:  FormatYAML,
+			source:  "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseServerFormat(strings.NewReader(tt.source), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseServerFormat() error = \"%v\", wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if !equalConfig(got, tt.result) {
+				t.Errorf("ParseServerFormat() = %v, want %v", got, tt.result)
+			}
+		})
+	}
+}
+
+// Errors must point to the line and column of the source document
+func TestParseServerFormat_ErrorPosition(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		source string
+		want   config.TokenPosition
+	}{
+		{
+			name:   "json_property",
+			format: FormatJSON,
+			source: "{\n  \"server\": {\n    \"endpoints\": {\n      \"/\": {\"files\": {\"source\": \"/srv\"}}\n    }\n  }\n}",
+			want:   config.TokenPosition{Line: 4, Col: 23},
+		}, {
+			name:   "json_type",
+			format: FormatJSON,
+			source: "{\"server\": {\"endpoints\": {\"/\": {\"file\": {}}}}}",
+			want:   config.TokenPosition{Line: 1, Col: 33},
+		}, {
+			name:   "yaml_value",
+			format: FormatYAML,
+			source: "server:\n  endpoints:\n    /:\n      proxy:\n        url: 'http://host:port'\n",
+			want:   config.TokenPosition{Line: 5, Col: 14},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseServerFormat(strings.NewReader(tt.source), tt.format)
+			var confErr *config.Error
+			if !errors.As(err, &confErr) {
+				t.Fatalf("ParseServerFormat() error = %v, want config error", err)
+			}
+			if confErr.Pos != tt.want {
+				t.Errorf("ParseServerFormat() error at %v, want %v (%v)", confErr.Pos, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	for _, from := range Formats {
+		for _, to := range Formats {
+			t.Run(string(from)+"_"+string(to), func(t *testing.T) {
+				var got bytes.Buffer
+				err := Convert(strings.NewReader(formatsSources[from]), from, to, &got)
+				if err != nil {
+					t.Fatalf("Convert() error = %v", err)
+				}
+				if got.String() != formatsSources[to] {
+					t.Errorf("Convert() = %q, want %q", got.String(), formatsSources[to])
+				}
+			})
+		}
+	}
+}
+
+func TestConvert_Snippets(t *testing.T) {
+	source := `
+	define site {
+		sources: $dir
+	}
+	server {
+		endpoints {
+			/a: files {
+				use site { dir: "/srv/a" }
+			}
+		}
+	}`
+	var got bytes.Buffer
+	if err := Convert(strings.NewReader(source), FormatNative, FormatJSON, &got); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	want := `{"server":{"endpoints":{"/a":{"files":{"sources":"/srv/a"}}}}}`
+	if compact := strings.Join(strings.Fields(got.String()), ""); compact != want {
+		t.Errorf("Convert() = %s, want %s", compact, want)
+	}
+}
+
+// Variants with a value stay on one line and don't join the fields after them
+func TestConvert_ErrorPages(t *testing.T) {
+	source := `{"server": {"errors": {"404": {"file": "404.html"}, "5xx": {"template": "error.html"}}, "endpoints": {}}}`
+	want := `server {
+	errors {
+		404: file 404.html
+		5xx: template error.html
+	}
+	endpoints {
+	}
+}
+`
+	var got bytes.Buffer
+	if err := Convert(strings.NewReader(source), FormatJSON, FormatNative, &got); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if got.String() != want {
+		t.Errorf("Convert() = %q, want %q", got.String(), want)
+	}
+}
+
+// Config with every block, property and variant of the schema
+const roundTripSource = `server {
+	https_redirect {
+		status: 301
+		port: 8443
+		trusted_proxies: [10.0.0.0/8, 127.0.0.1]
+	}
+	errors {
+		404: file 404.html
+		5xx: template "errors/5xx.json"
+		403: proxy "http://localhost:9000/errors"
+	}
+	upstreams {
+		backend: pool {
+			servers {
+				"10.0.0.1:8080": 2
+				"10.0.0.2:8080": 1
+			}
+			balance: hash
+			hash_header: X-User
+			max_fails: off
+			fail_timeout: 10s
+			health_check {
+				path: /health
+				interval: 5s
+				timeout: 2s
+				status: 204
+				healthy_threshold: 1
+				unhealthy_threshold: 4
+			}
+			tls {
+				ca: testdata/ca.pem
+				server_name: backend.internal
+				cert: testdata/client.pem
+				key: testdata/client-key.pem
+			}
+		}
+		sessions: pool {
+			servers {
+				"unix:/run/app.sock": 1
+			}
+			balance: hash
+			hash_cookie: session
+		}
+	}
+	endpoints {
+		/static: files {
+			sources: "/var/www"
+			cache_control: "public, max-age=3600"
+			index: [index.html, index.htm]
+			fallback: index.html
+			browse: true
+			browse_template: listing.html
+			precompressed: [br, gzip]
+			follow_symlinks: always
+			hidden: unlisted
+			deny: ["*.bak", "private/*"]
+			compress {
+				encodings: [gzip]
+				min_size: 512
+				types: ["text/*"]
+			}
+			errors {
+				404: file missing.html
+			}
+		}
+		/old: redirect {
+			url: "https://example.com/{{.LocalPath}}"
+			status: 308
+			preserve_path: true
+			preserve_query: true
+		}
+		/api: proxy {
+			url: "/v1"
+			upstream: backend
+			keepalive: 8
+			idle_timeout: 1m
+			dial_timeout: 5s
+			response_header_timeout: 10s
+			http2: true
+			preserve_host: true
+			forwarded: trusted
+			trusted_proxies: [192.168.0.0/16]
+			rewrite_location: true
+			rewrite_cookies: true
+			cookie_domain: example.com
+			request_headers {
+				set {
+					X-Api-Key: secret
+				}
+				add {
+					X-Tag: a
+				}
+				remove: [Cookie]
+			}
+			response_headers {
+				remove: [Server]
+			}
+			timeout: 30s
+			flush_interval: immediate
+			upgrade_idle_timeout: off
+			retry {
+				attempts: 2
+				statuses: [502, 503]
+				backoff: 100ms
+				max_backoff: 2s
+			}
+			circuit_breaker {
+				failures: 3
+				open_timeout: 1m
+			}
+			cache {
+				key: [host, path, "header:Accept-Language"]
+				max_size: 16m
+				max_entry_size: 512k
+				dir: /var/cache/switchman
+				disk_max_size: 2g
+				default_ttl: 1m
+				stale_while_revalidate: 30s
+			}
+		}
+		/secure: proxy {
+			url: "https://localhost:8443/"
+			tls {
+				ca: testdata/ca.pem
+			}
+		}
+		/health: respond {
+			status: 200
+			headers {
+				Content-Type: application/json
+			}
+			body: "{\"ok\": true}"
+		}
+	}
+}
+`
+
+// Configs read the same after they are converted to another format and back, for every part of
+// the schema
+func TestConvert_RoundTrip(t *testing.T) {
+	want, err := ParseServer(strings.NewReader(roundTripSource))
+	if err != nil {
+		t.Fatalf("ParseServer() error = %v", err)
+	}
+	doc, err := readNativeDocument(config.NewReader(strings.NewReader(roundTripSource)))
+	if err != nil {
+		t.Fatalf("readNativeDocument() error = %v", err)
+	}
+	covered := map[any]bool{}
+	var walk func(node *docNode, schema *Block)
+	walk = func(node *docNode, schema *Block) {
+		for _, f := range node.fields {
+			if schema.Key != "" {
+				entry := f.value.fields[0]
+				variant := schema.Variant(entry.name)
+				covered[variant] = true
+				if entry.value.block {
+					walk(entry.value, variant)
+				}
+			} else if p := schema.Property(f.name); p != nil {
+				covered[p] = true
+				if p.Block != nil {
+					walk(f.value, p.Block)
+				}
+			}
+		}
+	}
+	walk(doc.fields[0].value, ServerSchema)
+	var check func(path string, b *Block)
+	check = func(path string, b *Block) {
+		for _, p := range b.Properties {
+			if !covered[p] {
+				t.Errorf("property %s/%s is not in the config", path, p.Name)
+			}
+			if p.Block != nil {
+				check(path+"/"+p.Name, p.Block)
+			}
+		}
+		for _, v := range b.Variants {
+			if !covered[v] {
+				t.Errorf("variant %s/%s is not in the config", path, v.Name)
+			}
+			check(path+"/"+v.Name, v)
+		}
+	}
+	check("server", ServerSchema)
+
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			testConversion(t, roundTripSource, want, format)
+		})
+	}
+}
+
+// Convert a native config to the format and back, both have to read as the same server
+func testConversion(t *testing.T, source string, want *http.Server, format Format) {
+	t.Helper()
+	var converted, back bytes.Buffer
+	if err := Convert(strings.NewReader(source), FormatNative, format, &converted); err != nil {
+		t.Fatalf("Convert() to %s error = %v", format, err)
+	}
+	got, err := ParseServerFormat(bytes.NewReader(converted.Bytes()), format)
+	if err != nil {
+		t.Fatalf("ParseServerFormat() error = %v\n%s", err, converted.String())
+	}
+	if !equalConfig(got, want) {
+		t.Errorf("ParseServerFormat() of the converted config = %+v, want %+v\n%s", got, want, converted.String())
+	}
+	if err := Convert(bytes.NewReader(converted.Bytes()), format, FormatNative, &back); err != nil {
+		t.Fatalf("Convert() from %s error = %v", format, err)
+	}
+	got, err = ParseServer(&back)
+	if err != nil {
+		t.Fatalf("ParseServer() error = %v\n%s", err, back.String())
+	}
+	if !equalConfig(got, want) {
+		t.Errorf("ParseServer() after the round trip = %+v, want %+v\n%s", got, want, back.String())
+	}
+}
+
+// Convert an example of the parser tests to every format, it is wrapped into a server config by
+// the format string
+func testExampleConversion(t *testing.T, wrap string, example string) {
+	t.Helper()
+	source := fmt.Sprintf(wrap, example)
+	want, err := ParseServer(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("ParseServer() of the example error = %v\n%s", err, source)
+	}
+	for _, format := range Formats {
+		testConversion(t, source, want, format)
+	}
+}
+
+func TestFormatFromContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        Format
+		wantOk      bool
+	}{
+		{"application/json", FormatJSON, true},
+		{"application/json; charset=utf-8", FormatJSON, true},
+		{"application/yaml", FormatYAML, true},
+		{"text/x-yaml", FormatYAML, true},
+		{"text/plain;charset=UTF-8", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			got, ok := FormatFromContentType(tt.contentType)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("FormatFromContentType() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want Format
+	}{
+		{"/etc/switchman/server.conf", FormatNative},
+		{"server.JSON", FormatJSON},
+		{"server.yaml", FormatYAML},
+		{"server.yml", FormatYAML},
+		{"server", FormatNative},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := FormatFromPath(tt.path); got != tt.want {
+				t.Errorf("FormatFromPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package appconfig
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Block describes a block of the config language and the properties that can be set inside of it
+type Block struct {
+	Name       string      `json:"name"`
+	Doc        string      `json:"doc"`
+	Properties []*Property `json:"properties,omitempty"`
+	// Blocks like endpoints have user defined keys instead of fixed properties, each entry is
+	// written as 'key: variant {...}' with one of the variant blocks
+	Key      string   `json:"key,omitempty"`
+	Variants []*Block `json:"variants,omitempty"`
+	// Type of the value of variants that are written as 'key: variant value' instead of a block
+	Value string `json:"value,omitempty"`
+}
+
+// Types of property values
+const (
+	TypeString = "string"
+	TypePath   = "path"
+	TypeURL    = "url"
+	TypeBool   = "bool"
+	TypeInt    = "int"
+	// Time like '30s' or '1m30s'
+	TypeDuration = "duration"
+	// Number of bytes like '512' or with a unit like '64k', '16m' or '1g'
+	TypeSize  = "size"
+	TypeList  = "list"
+	TypeBlock = "block"
+	// Block with user defined names and string values, like headers
+	TypeMap = "map"
+)
+
+// Property describes a single property of a block. Properties with a nested block are written
+// as 'name {...}', others as 'name: value'
+type Property struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Words accepted instead of a value of the type, like 'off'
+	Keywords []string `json:"keywords,omitempty"`
+	// Value used when the property is not set, empty if there is none
+	Default string `json:"default,omitempty"`
+	Doc     string `json:"doc"`
+	Block   *Block `json:"block,omitempty"`
+}
+
+// Check that the value has the type of the property. Only the form of the value is checked,
+// ranges of numbers are left to the parser
+func (p *Property) CheckValue(value string) error {
+	if slices.Contains(p.Keywords, value) {
+		return nil
+	}
+	var ok bool
+	var expected string
+	switch p.Type {
+	case TypeInt:
+		_, err := strconv.Atoi(value)
+		ok, expected = err == nil, "a number"
+	case TypeDuration:
+		_, ok = parseDuration(value)
+		expected = "a duration like '30s' or '1m30s'"
+	case TypeSize:
+		_, ok = parseSize(value)
+		expected = "a size like '512', '64k', '16m' or '1g'"
+	default:
+		return nil
+	}
+	if ok {
+		return nil
+	}
+	for _, k := range p.Keywords {
+		expected += fmt.Sprintf(" or '%s'", k)
+	}
+	return fmt.Errorf("'%s' is not a valid %s, expected %s", value, p.Name, expected)
+}
+
+// Find a property of the block by name, nil if there is none
+func (b *Block) Property(name string) *Property {
+	for _, p := range b.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Find a variant of the block by name, nil if there is none
+func (b *Block) Variant(name string) *Block {
+	for _, v := range b.Variants {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+var compressSchema = &Block{
+	Name: "compress",
+	Doc:  "Compresses responses for clients that accept it. Responses that are already encoded, partial or of other types are sent as is",
+	Properties: []*Property{
+		{Name: "encodings", Type: TypeList, Default: "[br, gzip, deflate]", Doc: "Encodings in order of preference: br, gzip and deflate"},
+		{Name: "min_size", Type: TypeSize, Default: "1k", Doc: "Minimum size of a response to compress it, in bytes or with a unit like '1k'"},
+		{Name: "types", Type: TypeList, Default: "[text/*, application/javascript, application/json, ...]", Doc: "Media types that are compressed, patterns like 'text/*' are allowed"},
+	},
+}
+
+var errorPagesSchema = &Block{
+	Name: "errors",
+	Doc:  "Pages sent with error responses, by status like 404 or class like 5xx. Clients that ask for JSON get the error as JSON instead",
+	Key:  "status",
+	Variants: []*Block{
+		{Name: "file", Value: TypePath, Doc: "File sent as is"},
+		{Name: "template", Value: TypePath, Doc: "Go template with .Status, .Error, .Path and .RequestID, html is escaped if the file is .html"},
+		{Name: "proxy", Value: TypeURL, Doc: "URL the page is requested from, with the status in X-Error-Status and the request path in X-Original-URI headers"},
+	},
+}
+
+// Properties that all endpoint types have
+var endpointProperties = []*Property{
+	{Name: "compress", Type: TypeBlock, Doc: compressSchema.Doc, Block: compressSchema},
+	{Name: "errors", Type: TypeBlock, Doc: errorPagesSchema.Doc + ". Endpoint pages take precedence over the ones of the server", Block: errorPagesSchema},
+}
+
+var filesSchema = &Block{
+	Name: "files",
+	Doc:  "Serves files from a directory in the local filesystem",
+	Properties: append([]*Property{
+		{Name: "sources", Type: TypePath, Doc: "Path to the directory that the files will be served from"},
+		{Name: "cache_control", Type: TypeString, Doc: "Cache-Control header sent with the files, e.g. 'public, max-age=3600'"},
+		{Name: "index", Type: TypeList, Default: "[index.html]", Doc: "Files served for directories, the first one that exists is used"},
+		{Name: "fallback", Type: TypePath, Doc: "File served with status 200 for pages that don't exist, like index.html of a single page app. Missing assets with a file extension still get 404"},
+		{Name: "browse", Type: TypeBool, Default: "false", Doc: "List contents of directories that have no index file, as html or as JSON for clients that accept it"},
+		{Name: "browse_template", Type: TypePath, Doc: "Path to a Go html/template file used to render directory listings instead of the default page"},
+		{Name: "precompressed", Type: TypeList, Doc: "Encodings of precompressed files to serve in order of preference: br, zstd and gzip. A client accepting brotli gets 'app.js.br' for 'app.js' if it exists"},
+		{Name: "follow_symlinks", Type: TypeString, Default: "always", Doc: "Whether symbolic links are followed: never, within-root if the target is inside of sources, or always"},
+		{Name: "hidden", Type: TypeString, Default: "unlisted", Doc: "Whether files whose names start with a dot, like .git, are served: deny, unlisted to serve them without listing, or allow. .well-known is always served"},
+		{Name: "deny", Type: TypeList, Doc: "Patterns of paths that are never served, like '*.bak' or 'private/*'. Patterns without a slash match any file or directory name"},
+	}, endpointProperties...),
+}
+
+var redirectSchema = &Block{
+	Name: "redirect",
+	Doc:  "Redirects all requests to another URL",
+	Properties: append([]*Property{
+		{Name: "url", Type: TypeURL, Doc: "URL that the requests are redirected to. It is a Go template with the same values as respond bodies, like 'https://example.com/{{.LocalPath}}'"},
+		{Name: "status", Type: TypeInt, Default: "301", Doc: "Status of the redirect: 301 or 308 for permanent and 302 or 307 for temporary ones, 307 and 308 keep the method. 303 makes the client use GET"},
+		{Name: "preserve_path", Type: TypeBool, Default: "false", Doc: "Append the part of the path after the location to the url, so that '/old/a' redirects to '/new/a'"},
+		{Name: "preserve_query", Type: TypeBool, Default: "false", Doc: "Append the query of the request to the url"},
+	}, endpointProperties...),
+}
+
+var proxySchema = &Block{
+	Name: "proxy",
+	Doc:  "Passes requests to another server and sends back its responses",
+	Properties: append([]*Property{
+		{Name: "url", Type: TypeURL, Default: "http://localhost:80/", Doc: "URL of the remote server in format [http[s]://][hostname][:port][/path], or unix:/socket/path[:/path] for a server listening on a unix socket"},
+		{Name: "upstream", Type: TypeString, Doc: "Name of a pool defined in upstreams that requests are balanced between, the url then only sets the path"},
+		{Name: "keepalive", Type: TypeInt, Keywords: []string{"off"}, Default: "32", Doc: "Idle connections kept open to the remote server to reuse them for the next requests, 'off' opens a connection for every request"},
+		{Name: "idle_timeout", Type: TypeDuration, Default: "90s", Doc: "Time an idle connection is kept open, like '90s' or '2m'"},
+		{Name: "dial_timeout", Type: TypeDuration, Default: "30s", Doc: "Time to connect to the remote server before responding with 504"},
+		{Name: "response_header_timeout", Type: TypeDuration, Doc: "Time to wait for the headers of the response after the request is sent before responding with 504, no limit if not set. It applies to each retry"},
+		{Name: "http2", Type: TypeBool, Default: "false", Doc: "Use HTTP/2 without TLS, the remote server must accept it without an upgrade. All requests share one connection"},
+		{Name: "preserve_host", Type: TypeBool, Default: "false", Doc: "Send the Host header of the request instead of the host of the url"},
+		{Name: "forwarded", Type: TypeString, Default: "replace", Doc: "What to do with Forwarded and X-Forwarded-* headers of the request: 'replace' them with the client address, 'append' the client to them, or append only for clients listed in trusted_proxies with 'trusted'"},
+		{Name: "trusted_proxies", Type: TypeList, Doc: "Addresses or networks like '10.0.0.0/8' of proxies whose forwarded headers are kept with 'forwarded: trusted'"},
+		{Name: "tls", Type: TypeBlock, Doc: tlsSchema.Doc, Block: tlsSchema},
+		{Name: "rewrite_location", Type: TypeBool, Default: "false", Doc: "Map Location and Content-Location headers of responses that point at the remote server from the path of the url to the location of the endpoint, so that redirects come back to the endpoint"},
+		{Name: "rewrite_cookies", Type: TypeBool, Default: "false", Doc: "Map the Path of cookies set by the remote server like rewrite_location, and replace their Domain if it is the host of the remote server"},
+		{Name: "cookie_domain", Type: TypeString, Doc: "Domain of cookies rewritten with rewrite_cookies, they are only sent to the host that set them if not set"},
+		{Name: "request_headers", Type: TypeBlock, Doc: "Changes to the headers of requests sent to the remote server", Block: headerRewriteSchema},
+		{Name: "response_headers", Type: TypeBlock, Doc: "Changes to the headers of responses sent to the client", Block: headerRewriteSchema},
+		{Name: "timeout", Type: TypeDuration, Doc: "Time the remote server has to respond including retries before responding with 504, no limit if not set"},
+		{Name: "flush_interval", Type: TypeDuration, Keywords: []string{"immediate"}, Doc: "Time after which parts of the response body are sent to the client, like '100ms', or 'immediate' to send every part right away. Event streams are always sent right away, other bodies are sent as buffers fill up if not set"},
+		{Name: "upgrade_idle_timeout", Type: TypeDuration, Keywords: []string{"off"}, Default: "5m", Doc: "Time an upgraded connection like a WebSocket is kept open without data in either direction, 'off' keeps it open until a side closes it. Upgraded connections are closed when the config is replaced"},
+		{Name: "retry", Type: TypeBlock, Doc: retrySchema.Doc, Block: retrySchema},
+		{Name: "circuit_breaker", Type: TypeBlock, Doc: circuitBreakerSchema.Doc, Block: circuitBreakerSchema},
+		{Name: "cache", Type: TypeBlock, Doc: cacheSchema.Doc, Block: cacheSchema},
+	}, endpointProperties...),
+}
+
+var cacheSchema = &Block{
+	Name: "cache",
+	Doc:  "Stores responses of the remote server and answers GET and HEAD requests with them while they are fresh, as told by Cache-Control, Expires and Vary. Responses that set cookies or are private are not stored. Responses carry an X-Cache header with HIT, MISS, STALE, REVALIDATED or BYPASS",
+	Properties: []*Property{
+		{Name: "key", Type: TypeList, Default: "[host, path, query]", Doc: "Parts of the request that tell responses apart: scheme, host, path, query, and 'header:Name' or 'cookie:name' for their values. Headers in Vary of a response are added to its key"},
+		{Name: "max_size", Type: TypeSize, Default: "64m", Doc: "Memory the stored responses take, in bytes or with a unit like '512k', '64m' or '1g'"},
+		{Name: "max_entry_size", Type: TypeSize, Default: "1m", Doc: "Responses with larger bodies are passed to the client without being stored"},
+		{Name: "dir", Type: TypePath, Doc: "Directory that responses are also written to, so that they are kept after they leave memory and across restarts. Responses are only kept in memory if not set"},
+		{Name: "disk_max_size", Type: TypeSize, Default: "1g", Doc: "Space the responses take in dir, the least recently used ones are removed first"},
+		{Name: "default_ttl", Type: TypeDuration, Doc: "Time responses without Cache-Control or Expires are fresh, like '1m'. If not set they are only stored if they can be revalidated with ETag or Last-Modified"},
+		{Name: "stale_while_revalidate", Type: TypeDuration, Doc: "Time a stale response is still sent while a new one is fetched in the background, for responses without their own stale-while-revalidate"},
+	},
+}
+
+var headerRewriteSchema = &Block{
+	Name: "headers",
+	Doc:  "Changes to headers, they are removed first, then set and then added",
+	Properties: []*Property{
+		{Name: "set", Type: TypeMap, Doc: "Headers as 'Name: value' whose values are replaced"},
+		{Name: "add", Type: TypeMap, Doc: "Headers as 'Name: value' that get another value"},
+		{Name: "remove", Type: TypeList, Doc: "Names of headers that are removed"},
+	},
+}
+
+var tlsSchema = &Block{
+	Name: "tls",
+	Doc:  "Settings of connections to https servers",
+	Properties: []*Property{
+		{Name: "ca", Type: TypeString, Doc: "PEM file of the certificate authorities that server certificates are checked against, the system ones if not set"},
+		{Name: "server_name", Type: TypeString, Doc: "Name sent with SNI and checked in the server certificate, the host of the url if not set"},
+		{Name: "cert", Type: TypeString, Doc: "PEM file of the client certificate sent to servers that ask for one"},
+		{Name: "key", Type: TypeString, Doc: "PEM file of the key of the client certificate"},
+	},
+}
+
+var retrySchema = &Block{
+	Name: "retry",
+	Doc:  "Sends requests that failed again, to another server of the upstream pool if there is one. Only GET, HEAD, OPTIONS, TRACE, PUT and DELETE requests without a body are retried",
+	Properties: []*Property{
+		{Name: "attempts", Type: TypeInt, Default: "3", Doc: "Tries of a request including the first one"},
+		{Name: "statuses", Type: TypeList, Default: "[502, 503, 504]", Doc: "Responses retried like connection errors"},
+		{Name: "backoff", Type: TypeDuration, Default: "50ms", Doc: "Wait before the first retry, doubled for each next one"},
+		{Name: "max_backoff", Type: TypeDuration, Default: "1s", Doc: "Longest wait between retries"},
+	},
+}
+
+var circuitBreakerSchema = &Block{
+	Name: "circuit_breaker",
+	Doc:  "Responds with 503 without contacting the remote server after it failed several requests in a row, until open_timeout passes and a request succeeds again",
+	Properties: []*Property{
+		{Name: "failures", Type: TypeInt, Default: "5", Doc: "Failed requests in a row, errors or 502, 503 and 504 responses, that open the breaker"},
+		{Name: "open_timeout", Type: TypeDuration, Default: "30s", Doc: "Time the breaker stays open before a request is let through to try the server"},
+	},
+}
+
+var respondSchema = &Block{
+	Name: "respond",
+	Doc:  "Sends a configured response, like a health check, robots.txt or a maintenance notice",
+	Properties: append([]*Property{
+		{Name: "status", Type: TypeInt, Default: "200", Doc: "Status of the response"},
+		{Name: "headers", Type: TypeMap, Doc: "Headers of the response as 'Name: value', Content-Type is text/plain if not set"},
+		{Name: "body", Type: TypeString, Doc: "Go template of the body with .Method, .Path, .LocalPath, .Query, .Headers, .Host and .RemoteAddr. Values are escaped for html, JSON and XML content types"},
+	}, endpointProperties...),
+}
+
+var httpsRedirectSchema = &Block{
+	Name: "https_redirect",
+	Doc:  "Redirects requests made over http to the same url with https. Requests with 'X-Forwarded-Proto: https' from a trusted proxy in front are served, as are ACME challenges",
+	Properties: []*Property{
+		{Name: "status", Type: TypeInt, Default: "308", Doc: "Status of the redirect: 301, 302, 307 or 308"},
+		{Name: "port", Type: TypeInt, Default: "443", Doc: "Port of the https server"},
+		{Name: "trusted_proxies", Type: TypeList, Doc: "Addresses or networks like '10.0.0.0/8' of proxies that terminate TLS, X-Forwarded-Proto is ignored for other clients"},
+	},
+}
+
+var healthCheckSchema = &Block{
+	Name: "health_check",
+	Doc:  "Requests sent to every server of the pool on an interval, servers that fail them get no traffic until they pass again",
+	Properties: []*Property{
+		{Name: "path", Type: TypeString, Doc: "Path that is requested, like /health"},
+		{Name: "interval", Type: TypeDuration, Default: "10s", Doc: "Time between checks"},
+		{Name: "timeout", Type: TypeDuration, Default: "5s", Doc: "Time a server has to respond to a check"},
+		{Name: "status", Type: TypeInt, Doc: "Expected status, any 2xx status passes if not set"},
+		{Name: "healthy_threshold", Type: TypeInt, Default: "2", Doc: "Checks in a row that a server that is down must pass to get traffic again"},
+		{Name: "unhealthy_threshold", Type: TypeInt, Default: "3", Doc: "Checks in a row that a server must fail to be down"},
+	},
+}
+
+var poolSchema = &Block{
+	Name: "pool",
+	Doc:  "Servers that requests of proxies are balanced between",
+	Properties: []*Property{
+		{Name: "servers", Type: TypeMap, Doc: "URLs of the servers in format [http[s]://][hostname][:port] or unix:/socket/path with their weights, like '\"10.0.0.1:8080\": 2'"},
+		{Name: "balance", Type: TypeString, Default: "round_robin", Doc: "How a server is chosen: round_robin, weighted round robin, least_conn for the fewest requests in progress, random_two for the less loaded of two random servers, or hash for consistent hashing of the client"},
+		{Name: "hash_header", Type: TypeString, Doc: "Header hashed with 'balance: hash' instead of the client address"},
+		{Name: "hash_cookie", Type: TypeString, Doc: "Cookie hashed with 'balance: hash' instead of the client address"},
+		{Name: "health_check", Type: TypeBlock, Doc: healthCheckSchema.Doc, Block: healthCheckSchema},
+		{Name: "max_fails", Type: TypeInt, Keywords: []string{"off"}, Default: "3", Doc: "Failed requests in a row, errors or 502, 503 and 504 responses, after which a server gets no traffic for fail_timeout. 'off' disables it"},
+		{Name: "fail_timeout", Type: TypeDuration, Default: "30s", Doc: "Time a server gets no traffic after max_fails failed requests"},
+		{Name: "tls", Type: TypeBlock, Doc: tlsSchema.Doc + ". They replace the ones of proxies using the pool", Block: tlsSchema},
+	},
+}
+
+var upstreamsSchema = &Block{
+	Name:     "upstreams",
+	Doc:      "Pools of servers that proxies refer to with 'upstream: name', a pool can be shared by several proxies",
+	Key:      "name",
+	Variants: []*Block{poolSchema},
+}
+
+var endpointsSchema = &Block{
+	Name:     "endpoints",
+	Doc:      "Endpoints of the server, requests are handled by the first endpoint whose location is a prefix of the request path",
+	Key:      "location",
+	Variants: []*Block{filesSchema, redirectSchema, proxySchema, respondSchema},
+}
+
+// ServerSchema describes the server block that is the root of a config file
+var ServerSchema = &Block{
+	Name: "server",
+	Doc:  "HTTP server configuration",
+	Properties: []*Property{
+		{Name: "endpoints", Type: TypeBlock, Doc: endpointsSchema.Doc, Block: endpointsSchema},
+		{Name: "upstreams", Type: TypeBlock, Doc: upstreamsSchema.Doc, Block: upstreamsSchema},
+		{Name: "errors", Type: TypeBlock, Doc: errorPagesSchema.Doc, Block: errorPagesSchema},
+		{Name: "https_redirect", Type: TypeBlock, Doc: httpsRedirectSchema.Doc, Block: httpsRedirectSchema},
+	},
+}
@@ -0,0 +1,170 @@
+package appconfig
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/arrowinaknee/switchman/pkg/config"
+)
+
+// Every field that the parser reads must be in the schema. Fields are the cases of switches on
+// 'field' in the reader functions, and variants the ones of switches on 'kind' and 'ep_type'
+func TestSchema_Complete(t *testing.T) {
+	readers := map[string]*Block{
+		"readServer":         ServerSchema,
+		"readHTTPSRedirect":  httpsRedirectSchema,
+		"readEndpoints":      endpointsSchema,
+		"readEndpointOption": {Properties: endpointProperties},
+		"readEpFiles":        filesSchema,
+		"readEpRedirect":     redirectSchema,
+		"readEpProxy":        proxySchema,
+		"readEpRespond":      respondSchema,
+		"readProxyCache":     cacheSchema,
+		"readHeaderRewrite":  headerRewriteSchema,
+		"readProxyTLS":       tlsSchema,
+		"readRetryPolicy":    retrySchema,
+		"readCircuitBreaker": circuitBreakerSchema,
+		"readUpstreamPool":   poolSchema,
+		"readHealthCheck":    healthCheckSchema,
+		"readErrorPages":     errorPagesSchema,
+		"readCompress":       compressSchema,
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "server_config.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range file.Decls {
+		fun, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		ast.Inspect(fun, func(n ast.Node) bool {
+			sw, ok := n.(*ast.SwitchStmt)
+			if !ok {
+				return true
+			}
+			tag, ok := sw.Tag.(*ast.Ident)
+			if !ok || tag.Name != "field" && tag.Name != "kind" && tag.Name != "ep_type" {
+				return true
+			}
+			schema, ok := readers[fun.Name.Name]
+			if !ok {
+				t.Errorf("%s reads fields but has no schema in the test", fun.Name.Name)
+				return false
+			}
+			for _, stmt := range sw.Body.List {
+				for _, expr := range stmt.(*ast.CaseClause).List {
+					lit, ok := expr.(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						continue
+					}
+					name, _ := strconv.Unquote(lit.Value)
+					if tag.Name == "field" && schema.Property(name) == nil {
+						t.Errorf("%s reads property %s that is not in the schema", fun.Name.Name, name)
+					}
+					if tag.Name != "field" && schema.Variant(name) == nil {
+						t.Errorf("%s reads variant %s that is not in the schema", fun.Name.Name, name)
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// Every property in the schema must be recognized by the parser
+func TestSchema_Recognized(t *testing.T) {
+	var check func(path string, b *Block, source func(field string) string)
+	check = func(path string, b *Block, source func(field string) string) {
+		for _, p := range b.Properties {
+			field := p.Name + ": value"
+			if p.Block != nil {
+				field = p.Name + " {}"
+			}
+			t.Run(path+"/"+p.Name, func(t *testing.T) {
+				_, err := ParseServer(strings.NewReader(source(field)))
+				var confErr *config.Error
+				if errors.As(err, &confErr) && strings.Contains(confErr.Msg, "is not a recognized") {
+					t.Errorf("property is not recognized by the parser: %v", err)
+				}
+			})
+		}
+		for _, v := range b.Variants {
+			check(path+"/"+v.Name, v, func(field string) string {
+				return source(fmt.Sprintf("/: %s { %s }", v.Name, field))
+			})
+		}
+		for _, p := range b.Properties {
+			if p.Block != nil {
+				check(path+"/"+p.Name, p.Block, func(field string) string {
+					return source(fmt.Sprintf("%s { %s }", p.Name, field))
+				})
+			}
+		}
+	}
+	check("server", ServerSchema, func(field string) string {
+		return fmt.Sprintf("server { %s }", field)
+	})
+}
+
+// Every property must be documented and have a type matching its value
+func TestSchema_Properties(t *testing.T) {
+	var check func(path string, b *Block)
+	check = func(path string, b *Block) {
+		if b.Doc == "" {
+			t.Errorf("block %s has no doc", path)
+		}
+		for _, p := range b.Properties {
+			if p.Doc == "" || p.Type == "" {
+				t.Errorf("property %s/%s has no doc or type", path, p.Name)
+			}
+			if (p.Type == TypeBlock) != (p.Block != nil) {
+				t.Errorf("property %s/%s has type %s, but block %v", path, p.Name, p.Type, p.Block)
+			}
+			if err := p.CheckValue(p.Default); p.Default != "" && err != nil {
+				t.Errorf("property %s/%s has a default of another type: %v", path, p.Name, err)
+			}
+			if p.Block != nil {
+				check(path+"/"+p.Name, p.Block)
+			}
+		}
+		for _, v := range b.Variants {
+			check(path+"/"+v.Name, v)
+		}
+	}
+	check("server", ServerSchema)
+}
+
+func TestProperty_CheckValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		prop    *Property
+		value   string
+		wantErr bool
+	}{
+		{"int", &Property{Name: "status", Type: TypeInt}, "404", false},
+		{"int_invalid", &Property{Name: "status", Type: TypeInt}, "4o4", true},
+		{"int_keyword", &Property{Name: "keepalive", Type: TypeInt, Keywords: []string{"off"}}, "off", false},
+		{"int_other_word", &Property{Name: "keepalive", Type: TypeInt, Keywords: []string{"off"}}, "none", true},
+		{"duration", &Property{Name: "timeout", Type: TypeDuration}, "1m30s", false},
+		{"duration_no_unit", &Property{Name: "timeout", Type: TypeDuration}, "30", true},
+		{"duration_zero", &Property{Name: "timeout", Type: TypeDuration}, "0s", true},
+		{"size", &Property{Name: "max_size", Type: TypeSize}, "64m", false},
+		{"size_bytes", &Property{Name: "max_size", Type: TypeSize}, "512", false},
+		{"size_invalid", &Property{Name: "max_size", Type: TypeSize}, "64mb", true},
+		{"string", &Property{Name: "path", Type: TypeString}, "anything", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.prop.CheckValue(tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("CheckValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,8 +3,15 @@ package appconfig
 import (
 	"fmt"
 	"io"
+	"math"
+	"net/netip"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/arrowinaknee/switchman/pkg/config"
 	"github.com/arrowinaknee/switchman/pkg/servers/http"
@@ -17,9 +24,29 @@ func ParseServer(source io.Reader) (*http.Server, error) {
 	return readConfig(config.NewReader(source))
 }
 
+// Read a server config from a prepared reader, like one that reports what it reads to a
+// config.Listener
+func ReadServer(conf *config.Reader) (*http.Server, error) {
+	return readConfig(conf)
+}
+
 func readConfig(conf *config.Reader) (server *http.Server, err error) {
-	if err = conf.ReadExact("server"); err != nil {
-		return
+	/*define name {...}
+	server {...}*/
+	var t config.Token
+	for {
+		if t, err = conf.ReadNext(); err != nil {
+			return
+		}
+		if t != "define" {
+			break
+		}
+		if err = conf.ReadDefinition(); err != nil {
+			return
+		}
+	}
+	if t != "server" {
+		return nil, conf.ErrUnexpectedToken("'server' or 'define'")
 	}
 	server, err = readServer(conf)
 	if err != nil {
@@ -34,13 +61,23 @@ func readConfig(conf *config.Reader) (server *http.Server, err error) {
 func readServer(conf *config.Reader) (server *http.Server, err error) {
 	/*server {
 		locations: {...}
+		upstreams {...}
+		errors {...}
+		https_redirect {...}
 	}*/
 	server = &http.Server{}
+	pools := newUpstreamPools()
 
 	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
 		switch field {
 		case "endpoints":
-			server.Endpoints, err = readEndpoints(conf)
+			server.Endpoints, err = readEndpoints(conf, pools)
+		case "upstreams":
+			err = readUpstreams(conf, pools)
+		case "errors":
+			server.Errors, err = readErrorPages(conf)
+		case "https_redirect":
+			server.HTTPSRedirect, err = readHTTPSRedirect(conf)
 		default:
 			err = conf.ErrUnrecognized("server property")
 		}
@@ -49,10 +86,92 @@ func readServer(conf *config.Reader) (server *http.Server, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = pools.check(); err != nil {
+		return nil, err
+	}
+	// proxies take the tls settings of their pools, which can be defined after them
+	if err = initProxies(server); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Create the connection pools of the proxies of the server, including the ones of error pages
+func initProxies(server *http.Server) error {
+	var proxies []*http.EndpointProxy
+	pages := []http.ErrorPages{server.Errors}
+	for _, e := range server.Endpoints {
+		switch f := e.Function.(type) {
+		case *http.EndpointProxy:
+			proxies = append(proxies, f)
+		case *http.EndpointCache:
+			proxies = append(proxies, f.Proxy)
+		}
+		pages = append(pages, e.Errors)
+	}
+	for _, p := range pages {
+		for _, page := range p {
+			if page.Proxy != nil {
+				proxies = append(proxies, page.Proxy)
+			}
+		}
+	}
+	for _, p := range proxies {
+		if err := p.Init(); err != nil {
+			return fmt.Errorf("connections of proxy to '%s://%s' can't be created: %w", p.Proto, p.Host, err)
+		}
+	}
+	return nil
+}
+
+func readHTTPSRedirect(conf *config.Reader) (redirect *http.HTTPSRedirect, err error) {
+	/*https_redirect {
+		status: 301|302|307|308
+		port: 443
+		trusted_proxies: [10.0.0.0/8, 127.0.0.1]
+	}*/
+	redirect = &http.HTTPSRedirect{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var t config.Token
+		switch field {
+		case "status":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			redirect.Status, err = strconv.Atoi(t.String())
+			// 303 would turn every request into GET
+			if err != nil || redirect.Status == 303 || !slices.Contains(http.RedirectStatuses, redirect.Status) {
+				return conf.ErrInvalid("status, expected 301, 302, 307 or 308")
+			}
+		case "port":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			redirect.Port, err = strconv.Atoi(t.String())
+			if err != nil || redirect.Port < 1 || redirect.Port > 65535 {
+				return conf.ErrInvalid("port, expected a number from 1 to 65535")
+			}
+		case "trusted_proxies":
+			redirect.TrustedProxies, err = readTrustedProxies(conf)
+		default:
+			err = conf.ErrUnrecognizedField("https_redirect property")
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
 	return
 }
 
-func readEndpoints(conf *config.Reader) (locations []http.Endpoint, err error) {
+func readEndpoints(conf *config.Reader, pools *upstreamPools) (locations []http.Endpoint, err error) {
 	/*locations{
 		path: endpoint_type {...}
 		...: ...
@@ -78,11 +197,20 @@ func readEndpoints(conf *config.Reader) (locations []http.Endpoint, err error) {
 		}
 		switch ep_type {
 		case "files":
-			endpoint.Function, err = readEpFiles(conf)
+			endpoint.Function, err = readEpFiles(conf, &endpoint)
 		case "redirect":
-			endpoint.Function, err = readEpRedirect(conf)
+			endpoint.Function, err = readEpRedirect(conf, &endpoint)
 		case "proxy":
-			endpoint.Function, err = readEpProxy(conf)
+			var proxy *http.EndpointProxy
+			var cache *http.EndpointCache
+			proxy, cache, err = readEpProxy(conf, &endpoint, pools)
+			endpoint.Function = proxy
+			if cache != nil {
+				cache.Proxy = proxy
+				endpoint.Function = cache
+			}
+		case "respond":
+			endpoint.Function, err = readEpRespond(conf, &endpoint)
 		default:
 			return conf.ErrUnrecognized("endpoint type")
 		}
@@ -98,13 +226,27 @@ func readEndpoints(conf *config.Reader) (locations []http.Endpoint, err error) {
 	return
 }
 
-func readEpFiles(conf *config.Reader) (fun *http.EndpointFiles, err error) {
+func readEpFiles(conf *config.Reader, endpoint *http.Endpoint) (fun *http.EndpointFiles, err error) {
 	/*files {
 		sources: path
+		cache_control: value
+		index: [name, ...]
+		fallback: path
+		browse: true|false
+		browse_template: path
+		precompressed: [br, zstd, gzip]
+		follow_symlinks: never|within-root|always
+		hidden: deny|unlisted|allow
+		deny: [pattern, ...]
+		compress {...}
+		errors {...}
 	}*/
 	fun = &http.EndpointFiles{}
 
 	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		if ok, err := readEndpointOption(conf, field, endpoint); ok {
+			return err
+		}
 		err = conf.ReadSeparator()
 		if err != nil {
 			return
@@ -116,13 +258,89 @@ func readEpFiles(conf *config.Reader) (fun *http.EndpointFiles, err error) {
 			if err != nil {
 				return
 			}
-			t, err = t.Unescaped()
+			fun.Source = t.String()
+		case "cache_control":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			fun.CacheControl = t.String()
+		case "index":
+			var list []config.Token
+			list, err = conf.ReadList()
 			if err != nil {
-				return err
+				return
+			}
+			fun.Index = []string{}
+			for _, name := range list {
+				if !filepath.IsLocal(name.String()) {
+					return conf.Errorf("index file '%s' must be a path inside of sources", name)
+				}
+				fun.Index = append(fun.Index, name.String())
+			}
+		case "fallback":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if !filepath.IsLocal(t.String()) {
+				return conf.ErrInvalid("fallback, expected a path inside of sources")
+			}
+			fun.Fallback = t.String()
+		case "browse":
+			fun.Browse, err = conf.ReadBool()
+		case "browse_template":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			fun.BrowseTemplate = t.String()
+		case "precompressed":
+			var list []config.Token
+			list, err = conf.ReadList()
+			if err != nil {
+				return
+			}
+			fun.Precompressed = []string{}
+			for _, encoding := range list {
+				if _, ok := http.PrecompressedExtensions[encoding.String()]; !ok {
+					return conf.Errorf("'%s' is not a supported encoding, expected br, zstd or gzip", encoding)
+				}
+				fun.Precompressed = append(fun.Precompressed, encoding.String())
+			}
+		case "follow_symlinks":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if !slices.Contains(http.SymlinkPolicies, t.String()) {
+				return conf.ErrInvalid("follow_symlinks, expected never, within-root or always")
+			}
+			fun.FollowSymlinks = t.String()
+		case "hidden":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if !slices.Contains(http.HiddenPolicies, t.String()) {
+				return conf.ErrInvalid("hidden, expected deny, unlisted or allow")
+			}
+			fun.Hidden = t.String()
+		case "deny":
+			var list []config.Token
+			list, err = conf.ReadList()
+			if err != nil {
+				return
+			}
+			fun.Deny = []string{}
+			for _, pattern := range list {
+				if _, err := path.Match(pattern.String(), ""); err != nil {
+					return conf.Errorf("'%s' is not a valid path pattern", pattern)
+				}
+				fun.Deny = append(fun.Deny, pattern.String())
 			}
-			fun.Source = t.String()
 		default:
-			err = conf.ErrUnrecognized("files endpoint property")
+			err = conf.ErrUnrecognizedField("files endpoint property")
 		}
 		return
 	})
@@ -132,13 +350,22 @@ func readEpFiles(conf *config.Reader) (fun *http.EndpointFiles, err error) {
 	return
 }
 
-func readEpRedirect(conf *config.Reader) (fun *http.EndpointRedirect, err error) {
+func readEpRedirect(conf *config.Reader, endpoint *http.Endpoint) (fun *http.EndpointRedirect, err error) {
 	/*redirect {
-		url: path
+		url: "https://example.com/{{.LocalPath}}"
+		status: 301|302|303|307|308
+		preserve_path: true|false
+		preserve_query: true|false
+		compress {...}
+		errors {...}
 	}*/
 	fun = &http.EndpointRedirect{}
+	var urlPos config.TokenPosition
 
 	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		if ok, err := readEndpointOption(conf, field, endpoint); ok {
+			return err
+		}
 		err = conf.ReadSeparator()
 		if err != nil {
 			return
@@ -150,33 +377,96 @@ func readEpRedirect(conf *config.Reader) (fun *http.EndpointRedirect, err error)
 			if err != nil {
 				return
 			}
-			t, err = t.Unescaped()
+			fun.URL = t.String()
+			urlPos = conf.Position()
+		case "status":
+			t, err = conf.ReadString()
 			if err != nil {
-				return err
+				return
 			}
-			fun.URL = t.String()
+			fun.Status, err = strconv.Atoi(t.String())
+			if err != nil || !slices.Contains(http.RedirectStatuses, fun.Status) {
+				return conf.ErrInvalid("status, expected 301, 302, 303, 307 or 308")
+			}
+		case "preserve_path":
+			fun.PreservePath, err = conf.ReadBool()
+		case "preserve_query":
+			fun.PreserveQuery, err = conf.ReadBool()
 		default:
-			err = conf.ErrUnrecognized("redirect endpoint property")
+			err = conf.ErrUnrecognizedField("redirect endpoint property")
 		}
 		return
 	})
 	if err != nil {
 		return nil, err
 	}
+	if err = fun.Check(); err != nil {
+		return nil, &config.Error{Pos: urlPos, Msg: fmt.Sprintf("invalid url template: %v", err)}
+	}
 	return
 }
 
-func readEpProxy(conf *config.Reader) (fun *http.EndpointProxy, err error) {
+// Read a proxy endpoint, and the cache in front of it if it has one
+func readEpProxy(conf *config.Reader, endpoint *http.Endpoint, pools *upstreamPools) (fun *http.EndpointProxy, cache *http.EndpointCache, err error) {
 	/*proxy {
 		url: "http://example.com:8080/hello"
+		upstream: name
+		keepalive: 32
+		idle_timeout: 90s
+		dial_timeout: 30s
+		response_header_timeout: 1m
+		http2: false
+		preserve_host: false
+		forwarded: trusted
+		trusted_proxies: [10.0.0.0/8, 127.0.0.1]
+		tls {...}
+		rewrite_location: false
+		rewrite_cookies: false
+		cookie_domain: example.com
+		request_headers {...}
+		response_headers {...}
+		timeout: 30s
+		upgrade_idle_timeout: 5m|off
+		flush_interval: 100ms|immediate
+		retry {...}
+		circuit_breaker {...}
+		cache {...}
+		compress {...}
+		errors {...}
 	}*/
 	fun = &http.EndpointProxy{
 		Proto: "http",
 		Host:  "localhost:80",
 		Path:  "/",
 	}
+	var forwardedPos config.TokenPosition
+	var url string
+	var urlPos config.TokenPosition
 
 	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		if ok, err := readEndpointOption(conf, field, endpoint); ok {
+			return err
+		}
+		switch field {
+		case "retry":
+			fun.Retry, err = readRetryPolicy(conf)
+			return
+		case "circuit_breaker":
+			fun.CircuitBreaker, err = readCircuitBreaker(conf)
+			return
+		case "cache":
+			cache, err = readProxyCache(conf)
+			return
+		case "tls":
+			fun.TLS, err = readProxyTLS(conf)
+			return
+		case "request_headers":
+			fun.RequestHeaders, err = readHeaderRewrite(conf)
+			return
+		case "response_headers":
+			fun.ResponseHeaders, err = readHeaderRewrite(conf)
+			return
+		}
 		err = conf.ReadSeparator()
 		if err != nil {
 			return
@@ -184,79 +474,1012 @@ func readEpProxy(conf *config.Reader) (fun *http.EndpointProxy, err error) {
 		var t config.Token
 		switch field {
 		case "url":
-			const url_err_text = "malformed or unsupported url"
 			t, err = conf.ReadString()
 			if err != nil {
 				return
 			}
-			t, err = t.Unescaped()
+			url, urlPos = t.String(), conf.Position()
+			err = parseProxyUrl(conf, url, fun)
+		case "upstream":
+			t, err = conf.ReadName()
 			if err != nil {
-				return err
+				return
 			}
-			url := t.String()
-
-			if len(url) == 0 {
-				return conf.Errorf("%s, expected url format: [http://][hostname][:port][/path]", url_err_text)
+			fun.Upstream = pools.ref(t.String(), conf.Position())
+		case "keepalive":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
 			}
-			match := urlRegexp.FindStringSubmatch(url)
-			if len(match) == 0 {
-				return conf.Errorf("%s, expected url format: [http://][hostname][:port][/path]", url_err_text)
+			if t == "off" {
+				fun.Keepalive = -1
+				return
 			}
-			result := make(map[string]string)
-			for i, name := range urlRegexp.SubexpNames() {
-				if i != 0 && name != "" {
-					result[name] = match[i]
-				}
+			fun.Keepalive, err = strconv.Atoi(t.String())
+			if err != nil || fun.Keepalive < 1 {
+				return conf.ErrInvalid("keepalive, expected a number of connections or 'off'")
 			}
-
-			proto := ""
-			host := ""
-			port := ""
-			path := ""
-
-			if proto = result["proto"]; proto != "" {
-				if proto != "http" {
-					return conf.Errorf("%s: %s protocol is not supported", url_err_text, proto)
-				}
-				fun.Proto = proto
+		case "idle_timeout":
+			fun.IdleTimeout, err = readDuration(conf)
+		case "dial_timeout":
+			fun.DialTimeout, err = readDuration(conf)
+		case "response_header_timeout":
+			fun.ResponseHeaderTimeout, err = readDuration(conf)
+		case "http2":
+			fun.HTTP2, err = conf.ReadBool()
+		case "preserve_host":
+			fun.PreserveHost, err = conf.ReadBool()
+		case "forwarded":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
 			}
-			if host = result["hostname"]; host != "" {
-				if !hostRegexp.MatchString(host) {
-					return conf.Errorf("%s: invalid hostname %s", url_err_text, host)
-				}
+			if !slices.Contains(http.ForwardedPolicies, t.String()) {
+				return conf.ErrInvalid("forwarded headers policy, expected replace, append or trusted")
 			}
-			if port = result["port"]; port != "" {
-				portn, err := strconv.Atoi(port[1:])
-				if err != nil || portn > 65535 {
-					return fmt.Errorf("%s: port must be a number from 1 to 65535", url_err_text)
-				}
+			fun.Forwarded = t.String()
+			forwardedPos = conf.Position()
+		case "trusted_proxies":
+			fun.TrustedProxies, err = readTrustedProxies(conf)
+		case "timeout":
+			fun.Timeout, err = readDuration(conf)
+		case "rewrite_location":
+			fun.RewriteLocation, err = conf.ReadBool()
+		case "rewrite_cookies":
+			fun.RewriteCookies, err = conf.ReadBool()
+		case "cookie_domain":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if !hostRegexp.MatchString(strings.TrimPrefix(t.String(), ".")) {
+				return conf.ErrInvalid("cookie_domain, expected a domain")
+			}
+			fun.CookieDomain = t.String()
+		case "flush_interval":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if t == "immediate" {
+				fun.FlushInterval = http.FlushImmediately
+				return
+			}
+			fun.FlushInterval, err = time.ParseDuration(t.String())
+			if err != nil || fun.FlushInterval <= 0 {
+				return conf.ErrInvalid("flush_interval, expected a duration like '100ms' or 'immediate'")
+			}
+		case "upgrade_idle_timeout":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if t == "off" {
+				fun.UpgradeIdleTimeout = -1
+				return
+			}
+			fun.UpgradeIdleTimeout, err = time.ParseDuration(t.String())
+			if err != nil || fun.UpgradeIdleTimeout <= 0 {
+				return conf.ErrInvalid("upgrade_idle_timeout, expected a duration like '5m' or 'off'")
 			}
-			path = result["path"]
+		default:
+			err = conf.ErrUnrecognizedField("proxy endpoint property")
+		}
+		return
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if fun.Forwarded == http.ForwardedTrusted && len(fun.TrustedProxies) == 0 {
+		return nil, nil, &config.Error{Pos: forwardedPos, Msg: "forwarded: trusted requires a list of trusted_proxies"}
+	}
+	if fun.Upstream != nil && url != "" && !strings.HasPrefix(url, "/") {
+		return nil, nil, &config.Error{Pos: urlPos, Msg: "url of a proxy with an upstream pool can only have a path"}
+	}
+	return
+}
+
+func readEpRespond(conf *config.Reader, endpoint *http.Endpoint) (fun *http.EndpointRespond, err error) {
+	/*respond {
+		status: 200
+		headers {
+			Content-Type: text/plain
+		}
+		body: "Hello from {{.Path}}"
+		compress {...}
+		errors {...}
+	}*/
+	fun = &http.EndpointRespond{}
+	var bodyPos config.TokenPosition
 
-			if query := result["query"]; query != "" {
-				return fmt.Errorf("%s: query is not allowed in proxy url", url_err_text)
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		if ok, err := readEndpointOption(conf, field, endpoint); ok {
+			return err
+		}
+		if field == "headers" {
+			fun.Headers, err = readHeaders(conf)
+			return
+		}
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var t config.Token
+		switch field {
+		case "status":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
 			}
-			if fragment := result["fragment"]; fragment != "" {
-				return fmt.Errorf("%s: fragment is not allowed in proxy url", url_err_text)
+			fun.Status, err = strconv.Atoi(t.String())
+			if err != nil || fun.Status < 100 || fun.Status > 599 {
+				return conf.ErrInvalid("status, expected a number from 100 to 599")
+			}
+		case "body":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
 			}
+			fun.Body = t.String()
+			bodyPos = conf.Position()
+		default:
+			err = conf.ErrUnrecognizedField("respond endpoint property")
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the content type may be set after the body, so the template is checked at the end
+	if err = fun.Check(); err != nil {
+		return nil, &config.Error{Pos: bodyPos, Msg: fmt.Sprintf("invalid body template: %v", err)}
+	}
+	return
+}
+
+var headerNameRegexp = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+func readHeaders(conf *config.Reader) (headers map[string]string, err error) {
+	/*headers {
+		Name: value
+	}*/
+	headers = map[string]string{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		name, err := field.Unescaped()
+		if err != nil {
+			return
+		}
+		if !headerNameRegexp.MatchString(name.String()) {
+			return conf.ErrInvalid("header name")
+		}
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		t, err := conf.ReadString()
+		if err != nil {
+			return
+		}
+		if strings.ContainsAny(t.String(), "\r\n") {
+			return conf.ErrInvalid("header value, line breaks are not allowed")
+		}
+		headers[name.String()] = t.String()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func readHeaderRewrite(conf *config.Reader) (rewrite *http.HeaderRewrite, err error) {
+	/*request_headers {
+		set {
+			Name: value
+		}
+		add {
+			Name: value
+		}
+		remove: [Name, ...]
+	}*/
+	rewrite = &http.HeaderRewrite{}
 
-			if proto != "" {
-				fun.Proto = proto
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		switch field {
+		case "set":
+			rewrite.Set, err = readHeaders(conf)
+			return
+		case "add":
+			rewrite.Add, err = readHeaders(conf)
+			return
+		}
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		switch field {
+		case "remove":
+			var list []config.Token
+			list, err = conf.ReadList()
+			if err != nil {
+				return
 			}
-			if host != "" || port != "" {
-				if host == "" {
-					host = "localhost"
+			rewrite.Remove = []string{}
+			for _, name := range list {
+				if !headerNameRegexp.MatchString(name.String()) {
+					return conf.Errorf("'%s' is not a valid header name", name)
 				}
-				if port == "" {
-					port = ":80"
-				}
-				fun.Host = host + port
+				rewrite.Remove = append(rewrite.Remove, name.String())
 			}
-			if path != "" {
-				fun.Path = path
+		default:
+			err = conf.ErrUnrecognizedField("header rewrite property")
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Upstream pools of a server. Proxies can refer to pools that are defined after them, so the
+// references are checked once the whole server is read
+type upstreamPools struct {
+	pools   map[string]*http.UpstreamPool
+	defined map[string]bool
+	// position of the first reference to each pool
+	refs map[string]config.TokenPosition
+}
+
+func newUpstreamPools() *upstreamPools {
+	return &upstreamPools{
+		pools:   map[string]*http.UpstreamPool{},
+		defined: map[string]bool{},
+		refs:    map[string]config.TokenPosition{},
+	}
+}
+
+// Pool with the name, it is filled in when its definition is read
+func (u *upstreamPools) get(name string) *http.UpstreamPool {
+	pool, ok := u.pools[name]
+	if !ok {
+		pool = &http.UpstreamPool{Name: name}
+		u.pools[name] = pool
+	}
+	return pool
+}
+
+func (u *upstreamPools) ref(name string, pos config.TokenPosition) *http.UpstreamPool {
+	if _, ok := u.refs[name]; !ok {
+		u.refs[name] = pos
+	}
+	return u.get(name)
+}
+
+// Error for the first reference to a pool that is not defined
+func (u *upstreamPools) check() error {
+	var err *config.Error
+	for name, pos := range u.refs {
+		if u.defined[name] {
+			continue
+		}
+		if err == nil || pos.Line < err.Pos.Line || pos.Line == err.Pos.Line && pos.Col < err.Pos.Col {
+			err = &config.Error{Pos: pos, Msg: fmt.Sprintf("upstream pool '%s' is not defined in upstreams", name)}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func readUpstreams(conf *config.Reader, pools *upstreamPools) error {
+	/*upstreams {
+		name: pool {...}
+	}*/
+	return conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		name, err := field.Unescaped()
+		if err != nil {
+			return
+		}
+		if pools.defined[name.String()] {
+			return conf.Errorf("upstream pool '%s' is already defined", name)
+		}
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		kind, err := conf.ReadName()
+		if err != nil {
+			return
+		}
+		if kind != "pool" {
+			return conf.ErrUnrecognized("upstream type")
+		}
+		pools.defined[name.String()] = true
+		return readUpstreamPool(conf, pools.get(name.String()))
+	})
+}
+
+func readUpstreamPool(conf *config.Reader, pool *http.UpstreamPool) (err error) {
+	/*pool {
+		servers {
+			"10.0.0.1:8080": 3
+			"10.0.0.2:8080": 1
+		}
+		balance: round_robin|weighted|least_conn|random_two|hash
+		hash_header: X-User
+		hash_cookie: session
+		health_check {...}
+		tls {...}
+		max_fails: 3|off
+		fail_timeout: 30s
+	}*/
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		switch field {
+		case "servers":
+			pool.Servers, err = readUpstreamServers(conf)
+			return
+		case "health_check":
+			pool.HealthCheck, err = readHealthCheck(conf)
+			return
+		case "tls":
+			pool.TLS, err = readProxyTLS(conf)
+			return
+		}
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var t config.Token
+		switch field {
+		case "balance":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if !slices.Contains(http.BalancePolicies, t.String()) {
+				return conf.ErrInvalid("balance policy, expected round_robin, weighted, least_conn, random_two or hash")
+			}
+			pool.Balance = t.String()
+		case "hash_header":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if !headerNameRegexp.MatchString(t.String()) {
+				return conf.ErrInvalid("header name")
+			}
+			pool.HashHeader = t.String()
+		case "hash_cookie":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if t == "" {
+				return conf.ErrInvalid("cookie name")
+			}
+			pool.HashCookie = t.String()
+		case "max_fails":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if t == "off" {
+				pool.MaxFails = -1
+				return
+			}
+			pool.MaxFails, err = strconv.Atoi(t.String())
+			if err != nil || pool.MaxFails < 1 {
+				return conf.ErrInvalid("max_fails, expected a number of failed requests or 'off'")
+			}
+		case "fail_timeout":
+			pool.FailTimeout, err = readDuration(conf)
+		default:
+			err = conf.ErrUnrecognizedField("upstream pool property")
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	if len(pool.Servers) == 0 {
+		return conf.Errorf("upstream pool '%s' has no servers", pool.Name)
+	}
+	if pool.HashHeader != "" && pool.HashCookie != "" {
+		return conf.Errorf("upstream pool '%s' can hash either a header or a cookie", pool.Name)
+	}
+	return
+}
+
+func readHealthCheck(conf *config.Reader) (check *http.HealthCheck, err error) {
+	/*health_check {
+		path: /health
+		interval: 10s
+		timeout: 5s
+		status: 200
+		healthy_threshold: 2
+		unhealthy_threshold: 3
+	}*/
+	check = &http.HealthCheck{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var t config.Token
+		switch field {
+		case "path":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(t.String(), "/") {
+				return conf.ErrInvalid("path, expected a path starting with '/'")
+			}
+			check.Path = t.String()
+		case "interval":
+			check.Interval, err = readDuration(conf)
+		case "timeout":
+			check.Timeout, err = readDuration(conf)
+		case "status":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			check.Status, err = strconv.Atoi(t.String())
+			if err != nil || check.Status < 100 || check.Status > 599 {
+				return conf.ErrInvalid("status, expected a number from 100 to 599")
+			}
+		case "healthy_threshold":
+			check.HealthyThreshold, err = readThreshold(conf)
+		case "unhealthy_threshold":
+			check.UnhealthyThreshold, err = readThreshold(conf)
+		default:
+			err = conf.ErrUnrecognizedField("health_check property")
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if check.Path == "" {
+		return nil, conf.Errorf("health_check requires a path")
+	}
+	return
+}
+
+func readProxyTLS(conf *config.Reader) (settings *http.ProxyTLS, err error) {
+	/*tls {
+		ca: /etc/ssl/upstream-ca.pem
+		server_name: backend.internal
+		cert: /etc/ssl/client.pem
+		key: /etc/ssl/client-key.pem
+	}*/
+	settings = &http.ProxyTLS{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		var value *string
+		switch field {
+		case "ca":
+			value = &settings.CA
+		case "server_name":
+			value = &settings.ServerName
+		case "cert":
+			value = &settings.Cert
+		case "key":
+			value = &settings.Key
+		default:
+			return conf.ErrUnrecognizedField("tls property")
+		}
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		t, err := conf.ReadString()
+		if err != nil {
+			return
+		}
+		if field == "server_name" && !hostRegexp.MatchString(t.String()) {
+			return conf.ErrInvalid("server_name, expected a hostname")
+		}
+		*value = t.String()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if (settings.Cert == "") != (settings.Key == "") {
+		return nil, conf.Errorf("client certificate of tls requires both cert and key")
+	}
+	// files are read now so that a broken config is not accepted
+	err = settings.Load()
+	if err != nil {
+		return nil, conf.Errorf("files of tls can't be loaded: %v", err)
+	}
+	return
+}
+
+func readRetryPolicy(conf *config.Reader) (retry *http.RetryPolicy, err error) {
+	/*retry {
+		attempts: 3
+		statuses: [502, 503, 504]
+		backoff: 50ms
+		max_backoff: 1s
+	}*/
+	retry = &http.RetryPolicy{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var t config.Token
+		switch field {
+		case "attempts":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			retry.Attempts, err = strconv.Atoi(t.String())
+			if err != nil || retry.Attempts < 1 {
+				return conf.ErrInvalid("attempts, expected a positive number")
+			}
+		case "statuses":
+			var list []config.Token
+			list, err = conf.ReadList()
+			if err != nil {
+				return
+			}
+			retry.Statuses = []int{}
+			for _, t := range list {
+				status, err := strconv.Atoi(t.String())
+				if err != nil || status < 400 || status > 599 {
+					return conf.Errorf("'%s' is not a valid status to retry, expected a number from 400 to 599", t)
+				}
+				retry.Statuses = append(retry.Statuses, status)
+			}
+		case "backoff":
+			retry.Backoff, err = readDuration(conf)
+		case "max_backoff":
+			retry.MaxBackoff, err = readDuration(conf)
+		default:
+			err = conf.ErrUnrecognizedField("retry property")
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if retry.Backoff != 0 && retry.MaxBackoff != 0 && retry.MaxBackoff < retry.Backoff {
+		return nil, conf.Errorf("max_backoff of retry must not be less than backoff")
+	}
+	return
+}
+
+func readCircuitBreaker(conf *config.Reader) (breaker *http.CircuitBreaker, err error) {
+	/*circuit_breaker {
+		failures: 5
+		open_timeout: 30s
+	}*/
+	breaker = &http.CircuitBreaker{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var t config.Token
+		switch field {
+		case "failures":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			breaker.Failures, err = strconv.Atoi(t.String())
+			if err != nil || breaker.Failures < 1 {
+				return conf.ErrInvalid("failures, expected a positive number of requests")
+			}
+		case "open_timeout":
+			breaker.OpenTimeout, err = readDuration(conf)
+		default:
+			err = conf.ErrUnrecognizedField("circuit_breaker property")
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func readProxyCache(conf *config.Reader) (cache *http.EndpointCache, err error) {
+	/*cache {
+		key: [host, path, query, "header:Accept-Language", "cookie:lang"]
+		max_size: 64m
+		max_entry_size: 1m
+		dir: /var/cache/switchman
+		disk_max_size: 1g
+		default_ttl: 1m
+		stale_while_revalidate: 30s
+	}*/
+	cache = &http.EndpointCache{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var t config.Token
+		switch field {
+		case "key":
+			var list []config.Token
+			list, err = conf.ReadList()
+			if err != nil {
+				return
+			}
+			cache.Key = []string{}
+			for _, part := range list {
+				name, isHeader := strings.CutPrefix(part.String(), http.CacheKeyHeader)
+				if !isHeader {
+					name, isHeader = strings.CutPrefix(part.String(), http.CacheKeyCookie)
+				}
+				if isHeader && !headerNameRegexp.MatchString(name) || !isHeader && !slices.Contains(http.CacheKeyParts, part.String()) {
+					return conf.Errorf("'%s' is not a valid cache key part, expected scheme, host, path, query, header:Name or cookie:name", part)
+				}
+				cache.Key = append(cache.Key, part.String())
+			}
+		case "max_size":
+			cache.MaxSize, err = readSize(conf)
+		case "max_entry_size":
+			cache.MaxEntrySize, err = readSize(conf)
+		case "dir":
+			t, err = conf.ReadString()
+			cache.Dir = t.String()
+		case "disk_max_size":
+			cache.DiskMaxSize, err = readSize(conf)
+		case "default_ttl":
+			cache.DefaultTTL, err = readDuration(conf)
+		case "stale_while_revalidate":
+			cache.StaleWhileRevalidate, err = readDuration(conf)
+		default:
+			err = conf.ErrUnrecognizedField("cache property")
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+var sizeRegexp = regexp.MustCompile(`^([0-9]+)([kmgKMG]?)$`)
+
+// Read a size in bytes like '512', '64k', '16m' or '1g'
+func readSize(conf *config.Reader) (int64, error) {
+	t, err := conf.ReadString()
+	if err != nil {
+		return 0, err
+	}
+	size, ok := parseSize(t.String())
+	if !ok {
+		return 0, conf.ErrInvalid("size, expected a number of bytes like '512' or with a unit like '64k', '16m' or '1g'")
+	}
+	return size, nil
+}
+
+func parseSize(s string) (int64, bool) {
+	m := sizeRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(m[1], 10, 64)
+	shift := map[string]int{"": 0, "k": 10, "m": 20, "g": 30}[strings.ToLower(m[2])]
+	if err != nil || size <= 0 || size > math.MaxInt64>>shift {
+		return 0, false
+	}
+	return size << shift, true
+}
+
+func readThreshold(conf *config.Reader) (int, error) {
+	t, err := conf.ReadString()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(t.String())
+	if err != nil || n < 1 {
+		return 0, conf.ErrInvalid("threshold, expected a positive number of checks")
+	}
+	return n, nil
+}
+
+func readUpstreamServers(conf *config.Reader) (servers []*http.Upstream, err error) {
+	/*servers {
+		"http://host:port": weight
+	}*/
+	servers = []*http.Upstream{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		url, err := field.Unescaped()
+		if err != nil {
+			return
+		}
+		target := &http.EndpointProxy{Proto: "http", Host: "localhost:80", Path: "/"}
+		if err = parseProxyUrl(conf, url.String(), target); err != nil {
+			return
+		}
+		if target.Path != "/" {
+			return conf.Errorf("servers of an upstream pool can't have a path, it is set by the proxy")
+		}
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		t, err := conf.ReadString()
+		if err != nil {
+			return
+		}
+		weight, err := strconv.Atoi(t.String())
+		if err != nil || weight < 1 {
+			return conf.ErrInvalid("weight, expected a positive number")
+		}
+		servers = append(servers, &http.Upstream{Proto: target.Proto, Host: target.Host, Weight: weight})
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Read a duration like '30s' or '1m30s'
+func readDuration(conf *config.Reader) (time.Duration, error) {
+	t, err := conf.ReadString()
+	if err != nil {
+		return 0, err
+	}
+	d, ok := parseDuration(t.String())
+	if !ok {
+		return 0, conf.ErrInvalid("duration, expected a value like '30s' or '1m30s'")
+	}
+	return d, nil
+}
+
+func parseDuration(s string) (time.Duration, bool) {
+	d, err := time.ParseDuration(s)
+	return d, err == nil && d > 0
+}
+
+// Read a list of addresses and networks of proxies
+func readTrustedProxies(conf *config.Reader) ([]netip.Prefix, error) {
+	list, err := conf.ReadList()
+	if err != nil {
+		return nil, err
+	}
+	proxies := []netip.Prefix{}
+	for _, t := range list {
+		prefix, err := parseIPPrefix(t.String())
+		if err != nil {
+			return nil, conf.Errorf("'%s' is not a valid IP address or network", t)
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies, nil
+}
+
+// Parse a network like '10.0.0.0/8' or a single address
+func parseIPPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Parse the url of a proxy into its fields, parts missing in the url are left as they are
+func parseProxyUrl(conf *config.Reader, url string, fun *http.EndpointProxy) error {
+	const url_err_text = "malformed or unsupported url"
+	const url_format = "[http[s]://][hostname][:port][/path] or unix:/socket/path[:/path]"
+	if len(url) == 0 {
+		return conf.Errorf("%s, expected url format: %s", url_err_text, url_format)
+	}
+	if socket, ok := strings.CutPrefix(url, "unix:"); ok {
+		socket, path, hasPath := strings.Cut(socket, ":")
+		if socket == "" {
+			return conf.Errorf("%s: unix url requires the path of the socket", url_err_text)
+		}
+		if hasPath && !strings.HasPrefix(path, "/") {
+			return conf.Errorf("%s: path after the socket must start with '/'", url_err_text)
+		}
+		fun.Proto = "unix"
+		fun.Host = socket
+		if path != "" {
+			fun.Path = path
+		}
+		return nil
+	}
+	match := urlRegexp.FindStringSubmatch(url)
+	if len(match) == 0 {
+		return conf.Errorf("%s, expected url format: %s", url_err_text, url_format)
+	}
+	result := make(map[string]string)
+	for i, name := range urlRegexp.SubexpNames() {
+		if i != 0 && name != "" {
+			result[name] = match[i]
+		}
+	}
+
+	proto := ""
+	host := ""
+	port := ""
+	path := ""
+
+	if proto = result["proto"]; proto != "" {
+		if proto != "http" && proto != "https" {
+			return conf.Errorf("%s: %s protocol is not supported", url_err_text, proto)
+		}
+		fun.Proto = proto
+	}
+	if host = result["hostname"]; host != "" {
+		if !hostRegexp.MatchString(host) {
+			return conf.Errorf("%s: invalid hostname %s", url_err_text, host)
+		}
+	}
+	if port = result["port"]; port != "" {
+		portn, err := strconv.Atoi(port[1:])
+		if err != nil || portn > 65535 {
+			return conf.Errorf("%s: port must be a number from 1 to 65535", url_err_text)
+		}
+	}
+	path = result["path"]
+
+	if query := result["query"]; query != "" {
+		return conf.Errorf("%s: query is not allowed in proxy url", url_err_text)
+	}
+	if fragment := result["fragment"]; fragment != "" {
+		return conf.Errorf("%s: fragment is not allowed in proxy url", url_err_text)
+	}
+
+	if proto != "" {
+		fun.Proto = proto
+	}
+	if host != "" || port != "" {
+		if host == "" {
+			host = "localhost"
+		}
+		if port == "" {
+			port = ":80"
+			if fun.Proto == "https" {
+				port = ":443"
+			}
+		}
+		fun.Host = host + port
+	}
+	if path != "" {
+		fun.Path = path
+	}
+	return nil
+}
+
+// Read a property that all endpoint types have, ok is false if the field is not one of them
+func readEndpointOption(conf *config.Reader, field config.Token, endpoint *http.Endpoint) (ok bool, err error) {
+	switch field {
+	case "compress":
+		endpoint.Compress, err = readCompress(conf)
+		return true, err
+	case "errors":
+		endpoint.Errors, err = readErrorPages(conf)
+		return true, err
+	}
+	return false, nil
+}
+
+var errorStatusRegexp = regexp.MustCompile(`^(?:[45][0-9][0-9]|[45]xx)$`)
+
+func readErrorPages(conf *config.Reader) (pages http.ErrorPages, err error) {
+	/*errors {
+		404: file "404.html"
+		4xx: template "error.html"
+		5xx: proxy "localhost:8000/error"
+	}*/
+	pages = http.ErrorPages{}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		status, err := field.Unescaped()
+		if err != nil {
+			return
+		}
+		if !errorStatusRegexp.MatchString(status.String()) {
+			return conf.ErrInvalid("status, expected an error status like 404 or a class like 5xx")
+		}
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var kind, t config.Token
+		kind, err = conf.ReadName()
+		if err != nil {
+			return
+		}
+		page := &http.ErrorPage{}
+		switch kind {
+		case "file":
+			t, err = conf.ReadString()
+			page.File = t.String()
+		case "template":
+			t, err = conf.ReadString()
+			page.Template = t.String()
+		case "proxy":
+			t, err = conf.ReadString()
+			if err != nil {
+				return
+			}
+			page.Proxy = &http.EndpointProxy{Proto: "http", Host: "localhost:80", Path: "/"}
+			err = parseProxyUrl(conf, t.String(), page.Proxy)
+		default:
+			return conf.ErrUnrecognized("error page type")
+		}
+		if err != nil {
+			return
+		}
+		pages[status.String()] = page
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func readCompress(conf *config.Reader) (c *http.Compression, err error) {
+	/*compress {
+		encodings: [br, gzip, deflate]
+		min_size: 1k
+		types: [text/*, application/json]
+	}*/
+	c = &http.Compression{MinSize: 1024}
+
+	err = conf.ReadStruct(func(conf *config.Reader, field config.Token) (err error) {
+		err = conf.ReadSeparator()
+		if err != nil {
+			return
+		}
+		var list []config.Token
+		switch field {
+		case "encodings":
+			list, err = conf.ReadList()
+			if err != nil {
+				return
+			}
+			c.Encodings = []string{}
+			for _, encoding := range list {
+				if _, ok := http.CompressEncodings[encoding.String()]; !ok {
+					return conf.Errorf("'%s' is not a supported encoding, expected br, gzip or deflate", encoding)
+				}
+				c.Encodings = append(c.Encodings, encoding.String())
+			}
+		case "min_size":
+			c.MinSize, err = readSize(conf)
+		case "types":
+			list, err = conf.ReadList()
+			if err != nil {
+				return
+			}
+			c.Types = []string{}
+			for _, pattern := range list {
+				if _, err := path.Match(pattern.String(), ""); err != nil || !strings.Contains(pattern.String(), "/") {
+					return conf.Errorf("'%s' is not a valid media type pattern", pattern)
+				}
+				c.Types = append(c.Types, pattern.String())
 			}
 		default:
-			err = conf.ErrUnrecognized("proxy endpoint property")
+			err = conf.ErrUnrecognizedField("compress property")
 		}
 		return
 	})
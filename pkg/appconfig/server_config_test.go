@@ -1,14 +1,72 @@
 package appconfig
 
 import (
+	"net/netip"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/arrowinaknee/switchman/pkg/config"
 	"github.com/arrowinaknee/switchman/pkg/servers/http"
 )
 
+// Compare values read from configs. Unexported fields of the server types are state made from
+// the settings, like loaded files and connection pools, and are not compared
+func equalConfig(a, b any) bool {
+	return equalValue(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+func equalValue(a, b reflect.Value) bool {
+	if a.IsValid() != b.IsValid() {
+		return false
+	}
+	if !a.IsValid() {
+		return true
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equalValue(a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		for _, k := range a.MapKeys() {
+			if v := b.MapIndex(k); !v.IsValid() || !equalValue(a.MapIndex(k), v) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if a.Type().PkgPath() != reflect.TypeOf(http.Server{}).PkgPath() {
+			return reflect.DeepEqual(a.Interface(), b.Interface())
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).IsExported() && !equalValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
 func TestParseServer(t *testing.T) {
 	type testCase struct {
 		name    string
@@ -39,6 +97,45 @@ func TestParseServer(t *testing.T) {
 				},
 			},
 			wantErr: false,
+		}, {
+			name: "snippets",
+			source: `
+			define site {
+				sources: $dir
+			}
+			define endpoints {
+				/a: files {
+					use site { dir: "/srv/a" }
+				}
+			}
+			server {
+				endpoints {
+					use endpoints
+					/b: files {
+						use site { dir: '/srv/b' }
+					}
+				}
+			}`,
+			result: &http.Server{
+				Endpoints: []http.Endpoint{
+					{Location: "/a", Function: &http.EndpointFiles{Source: "/srv/a"}},
+					{Location: "/b", Function: &http.EndpointFiles{Source: "/srv/b"}},
+				},
+			},
+		}, {
+			name: "snippet_error",
+			source: `
+			define site {
+				target: $dir
+			}
+			server {
+				endpoints {
+					/b: files {
+						use site { dir: '/srv/b' }
+					}
+				}
+			}`,
+			wantErr: true,
 		}, {
 			name: "missing_parameter",
 			source: `
@@ -84,9 +181,12 @@ func TestParseServer(t *testing.T) {
 			if err != nil {
 				return
 			}
-			if !reflect.DeepEqual(got, tt.result) {
+			if !equalConfig(got, tt.result) {
 				t.Errorf("ParseServerConfig() = %v, want %v", got, tt.result)
 			}
+			if !tt.wantErr {
+				testExampleConversion(t, `%s`, tt.source)
+			}
 		})
 	}
 }
@@ -102,11 +202,105 @@ func Test_readServer(t *testing.T) {
 			name: "full",
 			input: `{
 				endpoints {}
+				errors {
+					404: file "404.html"
+				}
 			}`,
 			want: &http.Server{
 				Endpoints: nil,
+				Errors:    http.ErrorPages{"404": {File: "404.html"}},
+			},
+			wantErr: false,
+		}, {
+			name: "https_redirect",
+			input: `{
+				https_redirect {
+					status: 301
+					port: 8443
+					trusted_proxies: [10.0.0.0/8, 127.0.0.1]
+				}
+			}`,
+			want: &http.Server{
+				HTTPSRedirect: &http.HTTPSRedirect{Status: 301, Port: 8443, TrustedProxies: []netip.Prefix{
+					netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("127.0.0.1/32"),
+				}},
 			},
 			wantErr: false,
+		}, {
+			name: "https_redirect_see_other",
+			input: `{
+				https_redirect {
+					status: 303
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "upstreams",
+			input: `{
+				endpoints {
+					/api: proxy {
+						url: /v1
+						upstream: backend
+					}
+				}
+				upstreams {
+					backend: pool {
+						servers {
+							"10.0.0.1:8080": 1
+						}
+					}
+				}
+			}`,
+			want: &http.Server{
+				Endpoints: []http.Endpoint{{Location: "/api", Function: &http.EndpointProxy{
+					Proto: "http",
+					Host:  "localhost:80",
+					Path:  "/v1",
+					Upstream: &http.UpstreamPool{
+						Name:    "backend",
+						Servers: []*http.Upstream{{Proto: "http", Host: "10.0.0.1:8080", Weight: 1}},
+					},
+				}}},
+			},
+		}, {
+			name: "upstream_undefined",
+			input: `{
+				endpoints {
+					/api: proxy {
+						upstream: backend
+					}
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "upstream_with_host",
+			input: `{
+				endpoints {
+					/api: proxy {
+						url: "example.com/v1"
+						upstream: backend
+					}
+				}
+				upstreams {
+					backend: pool {
+						servers { "10.0.0.1:8080": 1 }
+					}
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "upstream_duplicate",
+			input: `{
+				upstreams {
+					backend: pool {
+						servers { "10.0.0.1:8080": 1 }
+					}
+					backend: pool {
+						servers { "10.0.0.2:8080": 1 }
+					}
+				}
+			}`,
+			wantErr: true,
 		}, {
 			name:    "empty",
 			input:   `{}`,
@@ -128,9 +322,214 @@ func Test_readServer(t *testing.T) {
 				t.Errorf("readServer() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !equalConfig(got, tt.want) {
 				t.Errorf("readServer() = %v, want %v", got, tt.want)
 			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server %s`, tt.input)
+			}
+		})
+	}
+}
+
+// Proxies that refer to the same pool share it, so that balancing takes all of their requests
+// into account
+func Test_readServer_sharedUpstream(t *testing.T) {
+	r := config.NewReader(strings.NewReader(`{
+		endpoints {
+			/a: proxy { upstream: backend }
+			/b: proxy { upstream: backend }
+		}
+		upstreams {
+			backend: pool {
+				servers {
+					"10.0.0.1:8080": 1
+					"10.0.0.2:8080": 1
+				}
+			}
+		}
+	}`))
+	server, err := readServer(r)
+	if err != nil {
+		t.Fatalf("readServer() error = %v", err)
+	}
+	a := server.Endpoints[0].Function.(*http.EndpointProxy).Upstream
+	b := server.Endpoints[1].Function.(*http.EndpointProxy).Upstream
+	if a != b || len(a.Servers) != 2 {
+		t.Errorf("readServer() pools = %v and %v, want the same pool", a, b)
+	}
+}
+
+func Test_readUpstreamPool(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *http.UpstreamPool
+		wantErr bool
+	}{
+		{
+			name: "full",
+			input: `{
+				servers {
+					"10.0.0.1:8080": 3
+					"http://backend.local": 1
+				}
+				balance: hash
+				hash_cookie: session
+			}`,
+			want: &http.UpstreamPool{
+				Servers: []*http.Upstream{
+					{Proto: "http", Host: "10.0.0.1:8080", Weight: 3},
+					{Proto: "http", Host: "backend.local:80", Weight: 1},
+				},
+				Balance:    http.BalanceHash,
+				HashCookie: "session",
+			},
+		}, {
+			name: "tls_and_unix",
+			input: `{
+				servers {
+					"https://10.0.0.1": 1
+					"unix:/run/app.sock": 1
+				}
+				tls {
+					ca: testdata/ca.pem
+				}
+			}`,
+			want: &http.UpstreamPool{
+				Servers: []*http.Upstream{
+					{Proto: "https", Host: "10.0.0.1:443", Weight: 1},
+					{Proto: "unix", Host: "/run/app.sock", Weight: 1},
+				},
+				TLS: &http.ProxyTLS{CA: "testdata/ca.pem"},
+			},
+		}, {
+			name: "unix_with_path",
+			input: `{
+				servers { "unix:/run/app.sock:/api": 1 }
+			}`,
+			wantErr: true,
+		}, {
+			name: "header_key",
+			input: `{
+				servers { ":8080": 1 }
+				hash_header: X-User
+			}`,
+			want: &http.UpstreamPool{
+				Servers:    []*http.Upstream{{Proto: "http", Host: "localhost:8080", Weight: 1}},
+				HashHeader: "X-User",
+			},
+		}, {
+			name: "health",
+			input: `{
+				servers { ":8080": 1 }
+				health_check {
+					path: /health
+					interval: 5s
+					timeout: 1s
+					status: 204
+					healthy_threshold: 1
+					unhealthy_threshold: 2
+				}
+				max_fails: off
+				fail_timeout: 1m
+			}`,
+			want: &http.UpstreamPool{
+				Servers: []*http.Upstream{{Proto: "http", Host: "localhost:8080", Weight: 1}},
+				HealthCheck: &http.HealthCheck{
+					Path:               "/health",
+					Interval:           5 * time.Second,
+					Timeout:            time.Second,
+					Status:             204,
+					HealthyThreshold:   1,
+					UnhealthyThreshold: 2,
+				},
+				MaxFails:    -1,
+				FailTimeout: time.Minute,
+			},
+		}, {
+			name: "health_no_path",
+			input: `{
+				servers { ":8080": 1 }
+				health_check {
+					interval: 5s
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "health_threshold_invalid",
+			input: `{
+				servers { ":8080": 1 }
+				health_check {
+					path: /health
+					healthy_threshold: 0
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "max_fails_invalid",
+			input: `{
+				servers { ":8080": 1 }
+				max_fails: 0
+			}`,
+			wantErr: true,
+		}, {
+			name: "no_servers",
+			input: `{
+				balance: least_conn
+			}`,
+			wantErr: true,
+		}, {
+			name: "server_path",
+			input: `{
+				servers { "10.0.0.1:8080/api": 1 }
+			}`,
+			wantErr: true,
+		}, {
+			name: "weight_invalid",
+			input: `{
+				servers { "10.0.0.1:8080": 0 }
+			}`,
+			wantErr: true,
+		}, {
+			name: "balance_invalid",
+			input: `{
+				servers { "10.0.0.1:8080": 1 }
+				balance: fastest
+			}`,
+			wantErr: true,
+		}, {
+			name: "hash_header_invalid",
+			input: `{
+				servers { "10.0.0.1:8080": 1 }
+				hash_header: "X User"
+			}`,
+			wantErr: true,
+		}, {
+			name: "hash_both",
+			input: `{
+				servers { "10.0.0.1:8080": 1 }
+				hash_header: X-User
+				hash_cookie: session
+			}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := config.NewReader(strings.NewReader(tt.input))
+			got := &http.UpstreamPool{}
+			err := readUpstreamPool(r, got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readUpstreamPool() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !equalConfig(got, tt.want) {
+				t.Errorf("readUpstreamPool() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { upstreams { backend: pool %s } }`, tt.input)
+			}
 		})
 	}
 }
@@ -166,6 +565,39 @@ func Test_readEndpoints(t *testing.T) {
 				},
 			},
 			wantErr: false,
+		}, {
+			name: "compress",
+			input: `{
+				/api: proxy {
+					url: localhost
+					compress {}
+				}
+			}`,
+			want: []http.Endpoint{
+				{
+					Location: "/api",
+					Function: &http.EndpointProxy{Proto: "http", Host: "localhost:80", Path: "/"},
+					Compress: &http.Compression{MinSize: 1024},
+				},
+			},
+			wantErr: false,
+		}, {
+			name: "cache",
+			input: `{
+				/api: proxy {
+					url: localhost
+					cache {}
+				}
+			}`,
+			want: []http.Endpoint{
+				{
+					Location: "/api",
+					Function: &http.EndpointCache{
+						Proxy: &http.EndpointProxy{Proto: "http", Host: "localhost:80", Path: "/"},
+					},
+				},
+			},
+			wantErr: false,
 		}, {
 			name:    "empty",
 			input:   "{}",
@@ -192,14 +624,17 @@ func Test_readEndpoints(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := config.NewReader(strings.NewReader(tt.input))
-			got, err := readEndpoints(r)
+			got, err := readEndpoints(r, newUpstreamPools())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("readEndpoints() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !equalConfig(got, tt.want) {
 				t.Errorf("readEndpoints() = %v, want %v", got, tt.want)
 			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { endpoints %s }`, tt.input)
+			}
 		})
 	}
 }
@@ -215,9 +650,27 @@ func Test_readEpFiles(t *testing.T) {
 			name: "full",
 			input: `{
 				sources: "E:/test/"
+				cache_control: "public, max-age=3600"
+				index: [index.html, index.htm]
+				fallback: app/index.html
+				browse: true
+				browse_template: /etc/listing.html
+				precompressed: [br, gzip]
+				follow_symlinks: never
+				hidden: unlisted
+				deny: ["*.bak", private/*]
 			}`,
 			want: &http.EndpointFiles{
-				Source: "E:/test/",
+				Source:         "E:/test/",
+				CacheControl:   "public, max-age=3600",
+				Index:          []string{"index.html", "index.htm"},
+				Fallback:       "app/index.html",
+				Browse:         true,
+				BrowseTemplate: "/etc/listing.html",
+				Precompressed:  []string{"br", "gzip"},
+				FollowSymlinks: "never",
+				Hidden:         "unlisted",
+				Deny:           []string{"*.bak", "private/*"},
 			},
 			wantErr: false,
 		}, {
@@ -232,19 +685,78 @@ func Test_readEpFiles(t *testing.T) {
 			}`,
 			want:    nil,
 			wantErr: true,
+		}, {
+			name: "no_index",
+			input: `{
+				index: []
+			}`,
+			want:    &http.EndpointFiles{Index: []string{}},
+			wantErr: false,
+		}, {
+			name: "single_index",
+			input: `{
+				index: default.htm
+			}`,
+			want:    &http.EndpointFiles{Index: []string{"default.htm"}},
+			wantErr: false,
+		}, {
+			name: "fallback_outside",
+			input: `{
+				fallback: ../index.html
+			}`,
+			want:    nil,
+			wantErr: true,
+		}, {
+			name: "unknown_encoding",
+			input: `{
+				precompressed: [br, deflate]
+			}`,
+			want:    nil,
+			wantErr: true,
+		}, {
+			name: "invalid_browse",
+			input: `{
+				browse: yes
+			}`,
+			want:    nil,
+			wantErr: true,
+		}, {
+			name: "invalid_symlinks",
+			input: `{
+				follow_symlinks: sometimes
+			}`,
+			want:    nil,
+			wantErr: true,
+		}, {
+			name: "invalid_hidden",
+			input: `{
+				hidden: show
+			}`,
+			want:    nil,
+			wantErr: true,
+		}, {
+			name: "invalid_deny",
+			input: `{
+				deny: ["[a-"]
+			}`,
+			want:    nil,
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := config.NewReader(strings.NewReader(tt.input))
-			got, err := readEpFiles(r)
+			got, err := readEpFiles(r, &http.Endpoint{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("readEpFiles() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !equalConfig(got, tt.want) {
 				t.Errorf("readEpFiles() = %v, want %v", got, tt.want)
 			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { endpoints { /: files %s } }`, tt.input)
+			}
 		})
 	}
 }
@@ -258,6 +770,20 @@ func Test_readEpRedirect(t *testing.T) {
 	}{
 		{
 			name: "full",
+			input: `{
+				url: "https://example.com/{{.LocalPath}}"
+				status: 308
+				preserve_path: false
+				preserve_query: true
+			}`,
+			want: &http.EndpointRedirect{
+				URL:           "https://example.com/{{.LocalPath}}",
+				Status:        308,
+				PreserveQuery: true,
+			},
+			wantErr: false,
+		}, {
+			name: "url_only",
 			input: `{
 				url: /
 			}`,
@@ -277,19 +803,36 @@ func Test_readEpRedirect(t *testing.T) {
 			}`,
 			want:    nil,
 			wantErr: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			r := config.NewReader(strings.NewReader(tt.input))
-			got, err := readEpRedirect(r)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("readEpRedirect() error = %v, wantErr %v", err, tt.wantErr)
+		}, {
+			name: "invalid_status",
+			input: `{
+				status: 200
+			}`,
+			want:    nil,
+			wantErr: true,
+		}, {
+			name: "invalid_template",
+			input: `{
+				url: "https://example.com/{{.LocalPath"
+			}`,
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := config.NewReader(strings.NewReader(tt.input))
+			got, err := readEpRedirect(r, &http.Endpoint{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readEpRedirect() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !equalConfig(got, tt.want) {
 				t.Errorf("readEpRedirect() = %v, want %v", got, tt.want)
 			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { endpoints { /: redirect %s } }`, tt.input)
+			}
 		})
 	}
 }
@@ -361,6 +904,152 @@ func Test_readEpProxy(t *testing.T) {
 				Host:  "localhost:80",
 				Path:  "/page/",
 			},
+		}, {
+			name: "https",
+			input: `{
+				url: "https://example.com/api"
+			}`,
+			want: &http.EndpointProxy{
+				Proto: "https",
+				Host:  "example.com:443",
+				Path:  "/api",
+			},
+		}, {
+			name: "unix",
+			input: `{
+				url: "unix:/run/app.sock"
+			}`,
+			want: &http.EndpointProxy{
+				Proto: "unix",
+				Host:  "/run/app.sock",
+				Path:  "/",
+			},
+		}, {
+			name: "unix_path",
+			input: `{
+				url: "unix:/run/app.sock:/api/"
+			}`,
+			want: &http.EndpointProxy{
+				Proto: "unix",
+				Host:  "/run/app.sock",
+				Path:  "/api/",
+			},
+		}, {
+			name: "unix_no_socket",
+			input: `{
+				url: "unix:"
+			}`,
+			wantErr: true,
+		}, {
+			name: "unix_path_invalid",
+			input: `{
+				url: "unix:/run/app.sock:api"
+			}`,
+			wantErr: true,
+		}, {
+			name: "tls",
+			input: `{
+				url: "https://10.0.0.1:8443"
+				tls {
+					ca: testdata/ca.pem
+					server_name: backend.internal
+					cert: testdata/client.pem
+					key: testdata/client-key.pem
+				}
+			}`,
+			want: &http.EndpointProxy{
+				Proto: "https",
+				Host:  "10.0.0.1:8443",
+				Path:  "/",
+				ProxyTransport: http.ProxyTransport{TLS: &http.ProxyTLS{
+					CA:         "testdata/ca.pem",
+					ServerName: "backend.internal",
+					Cert:       "testdata/client.pem",
+					Key:        "testdata/client-key.pem",
+				}},
+			},
+		}, {
+			name: "rewrite",
+			input: `{
+				rewrite_location: true
+				rewrite_cookies: true
+				cookie_domain: .example.com
+				request_headers {
+					set {
+						X-Api-Key: secret
+					}
+					remove: [Cookie, X-Debug]
+				}
+				response_headers {
+					add {
+						Cache-Control: no-transform
+					}
+				}
+			}`,
+			want: &http.EndpointProxy{
+				Proto: "http",
+				Host:  "localhost:80",
+				Path:  "/",
+				ProxyRewrite: http.ProxyRewrite{
+					RewriteLocation: true,
+					RewriteCookies:  true,
+					CookieDomain:    ".example.com",
+					RequestHeaders: &http.HeaderRewrite{
+						Set:    map[string]string{"X-Api-Key": "secret"},
+						Remove: []string{"Cookie", "X-Debug"},
+					},
+					ResponseHeaders: &http.HeaderRewrite{
+						Add: map[string]string{"Cache-Control": "no-transform"},
+					},
+				},
+			},
+		}, {
+			name: "rewrite_header_invalid",
+			input: `{
+				request_headers {
+					remove: ["Bad Name"]
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "cookie_domain_invalid",
+			input: `{
+				cookie_domain: "example com"
+			}`,
+			wantErr: true,
+		}, {
+			name: "tls_cert_without_key",
+			input: `{
+				tls {
+					cert: testdata/client.pem
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "tls_ca_missing",
+			input: `{
+				tls {
+					ca: testdata/missing.pem
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "tls_key_mismatch",
+			input: `{
+				tls {
+					cert: testdata/client.pem
+					key: testdata/ca.pem
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "tls_server_name_invalid",
+			input: `{
+				tls {
+					server_name: "bad name"
+				}
+			}`,
+			wantErr: true,
 		}, {
 			name: "proto_unsupported",
 			input: `{
@@ -409,19 +1098,334 @@ func Test_readEpProxy(t *testing.T) {
 				url: ""
 			}`,
 			wantErr: true,
+		}, {
+			name: "transport",
+			input: `{
+				url: "example.com"
+				keepalive: 64
+				idle_timeout: 2m
+				dial_timeout: 5s
+				response_header_timeout: 1m30s
+				http2: true
+			}`,
+			want: &http.EndpointProxy{
+				Proto: "http",
+				Host:  "example.com:80",
+				Path:  "/",
+				ProxyTransport: http.ProxyTransport{
+					Keepalive:             64,
+					IdleTimeout:           2 * time.Minute,
+					DialTimeout:           5 * time.Second,
+					ResponseHeaderTimeout: 90 * time.Second,
+					HTTP2:                 true,
+				},
+			},
+		}, {
+			name: "keepalive_off",
+			input: `{
+				keepalive: off
+			}`,
+			want: &http.EndpointProxy{
+				Proto:          "http",
+				Host:           "localhost:80",
+				Path:           "/",
+				ProxyTransport: http.ProxyTransport{Keepalive: -1},
+			},
+		}, {
+			name: "keepalive_invalid",
+			input: `{
+				keepalive: 0
+			}`,
+			wantErr: true,
+		}, {
+			name: "timeout_invalid",
+			input: `{
+				idle_timeout: 90
+			}`,
+			wantErr: true,
+		}, {
+			name: "timeout_negative",
+			input: `{
+				dial_timeout: -5s
+			}`,
+			wantErr: true,
+		}, {
+			name: "forwarded",
+			input: `{
+				preserve_host: true
+				forwarded: trusted
+				trusted_proxies: [10.1.2.3/8, 127.0.0.1, "::1"]
+			}`,
+			want: &http.EndpointProxy{
+				Proto:        "http",
+				Host:         "localhost:80",
+				Path:         "/",
+				PreserveHost: true,
+				Forwarded:    http.ForwardedTrusted,
+				TrustedProxies: []netip.Prefix{
+					netip.MustParsePrefix("10.0.0.0/8"),
+					netip.MustParsePrefix("127.0.0.1/32"),
+					netip.MustParsePrefix("::1/128"),
+				},
+			},
+		}, {
+			name: "upstream",
+			input: `{
+				url: /v1
+				upstream: backend
+			}`,
+			want: &http.EndpointProxy{
+				Proto:    "http",
+				Host:     "localhost:80",
+				Path:     "/v1",
+				Upstream: &http.UpstreamPool{Name: "backend"},
+			},
+		}, {
+			name: "forwarded_invalid",
+			input: `{
+				forwarded: keep
+			}`,
+			wantErr: true,
+		}, {
+			name: "trusted_without_proxies",
+			input: `{
+				forwarded: trusted
+			}`,
+			wantErr: true,
+		}, {
+			name: "trusted_proxy_invalid",
+			input: `{
+				trusted_proxies: [10.0.0.0/33]
+			}`,
+			wantErr: true,
+		}, {
+			name: "retry",
+			input: `{
+				timeout: 10s
+				retry {
+					attempts: 4
+					statuses: [429, 503]
+					backoff: 100ms
+					max_backoff: 2s
+				}
+				circuit_breaker {
+					failures: 10
+					open_timeout: 1m
+				}
+			}`,
+			want: &http.EndpointProxy{
+				Proto:   "http",
+				Host:    "localhost:80",
+				Path:    "/",
+				Timeout: 10 * time.Second,
+				Retry: &http.RetryPolicy{
+					Attempts:   4,
+					Statuses:   []int{429, 503},
+					Backoff:    100 * time.Millisecond,
+					MaxBackoff: 2 * time.Second,
+				},
+				CircuitBreaker: &http.CircuitBreaker{Failures: 10, OpenTimeout: time.Minute},
+			},
+		}, {
+			name: "retry_defaults",
+			input: `{
+				retry {}
+				circuit_breaker {}
+			}`,
+			want: &http.EndpointProxy{
+				Proto:          "http",
+				Host:           "localhost:80",
+				Path:           "/",
+				Retry:          &http.RetryPolicy{},
+				CircuitBreaker: &http.CircuitBreaker{},
+			},
+		}, {
+			name: "retry_status_invalid",
+			input: `{
+				retry {
+					statuses: [200]
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "retry_attempts_invalid",
+			input: `{
+				retry {
+					attempts: 0
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "retry_backoff_invalid",
+			input: `{
+				retry {
+					backoff: 1s
+					max_backoff: 100ms
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "upgrade_idle_timeout",
+			input: `{
+				upgrade_idle_timeout: 1h
+			}`,
+			want: &http.EndpointProxy{
+				Proto:              "http",
+				Host:               "localhost:80",
+				Path:               "/",
+				UpgradeIdleTimeout: time.Hour,
+			},
+		}, {
+			name: "upgrade_idle_timeout_off",
+			input: `{
+				upgrade_idle_timeout: off
+			}`,
+			want: &http.EndpointProxy{
+				Proto:              "http",
+				Host:               "localhost:80",
+				Path:               "/",
+				UpgradeIdleTimeout: -1,
+			},
+		}, {
+			name: "flush_interval",
+			input: `{
+				flush_interval: 100ms
+			}`,
+			want: &http.EndpointProxy{
+				Proto:         "http",
+				Host:          "localhost:80",
+				Path:          "/",
+				FlushInterval: 100 * time.Millisecond,
+			},
+		}, {
+			name: "flush_interval_immediate",
+			input: `{
+				flush_interval: immediate
+			}`,
+			want: &http.EndpointProxy{
+				Proto:         "http",
+				Host:          "localhost:80",
+				Path:          "/",
+				FlushInterval: http.FlushImmediately,
+			},
+		}, {
+			name: "flush_interval_invalid",
+			input: `{
+				flush_interval: 0s
+			}`,
+			wantErr: true,
+		}, {
+			name: "upgrade_idle_timeout_invalid",
+			input: `{
+				upgrade_idle_timeout: never
+			}`,
+			wantErr: true,
+		}, {
+			name: "circuit_breaker_invalid",
+			input: `{
+				circuit_breaker {
+					failures: off
+				}
+			}`,
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := config.NewReader(strings.NewReader(tt.input))
-			got, err := readEpProxy(r)
+			got, _, err := readEpProxy(r, &http.Endpoint{}, newUpstreamPools())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("readEpProxy() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !equalConfig(got, tt.want) {
 				t.Errorf("readEpProxy() = %v, want %v", got, tt.want)
 			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { upstreams { backend: pool { servers { ":8080": 1 } } } endpoints { /: proxy %s } }`, tt.input)
+			}
+		})
+	}
+}
+
+func Test_readProxyCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *http.EndpointCache
+		wantErr bool
+	}{
+		{
+			name: "all",
+			input: `{
+				key: [scheme, host, path, "header:Accept-Language", "cookie:lang"]
+				max_size: 16m
+				max_entry_size: 512k
+				dir: /var/cache/switchman
+				disk_max_size: 2G
+				default_ttl: 1m
+				stale_while_revalidate: 30s
+			}`,
+			want: &http.EndpointCache{
+				Key:                  []string{"scheme", "host", "path", "header:Accept-Language", "cookie:lang"},
+				MaxSize:              16 << 20,
+				MaxEntrySize:         512 << 10,
+				Dir:                  "/var/cache/switchman",
+				DiskMaxSize:          2 << 30,
+				DefaultTTL:           time.Minute,
+				StaleWhileRevalidate: 30 * time.Second,
+			},
+		}, {
+			name:  "empty",
+			input: "{}",
+			want:  &http.EndpointCache{},
+		}, {
+			name:  "bytes",
+			input: "{ max_size: 4096 }",
+			want:  &http.EndpointCache{MaxSize: 4096},
+		}, {
+			name:    "key_invalid",
+			input:   "{ key: [path, body] }",
+			wantErr: true,
+		}, {
+			name:    "key_header_invalid",
+			input:   `{ key: ["header:Accept Language"] }`,
+			wantErr: true,
+		}, {
+			name:    "size_invalid",
+			input:   "{ max_size: 64mb }",
+			wantErr: true,
+		}, {
+			name:    "size_zero",
+			input:   "{ max_entry_size: 0 }",
+			wantErr: true,
+		}, {
+			name:    "size_overflow",
+			input:   "{ disk_max_size: 9999999999999g }",
+			wantErr: true,
+		}, {
+			name:    "unknown",
+			input:   "{ ttl: 1m }",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := config.NewReader(strings.NewReader(tt.input))
+			got, err := readProxyCache(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readProxyCache() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !equalConfig(got, tt.want) {
+				t.Errorf("readProxyCache() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { endpoints { /: proxy {
+					url: "http://localhost"
+					cache %s
+				} } }`, tt.input)
+			}
 		})
 	}
 }
@@ -448,3 +1452,231 @@ func BenchmarkParseServer(b *testing.B) {
 		}
 	}
 }
+
+func Test_readCompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *http.Compression
+		wantErr bool
+	}{
+		{
+			name: "full",
+			input: `{
+				encodings: [gzip, br]
+				min_size: 256
+				types: [text/*, application/json]
+			}`,
+			want: &http.Compression{
+				Encodings: []string{"gzip", "br"},
+				MinSize:   256,
+				Types:     []string{"text/*", "application/json"},
+			},
+			wantErr: false,
+		}, {
+			name:    "defaults",
+			input:   "{}",
+			want:    &http.Compression{MinSize: 1024},
+			wantErr: false,
+		}, {
+			name: "unknown_encoding",
+			input: `{
+				encodings: [lzma]
+			}`,
+			wantErr: true,
+		}, {
+			name: "size_unit",
+			input: `{
+				min_size: 1k
+			}`,
+			want:    &http.Compression{MinSize: 1024},
+			wantErr: false,
+		}, {
+			name: "invalid_size",
+			input: `{
+				min_size: 1kb
+			}`,
+			wantErr: true,
+		}, {
+			name: "invalid_type",
+			input: `{
+				types: [text]
+			}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := config.NewReader(strings.NewReader(tt.input))
+			got, err := readCompress(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readCompress() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !equalConfig(got, tt.want) {
+				t.Errorf("readCompress() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { endpoints { /: files {
+					sources: /srv
+					compress %s
+				} } }`, tt.input)
+			}
+		})
+	}
+}
+
+func Test_readErrorPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    http.ErrorPages
+		wantErr bool
+	}{
+		{
+			name: "full",
+			input: `{
+				404: file "/srv/404.html"
+				4xx: template /srv/error.html
+				502: proxy "localhost:9000/errors"
+			}`,
+			want: http.ErrorPages{
+				"404": {File: "/srv/404.html"},
+				"4xx": {Template: "/srv/error.html"},
+				"502": {Proxy: &http.EndpointProxy{Proto: "http", Host: "localhost:9000", Path: "/errors"}},
+			},
+			wantErr: false,
+		}, {
+			name:    "empty",
+			input:   "{}",
+			want:    http.ErrorPages{},
+			wantErr: false,
+		}, {
+			name: "not_error",
+			input: `{
+				200: file ok.html
+			}`,
+			wantErr: true,
+		}, {
+			name: "invalid_status",
+			input: `{
+				notfound: file 404.html
+			}`,
+			wantErr: true,
+		}, {
+			name: "unknown_type",
+			input: `{
+				404: redirect /
+			}`,
+			wantErr: true,
+		}, {
+			name: "invalid_proxy",
+			input: `{
+				5xx: proxy "ftp://example.com"
+			}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := config.NewReader(strings.NewReader(tt.input))
+			got, err := readErrorPages(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readErrorPages() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !equalConfig(got, tt.want) {
+				t.Errorf("readErrorPages() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { errors %s }`, tt.input)
+			}
+		})
+	}
+}
+
+func Test_readEpRespond(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *http.EndpointRespond
+		wantErr bool
+	}{
+		{
+			name: "full",
+			input: `{
+				status: 503
+				headers {
+					Content-Type: "text/html; charset=utf-8"
+					Retry-After: 3600
+				}
+				body: """<h1>Maintenance</h1><p>{{.Path}} is back soon</p>"""
+			}`,
+			want: &http.EndpointRespond{
+				Status: 503,
+				Headers: map[string]string{
+					"Content-Type": "text/html; charset=utf-8",
+					"Retry-After":  "3600",
+				},
+				Body: "<h1>Maintenance</h1><p>{{.Path}} is back soon</p>",
+			},
+			wantErr: false,
+		}, {
+			name:    "empty",
+			input:   "{}",
+			want:    &http.EndpointRespond{},
+			wantErr: false,
+		}, {
+			name: "wrong_property",
+			input: `{
+				url: /
+			}`,
+			wantErr: true,
+		}, {
+			name: "invalid_status",
+			input: `{
+				status: 999
+			}`,
+			wantErr: true,
+		}, {
+			name: "invalid_template",
+			input: `{
+				body: "{{.Path"
+			}`,
+			wantErr: true,
+		}, {
+			name: "invalid_header_name",
+			input: `{
+				headers {
+					"X Bad": 1
+				}
+			}`,
+			wantErr: true,
+		}, {
+			name: "header_line_break",
+			input: `{
+				headers {
+					X-Bad: """a
+b"""
+				}
+			}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := config.NewReader(strings.NewReader(tt.input))
+			got, err := readEpRespond(r, &http.Endpoint{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readEpRespond() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !equalConfig(got, tt.want) {
+				t.Errorf("readEpRespond() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr {
+				testExampleConversion(t, `server { endpoints { /: respond %s } }`, tt.input)
+			}
+		})
+	}
+}
@@ -3,21 +3,108 @@ package config
 import (
 	"fmt"
 	"io"
+	"slices"
+	"strings"
 )
 
+// Source of tokens for the reader
+type tokenSource interface {
+	next() (Token, TokenPosition, error)
+}
+
 type Reader struct {
-	tokens   *tokenReader
+	tokens   tokenSource
 	curToken Token
 	tokenPos TokenPosition
+	peeked   *sourceToken
+	// field of the struct that is being read
+	field     sourceToken
+	fieldFrom []*expansion
+
+	snippets   map[Token]*snippet
+	expansions []*expansion
+	// snippet usages that the current token was read from
+	tokenFrom []*expansion
+
+	listener Listener
+}
+
+// Listener follows the structure of a config as it is read, so that tools like format
+// converters can use the parser instead of repeating its grammar
+type Listener interface {
+	// Struct read with ReadStruct, its fields are reported until the matching EndStruct
+	BeginStruct(pos TokenPosition)
+	EndStruct(pos TokenPosition)
+	// Field of the current struct, the values read for it follow
+	Field(name Token, pos TokenPosition)
+	// Value read with ReadString, ReadName or ReadBool. Strings are unescaped
+	Value(value Token, pos TokenPosition)
+	// List read with ReadList, a single string is reported as a list with one item
+	List(items []Token, pos TokenPosition)
+}
+
+// SnippetListener is a Listener that is also told about snippets. Fields of a used snippet
+// are reported like the ones of the block it is used in, at their positions in the definition
+type SnippetListener interface {
+	Listener
+	// Snippet read with ReadDefinition and the parameters of its body
+	Define(name Token, params []Token, pos TokenPosition)
+	// Usage of a snippet with 'use', reported once its name is read
+	Use(name Token, pos TokenPosition)
+	// Parameter set at the usage of a snippet, its value is reported where the snippet reads it
+	Argument(param Token, pos TokenPosition)
+}
+
+// Report what is read from now on to the listener
+func (r *Reader) Listen(l Listener) {
+	r.listener = l
 }
 
 func NewReader(r io.Reader) *Reader {
 	return &Reader{tokens: newTokenReader(r)}
 }
 
+// PositionedToken is a token with the position of its source
+type PositionedToken struct {
+	Token Token
+	Pos   TokenPosition
+}
+
+// Create a reader of tokens that were produced from a different source, like a document in
+// another format. Positions of the tokens are used in errors
+func NewTokensReader(tokens []PositionedToken) *Reader {
+	return &Reader{tokens: &tokenList{tokens: tokens}}
+}
+
+type tokenList struct {
+	tokens []PositionedToken
+	last   TokenPosition
+}
+
+func (l *tokenList) next() (Token, TokenPosition, error) {
+	if len(l.tokens) == 0 {
+		return EOF, l.last, nil
+	}
+	t := l.tokens[0]
+	l.tokens = l.tokens[1:]
+	l.last = t.Pos
+	return t.Token, t.Pos, nil
+}
+
 // Read next token. If reader reached EOF, return ""
 func (r *Reader) ReadNext() (Token, error) {
 	var err error
+	if t, ok := r.nextExpanded(); ok {
+		r.curToken, r.tokenPos = t.tok, t.pos
+		r.tokenFrom = slices.Clone(r.expansions)
+		return r.curToken, nil
+	}
+	r.tokenFrom = nil
+	if r.peeked != nil {
+		r.curToken, r.tokenPos = r.peeked.tok, r.peeked.pos
+		r.peeked = nil
+		return r.curToken, nil
+	}
 	r.curToken, r.tokenPos, err = r.tokens.next()
 	return r.curToken, err
 }
@@ -53,6 +140,14 @@ func (r *Reader) ReadSeparator() error {
 }
 
 func (r *Reader) ReadName() (t Token, err error) {
+	t, err = r.readName()
+	if err == nil && r.listener != nil {
+		r.listener.Value(t, r.tokenPos)
+	}
+	return
+}
+
+func (r *Reader) readName() (t Token, err error) {
 	t, err = r.ReadLiteral()
 	if err != nil {
 		return
@@ -69,15 +164,94 @@ func (r *Reader) ReadString() (t Token, err error) {
 	if err != nil {
 		return
 	}
-	t, err = t.Unescaped()
+	t, err = r.unescapeCurrent()
+	if err == nil && r.listener != nil {
+		r.listener.Value(t, r.tokenPos)
+	}
+	return
+}
+
+// Read a list of strings written as '[a, b]', a trailing comma is allowed. A single string
+// is read as a list with one item
+func (r *Reader) ReadList() (list []Token, err error) {
+	t, err := r.ReadNext()
 	if err != nil {
 		return
 	}
+	if r.listener != nil {
+		pos := r.tokenPos
+		defer func() {
+			if err == nil {
+				r.listener.List(list, pos)
+			}
+		}()
+	}
+	if t != "[" {
+		if !t.IsLiteral() {
+			return nil, r.ErrUnexpectedToken("a string or '['")
+		}
+		if t, err = r.unescapeCurrent(); err != nil {
+			return
+		}
+		return []Token{t}, nil
+	}
+
+	list = []Token{}
+	for {
+		if t, err = r.ReadNext(); err != nil {
+			return nil, err
+		}
+		if t == "]" {
+			return
+		}
+		if !t.IsLiteral() {
+			return nil, r.ErrUnexpectedToken("a string or ']'")
+		}
+		if t, err = r.unescapeCurrent(); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+
+		if t, err = r.ReadNext(); err != nil {
+			return nil, err
+		}
+		if t == "]" {
+			return
+		}
+		if t != "," {
+			return nil, r.ErrUnexpectedToken("',' or ']'")
+		}
+	}
+}
+
+func (r *Reader) unescapeCurrent() (t Token, err error) {
+	t, err = r.curToken.Unescaped()
+	if err != nil {
+		err = r.Errorf("%v", err)
+	}
 	return
 }
 
+// Read a boolean value, either 'true' or 'false'
+func (r *Reader) ReadBool() (v bool, err error) {
+	t, err := r.ReadString()
+	if err != nil {
+		return
+	}
+	switch t {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, r.ErrInvalid("boolean, expected true or false")
+}
+
 // Read a structure block, each literal token is passed to parseField function.
 //
+// Fields of snippets inserted with 'use' are passed to parseField as if they were
+// written in the block.
+//
 // Example:
 //
 //	{
@@ -88,6 +262,14 @@ func (r *Reader) ReadStruct(parseField func(tokens *Reader, field Token) error)
 	if err = r.ReadExact("{"); err != nil {
 		return
 	}
+	if r.listener != nil {
+		r.listener.BeginStruct(r.tokenPos)
+	}
+	// field of the outer struct is restored when this one ends
+	outer, outerFrom := r.field, r.fieldFrom
+	defer func() {
+		r.field, r.fieldFrom = outer, outerFrom
+	}()
 	for {
 		var token Token
 		token, err = r.ReadNext()
@@ -95,11 +277,24 @@ func (r *Reader) ReadStruct(parseField func(tokens *Reader, field Token) error)
 			return
 		}
 		if token == "}" {
+			if r.listener != nil {
+				r.listener.EndStruct(r.tokenPos)
+			}
 			break
 		} else if !token.IsLiteral() {
 			return r.ErrUnexpectedToken("property name or '}'")
 		}
+		if token == "use" {
+			if err = r.readUsage(); err != nil {
+				return
+			}
+			continue
+		}
 
+		r.field, r.fieldFrom = sourceToken{token, r.tokenPos}, r.tokenFrom
+		if r.listener != nil {
+			r.listener.Field(token, r.tokenPos)
+		}
 		err = parseField(r, token)
 		if err != nil {
 			return
@@ -108,15 +303,57 @@ func (r *Reader) ReadStruct(parseField func(tokens *Reader, field Token) error)
 	return
 }
 
+// Position of the current token
+func (r *Reader) Position() TokenPosition {
+	return r.tokenPos
+}
+
+// Error is an error in the config source, located at the position of a token
+type Error struct {
+	Pos TokenPosition
+	Msg string
+	// Usages of snippets that the token was inserted by, innermost first
+	Trace []SnippetUsage
+}
+
+// SnippetUsage is the location where a snippet was inserted with 'use'
+type SnippetUsage struct {
+	Name Token
+	Pos  TokenPosition
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:%d: %s", e.Pos.Line, e.Pos.Col, e.Msg)
+	for _, use := range e.Trace {
+		fmt.Fprintf(&b, " (in snippet %s used at %d:%d)", use.Name.Quote(), use.Pos.Line, use.Pos.Col)
+	}
+	return b.String()
+}
+
 func (r *Reader) Errorf(format string, a ...any) error {
-	return fmt.Errorf("%d:%d: %s", r.tokenPos.Line, r.tokenPos.Col, fmt.Sprintf(format, a...))
+	return &Error{
+		Pos:   r.tokenPos,
+		Msg:   fmt.Sprintf(format, a...),
+		Trace: expansionTrace(r.tokenFrom),
+	}
 }
 func (r *Reader) ErrUnexpectedToken(expect string) error {
 	return r.Errorf("%s was expected, got %s", expect, r.curToken.Quote())
 }
 func (r *Reader) ErrUnrecognized(exp string) error {
-	return fmt.Errorf("%s is not a recognized %s", r.curToken.Quote(), exp)
+	return r.Errorf("%s is not a recognized %s", r.curToken.Quote(), exp)
+}
+
+// Error for the field of the struct being read, reported at its position even if the
+// following tokens were already read
+func (r *Reader) ErrUnrecognizedField(exp string) error {
+	return &Error{
+		Pos:   r.field.pos,
+		Msg:   fmt.Sprintf("%s is not a recognized %s", r.field.tok.Quote(), exp),
+		Trace: expansionTrace(r.fieldFrom),
+	}
 }
 func (r *Reader) ErrInvalid(exp string) error {
-	return fmt.Errorf("%s is not a valid %s", r.curToken.Quote(), exp)
+	return r.Errorf("%s is not a valid %s", r.curToken.Quote(), exp)
 }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -170,6 +171,9 @@ func TestReader_ReadString(t *testing.T) {
 		{"escaped", `'\'string\''`, "'string'", false},
 		{"unknown_escape", `strin\g`, "", true},
 		{"escaped_end", `'string\'`, "", true},
+		{"escapes", `"line\n\ttab"`, "line\n\ttab", false},
+		{"raw", "`C:\\dir\\`", "C:\\dir\\", false},
+		{"multiline", "\"\"\"\n\t<p>\n\t</p>\n\t\"\"\"", "<p>\n</p>", false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -189,6 +193,65 @@ func TestReader_ReadString(t *testing.T) {
 	}
 }
 
+func TestReader_ReadList(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Token
+		wantErr bool
+	}{
+		{"list", "[index.html, 'index page.htm']", []Token{"index.html", "index page.htm"}, false},
+		{"empty", "[]", []Token{}, false},
+		{"trailing_comma", "[\n\ta,\n\tb,\n]", []Token{"a", "b"}, false},
+		{"single", "index.html", []Token{"index.html"}, false},
+		{"missing_comma", "[a b]", nil, true},
+		{"not_closed", "[a, b", nil, true},
+		{"double_comma", "[a,, b]", nil, true},
+		{"block", "{}", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input))
+			got, err := r.ReadList()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Reader.ReadList(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Reader.ReadList() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader_ReadBool(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    bool
+		wantErr bool
+	}{
+		{"true", "true", true, false},
+		{"false", "false", false, false},
+		{"quoted", "'true'", true, false},
+		{"invalid", "yes", false, true},
+		{"separator", ":", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input))
+			got, err := r.ReadBool()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Reader.ReadBool(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Reader.ReadBool() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestReader_ReadStruct(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -250,3 +313,132 @@ func TestReader_ReadStruct(t *testing.T) {
 		})
 	}
 }
+
+// Listener that records events as strings
+type eventRecorder struct {
+	events []string
+}
+
+func (e *eventRecorder) BeginStruct(pos TokenPosition) { e.events = append(e.events, "{") }
+func (e *eventRecorder) EndStruct(pos TokenPosition)   { e.events = append(e.events, "}") }
+func (e *eventRecorder) Field(name Token, pos TokenPosition) {
+	e.events = append(e.events, "field "+name.String())
+}
+func (e *eventRecorder) Value(value Token, pos TokenPosition) {
+	e.events = append(e.events, "value "+value.String())
+}
+func (e *eventRecorder) List(items []Token, pos TokenPosition) {
+	e.events = append(e.events, "list "+strings.Join(tokenStrings(items), ","))
+}
+
+func tokenStrings(tokens []Token) []string {
+	var s []string
+	for _, t := range tokens {
+		s = append(s, t.String())
+	}
+	return s
+}
+
+func TestReader_Listen(t *testing.T) {
+	r := NewReader(strings.NewReader(`
+	define site { sources: $dir }
+	{
+		name: "a b"
+		on: true
+		/path: files {
+			use site { dir: /srv }
+		}
+		items: [x, y]
+		single: z
+	}`))
+	if err := r.ReadExact("define"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadDefinition(); err != nil {
+		t.Fatal(err)
+	}
+	l := &eventRecorder{}
+	r.Listen(l)
+	var readField func(r *Reader, field Token) error
+	readField = func(r *Reader, field Token) (err error) {
+		switch field {
+		case "items", "single":
+			if err = r.ReadSeparator(); err == nil {
+				_, err = r.ReadList()
+			}
+		case "on":
+			if err = r.ReadSeparator(); err == nil {
+				_, err = r.ReadBool()
+			}
+		case "/path":
+			if err = r.ReadSeparator(); err == nil {
+				if _, err = r.ReadName(); err == nil {
+					err = r.ReadStruct(readField)
+				}
+			}
+		default:
+			if err = r.ReadSeparator(); err == nil {
+				_, err = r.ReadString()
+			}
+		}
+		return
+	}
+	if err := r.ReadStruct(readField); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"{",
+		"field name", "value a b",
+		"field on", "value true",
+		"field /path", "value files", "{", "field sources", "value /srv", "}",
+		"field items", "list x,y",
+		"field single", "list z",
+		"}",
+	}
+	if !reflect.DeepEqual(l.events, want) {
+		t.Errorf("Listen() events = %q, want %q", l.events, want)
+	}
+}
+
+// Listener that also records snippets with their positions
+type snippetRecorder struct {
+	eventRecorder
+}
+
+func (e *snippetRecorder) Define(name Token, params []Token, pos TokenPosition) {
+	e.events = append(e.events, fmt.Sprintf("define %s(%s) %d:%d", name, strings.Join(tokenStrings(params), ","), pos.Line, pos.Col))
+}
+func (e *snippetRecorder) Use(name Token, pos TokenPosition) {
+	e.events = append(e.events, fmt.Sprintf("use %s %d:%d", name, pos.Line, pos.Col))
+}
+func (e *snippetRecorder) Argument(param Token, pos TokenPosition) {
+	e.events = append(e.events, fmt.Sprintf("arg %s %d:%d", param, pos.Line, pos.Col))
+}
+
+func TestReader_ListenSnippets(t *testing.T) {
+	r := NewReader(strings.NewReader("define site { sources: [$dir, $dir] }\n{ use site { dir: /srv } }"))
+	l := &snippetRecorder{}
+	r.Listen(l)
+	if err := r.ReadExact("define"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadDefinition(); err != nil {
+		t.Fatal(err)
+	}
+	err := r.ReadStruct(func(r *Reader, field Token) (err error) {
+		if err = r.ReadSeparator(); err == nil {
+			_, err = r.ReadList()
+		}
+		return
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"define site(dir) 1:8",
+		"{", "use site 2:7", "arg dir 2:14", "field sources", "list /srv,/srv", "}",
+	}
+	if !reflect.DeepEqual(l.events, want) {
+		t.Errorf("Listen() events = %q, want %q", l.events, want)
+	}
+}
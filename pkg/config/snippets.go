@@ -0,0 +1,210 @@
+package config
+
+import (
+	"slices"
+)
+
+// A snippet is a named list of fields that can be inserted into any block with 'use'.
+//
+// Tokens in the body in form of $name are parameters, they are replaced with the values passed
+// at the usage site:
+//
+//	define common_proxy {
+//	  url: $upstream
+//	}
+//	...
+//	/api: proxy {
+//	  use common_proxy { upstream: "localhost:8080" }
+//	}
+type snippet struct {
+	name   Token
+	pos    TokenPosition
+	body   []sourceToken
+	params []Token
+}
+
+// A token with the position it was read at
+type sourceToken struct {
+	tok Token
+	pos TokenPosition
+}
+
+// An active usage of a snippet, tokens of its body are read before the rest of the source
+type expansion struct {
+	snippet *snippet
+	usedAt  TokenPosition
+	args    map[Token]sourceToken
+	next    int
+}
+
+func paramName(t Token) (Token, bool) {
+	if len(t) < 2 || t[0] != '$' || !t[1:].IsName() {
+		return EOF, false
+	}
+	return t[1:], true
+}
+
+// Read a snippet definition after the 'define' keyword. The snippet can be used in any block
+// read after it.
+//
+// Example:
+//
+//	define name {
+//	  field_a: $param
+//	  ...
+//	}
+func (r *Reader) ReadDefinition() (err error) {
+	name, err := r.readName()
+	if err != nil {
+		return
+	}
+	if _, ok := r.snippets[name]; ok {
+		return r.Errorf("snippet %s is already defined", name.Quote())
+	}
+	def := &snippet{name: name, pos: r.tokenPos}
+
+	if err = r.ReadExact("{"); err != nil {
+		return
+	}
+	// capture everything up to the matching closing brace
+	for depth := 0; ; {
+		var t Token
+		t, err = r.ReadNext()
+		if err != nil {
+			return
+		}
+		switch t {
+		case EOF:
+			return r.ErrUnexpectedToken("'}'")
+		case "{":
+			depth += 1
+		case "}":
+			depth -= 1
+		}
+		if depth < 0 {
+			break
+		}
+		if param, ok := paramName(t); ok && !slices.Contains(def.params, param) {
+			def.params = append(def.params, param)
+		}
+		def.body = append(def.body, sourceToken{t, r.tokenPos})
+	}
+
+	if r.snippets == nil {
+		r.snippets = make(map[Token]*snippet)
+	}
+	r.snippets[name] = def
+	if l, ok := r.listener.(SnippetListener); ok {
+		l.Define(name, def.params, def.pos)
+	}
+	return nil
+}
+
+// Read the usage of a snippet after the 'use' keyword, the following tokens will be read from
+// the snippet body.
+//
+// Example:
+//
+//	use name
+//	use name { param: value ... }
+func (r *Reader) readUsage() (err error) {
+	usedAt := r.tokenPos
+	name, err := r.readName()
+	if err != nil {
+		return
+	}
+	l, listening := r.listener.(SnippetListener)
+	if listening {
+		l.Use(name, r.tokenPos)
+	}
+	def, ok := r.snippets[name]
+	if !ok {
+		return r.Errorf("snippet %s is not defined", name.Quote())
+	}
+	for _, e := range r.expansions {
+		if e.snippet == def {
+			return r.Errorf("snippet %s is used recursively", name.Quote())
+		}
+	}
+	use := &expansion{snippet: def, usedAt: usedAt, args: make(map[Token]sourceToken)}
+
+	var t Token
+	if t, err = r.ReadNext(); err != nil {
+		return
+	}
+	if t == "{" {
+		for {
+			if t, err = r.ReadNext(); err != nil {
+				return
+			}
+			if t == "}" {
+				break
+			}
+			if !t.IsName() {
+				return r.ErrUnexpectedToken("parameter name or '}'")
+			}
+			if !slices.Contains(def.params, t) {
+				return r.Errorf("snippet %s has no parameter %s", name.Quote(), t.Quote())
+			}
+			if _, ok := use.args[t]; ok {
+				return r.Errorf("parameter %s is already set", t.Quote())
+			}
+			if listening {
+				l.Argument(t, r.tokenPos)
+			}
+			param := t
+			if err = r.ReadSeparator(); err != nil {
+				return
+			}
+			if t, err = r.ReadLiteral(); err != nil {
+				return
+			}
+			use.args[param] = sourceToken{t, r.tokenPos}
+		}
+	} else {
+		// not a part of the usage, keep it for the next read
+		r.unread()
+	}
+	for _, param := range def.params {
+		if _, ok := use.args[param]; !ok {
+			return r.Errorf("parameter %s of snippet %s is not set", param.Quote(), name.Quote())
+		}
+	}
+	r.expansions = append(r.expansions, use)
+	return nil
+}
+
+// Return the current token back to be read again
+func (r *Reader) unread() {
+	if len(r.tokenFrom) > 0 {
+		r.tokenFrom[len(r.tokenFrom)-1].next -= 1
+	} else {
+		r.peeked = &sourceToken{r.curToken, r.tokenPos}
+	}
+}
+
+// Return the next token of active snippet usages
+func (r *Reader) nextExpanded() (t sourceToken, ok bool) {
+	for len(r.expansions) > 0 {
+		use := r.expansions[len(r.expansions)-1]
+		if use.next < len(use.snippet.body) {
+			t = use.snippet.body[use.next]
+			use.next += 1
+			if param, ok := paramName(t.tok); ok {
+				t = use.args[param]
+			}
+			return t, true
+		}
+		r.expansions = r.expansions[:len(r.expansions)-1]
+	}
+	return
+}
+
+// List snippet usages that a token comes from, innermost first
+func expansionTrace(from []*expansion) (trace []SnippetUsage) {
+	for i := len(from) - 1; i >= 0; i-- {
+		use := from[i]
+		trace = append(trace, SnippetUsage{use.snippet.name, use.usedAt})
+	}
+	return
+}
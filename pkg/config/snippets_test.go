@@ -0,0 +1,184 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Read definitions followed by a struct, collecting "field: value" pairs from it
+func readFields(input string) (fields []Token, err error) {
+	r := NewReader(strings.NewReader(input))
+	for {
+		var t Token
+		if t, err = r.ReadNext(); err != nil {
+			return
+		}
+		if t != "define" {
+			r.unread()
+			break
+		}
+		if err = r.ReadDefinition(); err != nil {
+			return
+		}
+	}
+	err = r.ReadStruct(func(r *Reader, field Token) (err error) {
+		if err = r.ReadSeparator(); err != nil {
+			return
+		}
+		value, err := r.ReadString()
+		if err != nil {
+			return
+		}
+		fields = append(fields, field, value)
+		return
+	})
+	return
+}
+
+func TestReader_Snippets(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Token
+		wantErr bool
+	}{
+		{
+			name: "simple",
+			input: `
+			define common {
+				a: 1
+				b: 2
+			}
+			{
+				first: 0
+				use common
+				last: 3
+			}`,
+			want: []Token{"first", "0", "a", "1", "b", "2", "last", "3"},
+		}, {
+			name: "params",
+			input: `
+			define common {
+				a: $x
+				b: $y
+				c: $x
+			}
+			{
+				use common { x: "one" y: two }
+			}`,
+			want: []Token{"a", "one", "b", "two", "c", "one"},
+		}, {
+			name: "nested",
+			input: `
+			define inner {
+				a: $x
+			}
+			define outer {
+				use inner { x: $y }
+				b: $y
+			}
+			{
+				use outer { y: 1 }
+				use inner { x: 2 }
+			}`,
+			want: []Token{"a", "1", "b", "1", "a", "2"},
+		}, {
+			name: "at_end",
+			input: `
+			define inner {
+				a: 1
+			}
+			define outer {
+				b: 2
+				use inner
+			}
+			{
+				use outer
+			}`,
+			want: []Token{"b", "2", "a", "1"},
+		}, {
+			name: "undefined",
+			input: `{
+				use common
+			}`,
+			wantErr: true,
+		}, {
+			name: "redefined",
+			input: `
+			define common {}
+			define common {}
+			{}`,
+			wantErr: true,
+		}, {
+			name: "recursive",
+			input: `
+			define common {
+				use common
+			}
+			{
+				use common
+			}`,
+			wantErr: true,
+		}, {
+			name: "missing_param",
+			input: `
+			define common {
+				a: $x
+			}
+			{
+				use common
+			}`,
+			wantErr: true,
+		}, {
+			name: "unknown_param",
+			input: `
+			define common {
+				a: $x
+			}
+			{
+				use common { x: 1 y: 2 }
+			}`,
+			wantErr: true,
+		}, {
+			name: "not_terminated",
+			input: `
+			define common {
+				a: 1
+			`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFields(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Reader.ReadStruct() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Reader.ReadStruct() fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader_SnippetErrorPosition(t *testing.T) {
+	input := `define common {
+	a: 'unterminated
+}
+{
+	use common
+}`
+	_, err := readFields(input)
+	if err == nil {
+		t.Fatal("Reader.ReadStruct() error = nil, want error")
+	}
+	want := "2:5: quoted string literal not terminated (in snippet 'common' used at 5:2)"
+	if err.Error() != want {
+		t.Errorf("Reader.ReadStruct() error = %q, want %q", err, want)
+	}
+}
@@ -4,12 +4,13 @@ import (
 	"fmt"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 )
 
 const EOF Token = ""
 
-var special = []Token{"{", "}", ":"}
+var special = []Token{"{", "}", ":", "[", "]", ","}
 var name_regexp = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
 
 type Token string
@@ -44,27 +45,148 @@ func (t Token) IsName() bool {
 	return name_regexp.MatchString(t.String())
 }
 
+// Quote returns a quoted string literal with the value s
+func Quote(s string) Token {
+	return Token(strconv.Quote(s))
+}
+
+// Literal returns a token with the value s, the value is quoted only if it can't be written as is
+func Literal(s string) Token {
+	if s == "" || strings.HasPrefix(s, "$") || strings.ContainsAny(s, " \t\r\n{}:[],\"'`#\\") {
+		return Quote(s)
+	}
+	return Token(s)
+}
+
+// Unescaped returns the value of a literal token.
+//
+// Quoted strings ('...' or "...") have their quotes removed and escape sequences replaced. Strings
+// in triple quotes (""" or three single quotes) can span multiple lines and have the indentation of
+// the closing quotes removed from every line. Raw strings (`...`) are returned verbatim.
+// Unquoted literals can not contain escape sequences.
 func (t Token) Unescaped() (Token, error) {
-	if len(t) > 0 {
-		// string in quotes
-		if quote := t[0]; quote == '"' || quote == '\'' {
-			// string is terminated and the closing quote is not escaped
-			if len(t) < 2 || t[len(t)-1] != quote || t[len(t)-2] == '\\' {
-				return EOF, fmt.Errorf("quoted string literal not terminated")
-			}
-			// remove quotes
-			t = t[1 : len(t)-1]
-			// unescape quotes
-			t = Token(strings.ReplaceAll(t.String(), fmt.Sprintf("\\%c", quote), string(quote)))
+	var s = t.String()
+	switch {
+	case strings.HasPrefix(s, "`"):
+		if len(s) < 2 || strings.IndexByte(s[1:], '`') != len(s)-2 {
+			return EOF, fmt.Errorf("raw string literal not terminated")
+		}
+		return Token(s[1 : len(s)-1]), nil
+	case strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''"):
+		body, err := stringBody(s, s[:3])
+		if err != nil {
+			return EOF, err
+		}
+		body, err = trimIndent(body)
+		if err != nil {
+			return EOF, err
 		}
+		body, err = unescape(body, true)
+		return Token(body), err
+	case strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'"):
+		body, err := stringBody(s, s[:1])
+		if err != nil {
+			return EOF, err
+		}
+		if strings.ContainsRune(body, '\n') {
+			return EOF, fmt.Errorf("quoted string literal not terminated before the end of line")
+		}
+		body, err = unescape(body, false)
+		return Token(body), err
 	}
-	// error any other escapes
-	if i := strings.IndexByte(t.String(), '\\'); i != -1 {
-		if i < len(t)-1 {
-			return EOF, fmt.Errorf("%s is not a recognized escape sequence", t[i:i+2])
+	// error any escapes outside of strings
+	if i := strings.IndexByte(s, '\\'); i != -1 {
+		if i < len(s)-1 {
+			return EOF, fmt.Errorf("%s is not a recognized escape sequence", s[i:i+2])
 		} else {
 			return EOF, fmt.Errorf("escape sequence incomplete")
 		}
 	}
 	return t, nil
 }
+
+// return contents of a quoted literal s between delim quotes, checking that it is properly terminated
+func stringBody(s string, delim string) (string, error) {
+	for i := len(delim); i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], delim) {
+			if i+len(delim) != len(s) {
+				return "", fmt.Errorf("unexpected characters after the end of quoted string literal")
+			}
+			return s[len(delim):i], nil
+		}
+	}
+	return "", fmt.Errorf("quoted string literal not terminated")
+}
+
+// replace escape sequences in s. If multiline is set, newlines are allowed and an escaped newline
+// is removed to continue the line
+func unescape(s string, multiline bool) (string, error) {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s, nil
+	}
+	var b strings.Builder
+	for len(s) > 0 {
+		if s[0] != '\\' {
+			i := strings.IndexByte(s, '\\')
+			if i == -1 {
+				i = len(s)
+			}
+			b.WriteString(s[:i])
+			s = s[i:]
+			continue
+		}
+		if len(s) < 2 {
+			return "", fmt.Errorf("escape sequence incomplete")
+		}
+		switch s[1] {
+		case '"', '\'', '`':
+			b.WriteByte(s[1])
+			s = s[2:]
+			continue
+		case '\n':
+			if multiline {
+				s = s[2:]
+				continue
+			}
+		}
+		value, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return "", fmt.Errorf("%s is not a recognized escape sequence", s[:2])
+		}
+		// byte escapes like \xff are single bytes, not characters
+		if multibyte {
+			b.WriteRune(value)
+		} else {
+			b.WriteByte(byte(value))
+		}
+		s = tail
+	}
+	return b.String(), nil
+}
+
+// remove the leading newline after opening quotes of a multiline string and the indentation of
+// the closing quotes from every line
+func trimIndent(s string) (string, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "\r"), "\n")
+	var lastLine = strings.LastIndexByte(s, '\n')
+	// closing quotes must be on a separate line preceded only by whitespace, otherwise no trimming is done
+	var indent = s[lastLine+1:]
+	if lastLine == -1 || strings.Trim(indent, " \t") != "" {
+		return s, nil
+	}
+	var lines = strings.Split(strings.TrimSuffix(s[:lastLine], "\r"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, indent) {
+			lines[i] = line[len(indent):]
+		} else if strings.Trim(line, " \t\r") == "" {
+			lines[i] = ""
+		} else {
+			return "", fmt.Errorf("line %d of multiline string is indented less than its closing quotes", i+1)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
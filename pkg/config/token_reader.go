@@ -28,6 +28,10 @@ func (t *TokenPosition) nextChar() {
 	t.Col += 1
 }
 
+func (t *TokenPosition) prevChar() {
+	t.Col -= 1
+}
+
 func (t *TokenPosition) nextLine() {
 	t.Line += 1
 	t.Col = 0
@@ -62,11 +66,10 @@ func (r *tokenReader) writeRune(c rune) {
 	r.token.WriteRune(c)
 }
 
-func (r *tokenReader) firstChar() rune {
-	if r.token.Len() == 0 {
-		return rune(0)
-	}
-	return rune(r.token.String()[0])
+// return the last read rune back to the reader, it will be read again by the next call
+func (r *tokenReader) unreadRune() {
+	r.reader.UnreadRune()
+	r.curPos.prevChar()
 }
 
 func (r *tokenReader) next() (t Token, p TokenPosition, err error) {
@@ -91,46 +94,32 @@ func (r *tokenReader) next() (t Token, p TokenPosition, err error) {
 		}
 
 		if c == '\\' {
+			// escape sequences are only allowed inside of quoted strings
 			c, _, err = r.reader.ReadRune()
 			r.curPos.nextChar()
 			if err != nil {
 				if err == io.EOF {
-					err = fmt.Errorf("%d:%d: unfinished escape sequence at EOF", r.curPos.Line, r.curPos.Col)
+					err = &Error{Pos: *r.curPos, Msg: "unfinished escape sequence at EOF"}
 				}
 				return
 			}
-			if quote := r.firstChar(); quote == '"' || quote == '\'' {
-				if c == quote {
-					r.token.WriteString(fmt.Sprintf("\\%c", c))
-					continue
-				}
-			}
-			err = fmt.Errorf("%d:%d: \\%c is not a recognized escape sequence", r.curPos.Line, r.curPos.Col, c)
+			err = &Error{Pos: *r.curPos, Msg: fmt.Sprintf("\\%c is not a recognized escape sequence", c)}
 			return
 		}
 
-		if quote := r.firstChar(); quote == '"' || quote == '\'' {
-			if c == quote {
-				r.writeRune(c)
-				t, p = r.popToken()
-				return
-			}
-			// newlines can't be part of a string, but the error will be raised from token validation
-			if c == '\n' {
+		if isQuote(c) {
+			// a string always starts a new token
+			if r.token.Len() > 0 {
+				r.unreadRune()
 				t, p = r.popToken()
 				return
 			}
 			r.writeRune(c)
-			continue
-		}
-		if c == '"' || c == '\'' {
-			if r.token.Len() > 0 {
-				t, p = r.popToken()
-				r.writeRune(c)
+			if err = r.readQuoted(c); err != nil {
 				return
 			}
-			r.writeRune(c)
-			continue
+			t, p = r.popToken()
+			return
 		}
 
 		if slices.Contains(whitespace, c) {
@@ -194,3 +183,77 @@ func (r *tokenReader) processComment() error {
 		}
 	}
 }
+
+func isQuote(c rune) bool {
+	return c == '"' || c == '\'' || c == '`'
+}
+
+// Read the rest of a string literal opened with quote. Triple quotes and backquotes start
+// multiline strings, other strings end at the newline. The raw text of the literal is
+// added to the token as is, escape sequences and termination are checked by Token.Unescaped
+func (r *tokenReader) readQuoted(quote rune) error {
+	var delim = string(quote)
+	if quote != '`' {
+		if next, err := r.reader.Peek(2); err == nil && string(next) == delim+delim {
+			r.reader.Discard(2)
+			r.curPos.nextChar()
+			r.curPos.nextChar()
+			r.token.WriteString(delim + delim)
+			delim = strings.Repeat(delim, 3)
+		}
+	}
+	var multiline = len(delim) == 3 || quote == '`'
+	// number of unescaped quotes in a row, the string ends when it matches the opening ones
+	var closing = 0
+
+	for {
+		c, _, err := r.reader.ReadRune()
+		r.curPos.nextChar()
+		if err != nil {
+			// unterminated string will be reported by the token validation, EOF is read again by the caller
+			if err == io.EOF {
+				r.curPos.prevChar()
+				return nil
+			}
+			return err
+		}
+		if c == '\n' {
+			r.curPos.nextLine()
+			// newlines can't be part of a string, but the error will be raised from token validation
+			if !multiline {
+				return nil
+			}
+		}
+		r.token.WriteRune(c)
+
+		if c == '\\' && quote != '`' {
+			// escaped character never closes the string
+			c, _, err = r.reader.ReadRune()
+			r.curPos.nextChar()
+			if err != nil {
+				if err == io.EOF {
+					r.curPos.prevChar()
+					return nil
+				}
+				return err
+			}
+			if c == '\n' {
+				r.curPos.nextLine()
+				if !multiline {
+					return nil
+				}
+			}
+			r.token.WriteRune(c)
+			closing = 0
+			continue
+		}
+		if c != quote {
+			closing = 0
+			continue
+		}
+		closing += 1
+		if closing == len(delim) {
+			return nil
+		}
+	}
+}
@@ -22,6 +22,10 @@ func TestTokenReader(t *testing.T) {
 			name:  "special",
 			input: "test:{case \t}",
 			want:  []Token{"test", ":", "{", "case", "}", EOF},
+		}, {
+			name:  "list",
+			input: "index: [a.html,b.html, 'c,d' ]",
+			want:  []Token{"index", ":", "[", "a.html", ",", "b.html", ",", "'c,d'", "]", EOF},
 		}, {
 			name:  "empty",
 			input: "",
@@ -52,6 +56,34 @@ func TestTokenReader(t *testing.T) {
 				"'special: {}'",
 				"'new", "line",
 				EOF},
+		}, {
+			name:  "escapes",
+			input: `'\\' "\t\"" '\u00e9'`,
+			want:  []Token{`'\\'`, `"\t\""`, `'\u00e9'`, EOF},
+		}, {
+			name: "multiline",
+			input: `body: """
+				<h1>"quoted"</h1>
+				<p>{text}</p>
+				""" next
+				raw` + "`C:\\path\n# not a comment`" + `
+				'''single'''`,
+			want: []Token{
+				"body", ":", `"""
+				<h1>"quoted"</h1>
+				<p>{text}</p>
+				"""`, "next",
+				"raw", "`C:\\path\n# not a comment`",
+				"'''single'''",
+				EOF},
+		}, {
+			name:  "multiline_escaped_quotes",
+			input: `"""a\"""" b`,
+			want:  []Token{`"""a\""""`, "b", EOF},
+		}, {
+			name:  "empty_strings",
+			input: `"" ''`,
+			want:  []Token{`""`, "''", EOF},
 		},
 	}
 	for _, tt := range tests {
@@ -76,6 +108,42 @@ func TestTokenReader(t *testing.T) {
 	}
 }
 
+func TestTokenPosition_Multiline(t *testing.T) {
+	type tokenWithPos struct {
+		t Token
+		p TokenPosition
+	}
+	input := "a: \"\"\"\nline\n\"\"\" b\n`raw\n` 'str'\n\"x\\\ny\" c"
+	want := []tokenWithPos{
+		{"a", *position(1, 1)},
+		{":", *position(1, 2)},
+		{"\"\"\"\nline\n\"\"\"", *position(1, 4)},
+		{"b", *position(3, 5)},
+		{"`raw\n`", *position(4, 1)},
+		{"'str'", *position(5, 3)},
+		{"\"x\\", *position(6, 1)},
+		{"y", *position(7, 1)},
+		{"\" c", *position(7, 2)},
+		{EOF, *position(7, 5)},
+	}
+	var got []tokenWithPos
+	var reader = newTokenReader(strings.NewReader(input))
+	for {
+		tok, pos, err := reader.next()
+		if err != nil {
+			t.Errorf("tokenReader.next() error = %v", err)
+			return
+		}
+		got = append(got, tokenWithPos{tok, pos})
+		if tok == EOF {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenReader.next() tokens = %v, want = %v", got, want)
+	}
+}
+
 func TestTokenPosition(t *testing.T) {
 	type tokenWithPos struct {
 		t Token
@@ -40,6 +40,19 @@ func TestToken_Unescaped(t *testing.T) {
 		{"escaped", `'\'string\''`, "'string'", false},
 		{"escaped_end", `'string\'`, "", true},
 		{"unknown_escape", `'strin\g'`, "", true},
+		{"escapes", `"a\tb\nc\\d\"e\'f"`, "a\tb\nc\\d\"e'f", false},
+		{"escapes_unicode", `'caf\u00e9 \x41\U0001F600'`, "café A\U0001F600", false},
+		{"escapes_bytes", `'\xff\377\xc3\xa9'`, "\xff\xffé", false},
+		{"escape_incomplete", `'\u00e'`, "", true},
+		{"trailing_characters", `'string'tail'`, "", true},
+		{"raw", "`C:\\path\\n\n'quoted'`", "C:\\path\\n\n'quoted'", false},
+		{"raw_not_terminated", "`string", "", true},
+		{"triple", "\"\"\"\n\t\tline 1\n\t\t  line 2\n\n\t\t\"quoted\"\\t\n\t\t\"\"\"", "line 1\n  line 2\n\n\"quoted\"\t", false},
+		{"triple_single", "'''one\ntwo'''", "one\ntwo", false},
+		{"triple_continuation", "\"\"\"\n  one \\\n  two\n  \"\"\"", "one two", false},
+		{"triple_bad_indent", "\"\"\"\n  one\n two\n  \"\"\"", "", true},
+		{"triple_not_terminated", "\"\"\"\nline\"\"", "", true},
+		{"newline", "'new\nline'", "", true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -57,3 +70,30 @@ func TestToken_Unescaped(t *testing.T) {
 		})
 	}
 }
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want Token
+	}{
+		{"plain", "/var/www", "/var/www"},
+		{"empty", "", `""`},
+		{"space", "E:/test website/", `"E:/test website/"`},
+		{"separator", "http://host", `"http://host"`},
+		{"param", "$dir", `"$dir"`},
+		{"quotes", `say "hi"`, `"say \"hi\""`},
+		{"list", "a,b", `"a,b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Literal(tt.s)
+			if got != tt.want {
+				t.Errorf("Literal() = %v, want %v", got, tt.want)
+			}
+			if back, err := got.Unescaped(); err != nil || back.String() != tt.s {
+				t.Errorf("Literal().Unescaped() = %v, %v, want %v", back, err, tt.s)
+			}
+		})
+	}
+}
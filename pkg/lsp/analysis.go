@@ -0,0 +1,536 @@
+package lsp
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/arrowinaknee/switchman/pkg/appconfig"
+	"github.com/arrowinaknee/switchman/pkg/config"
+)
+
+// What a token means in the config
+type role int
+
+const (
+	roleNone       role = iota
+	roleKeyword         // server, define and use
+	roleProperty        // name of a property in a known block
+	roleKey             // user defined key, like endpoint location
+	roleVariant         // variant of a keyed entry, like endpoint type
+	roleValue           // property value
+	roleSnippetDef      // snippet name in definition
+	roleSnippetUse      // snippet name in usage
+	roleParam           // parameter name in snippet usage
+)
+
+type token struct {
+	text  config.Token
+	start config.TokenPosition
+	// position right after the last character of the token
+	end config.TokenPosition
+	// index in the tokens of the document
+	index int
+
+	role    role
+	block   *appconfig.Block
+	prop    *appconfig.Property
+	snippet *snippetDef
+}
+
+func (t *token) contains(p config.TokenPosition) bool {
+	return comparePos(t.start, p) <= 0 && comparePos(p, t.end) <= 0
+}
+
+type snippetDef struct {
+	name   config.Token
+	tok    *token
+	params []config.Token
+}
+
+type frameKind int
+
+const (
+	frameBlock   frameKind = iota
+	frameSnippet           // body of a snippet definition
+	frameArgs              // parameters set at the usage of a snippet
+)
+
+// Part of the document between braces
+type frame struct {
+	kind  frameKind
+	begin *token
+	// nil if the frame is not closed
+	end *token
+	// nil if the block is not known, like in snippet definitions
+	block   *appconfig.Block
+	snippet *snippetDef
+}
+
+// Tokens of a document with their meanings
+type analysis struct {
+	tokens   []*token
+	byPos    map[config.TokenPosition]*token
+	snippets map[config.Token]*snippetDef
+	frames   map[*token]*frame
+	// error that stopped the tokenization, tokens before it are still available
+	err error
+	// first error of the config parser, the same that the server would report
+	parseErr error
+}
+
+func analyze(text string) *analysis {
+	a := &analysis{
+		byPos:    make(map[config.TokenPosition]*token),
+		snippets: make(map[config.Token]*snippetDef),
+		frames:   make(map[*token]*frame),
+	}
+	r := config.NewReader(strings.NewReader(text))
+	for {
+		t, err := r.ReadNext()
+		if err != nil {
+			a.err = err
+			break
+		}
+		if t == config.EOF {
+			break
+		}
+		tok := &token{text: t, start: r.Position(), end: tokenEnd(t, r.Position()), index: len(a.tokens)}
+		a.tokens = append(a.tokens, tok)
+		a.byPos[tok.start] = tok
+	}
+	a.parse(text)
+	return a
+}
+
+func tokenEnd(t config.Token, start config.TokenPosition) config.TokenPosition {
+	s := t.String()
+	i := strings.LastIndexByte(s, '\n')
+	if i == -1 {
+		return config.TokenPosition{Line: start.Line, Col: start.Col + utf8.RuneCountInString(s)}
+	}
+	return config.TokenPosition{
+		Line: start.Line + strings.Count(s, "\n"),
+		Col:  utf8.RuneCountInString(s[i+1:]) + 1,
+	}
+}
+
+// Read the document with the config parser, tokens get their meanings from what it reads. The
+// parser stops at the first error, so the lines with errors are blanked out and the rest is
+// read again, until it is read to the end or the error is not at a token that can be skipped
+func (a *analysis) parse(text string) {
+	lines := strings.Split(text, "\n")
+	source := make([][]rune, len(lines))
+	for i, line := range lines {
+		source[i] = []rune(line)
+	}
+	remaining := a.tokens
+	for {
+		var b strings.Builder
+		for i, line := range source {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(string(line))
+		}
+		conf := config.NewReader(strings.NewReader(b.String()))
+		conf.Listen(&listener{a: a})
+		_, err := appconfig.ReadServer(conf)
+		if a.parseErr == nil {
+			a.parseErr = err
+		}
+		var confErr *config.Error
+		if err == nil || !errors.As(err, &confErr) {
+			return
+		}
+		lo, hi, ok := skippedAt(remaining, confErr.Pos)
+		if !ok {
+			return
+		}
+		// frames that were cut by the error end with the skipped tokens
+		for _, f := range a.frames {
+			if f.end == nil && f.begin.index >= remaining[lo].index && f.begin.index <= remaining[hi].index {
+				f.end = remaining[hi]
+			}
+		}
+		for _, t := range remaining[lo : hi+1] {
+			blank(source, t)
+		}
+		remaining = append(remaining[:lo:lo], remaining[hi+1:]...)
+	}
+}
+
+// Range of tokens to skip for an error at pos: the line of the error, and blocks and lists that
+// are opened or closed on it, so that the braces of the rest still match
+func skippedAt(tokens []*token, pos config.TokenPosition) (lo, hi int, ok bool) {
+	lo = -1
+	for i, t := range tokens {
+		if t.start == pos {
+			lo = i
+			break
+		}
+	}
+	if lo == -1 {
+		return 0, 0, false
+	}
+	hi = lo
+	for {
+		for lo > 0 && tokens[lo-1].end.Line == tokens[lo].start.Line {
+			lo--
+		}
+		for hi < len(tokens)-1 && tokens[hi+1].start.Line == tokens[hi].end.Line {
+			hi++
+		}
+		opened, closed := 0, 0
+		for _, t := range tokens[lo : hi+1] {
+			switch t.text {
+			case "{", "[":
+				opened++
+			case "}", "]":
+				if opened > 0 {
+					opened--
+				} else {
+					closed++
+				}
+			}
+		}
+		switch {
+		case closed > 0 && lo > 0:
+			for ; closed > 0 && lo > 0; lo-- {
+				switch tokens[lo-1].text {
+				case "{", "[":
+					closed--
+				case "}", "]":
+					closed++
+				}
+			}
+		case opened > 0 && hi < len(tokens)-1:
+			for ; opened > 0 && hi < len(tokens)-1; hi++ {
+				switch tokens[hi+1].text {
+				case "{", "[":
+					opened++
+				case "}", "]":
+					opened--
+				}
+			}
+		default:
+			return lo, hi, true
+		}
+	}
+}
+
+// Replace the characters of a token with spaces, keeping the positions of the rest
+func blank(source [][]rune, t *token) {
+	for line := t.start.Line; line <= t.end.Line && line <= len(source); line++ {
+		chars := source[line-1]
+		from, to := 0, len(chars)
+		if line == t.start.Line {
+			from = t.start.Col - 1
+		}
+		if line == t.end.Line {
+			to = min(t.end.Col-1, len(chars))
+		}
+		for i := from; i < to; i++ {
+			chars[i] = ' '
+		}
+	}
+}
+
+// Token after t, nil if t is the last one
+func (a *analysis) next(t *token) *token {
+	if t.index+1 < len(a.tokens) {
+		return a.tokens[t.index+1]
+	}
+	return nil
+}
+
+// Token before t, nil if t is the first one
+func (a *analysis) prev(t *token) *token {
+	if t.index > 0 {
+		return a.tokens[t.index-1]
+	}
+	return nil
+}
+
+// Brace that closes the one at t, nil if it is not closed
+func (a *analysis) closing(t *token) *token {
+	depth := 0
+	for _, c := range a.tokens[t.index:] {
+		switch c.text {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth == 0 {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// Frame that starts at the brace t, created on the first read of it
+func (a *analysis) frameAt(t *token, kind frameKind) (f *frame, created bool) {
+	if f, ok := a.frames[t]; ok {
+		return f, false
+	}
+	f = &frame{kind: kind, begin: t}
+	a.frames[t] = f
+	return f, true
+}
+
+// Innermost frame around the token with index i
+func (a *analysis) frameAround(i int) *frame {
+	var inner *frame
+	for _, f := range a.frames {
+		if f.begin.index < i && (f.end == nil || f.end.index >= i) {
+			if inner == nil || f.begin.index > inner.begin.index {
+				inner = f
+			}
+		}
+	}
+	return inner
+}
+
+// Token at pos that has no meaning yet. Tokens are read again after errors and the ones of
+// snippets each time they are used, the meaning they are first read with is kept
+func (a *analysis) unmarked(pos config.TokenPosition) *token {
+	if t := a.byPos[pos]; t != nil && t.role == roleNone {
+		return t
+	}
+	return nil
+}
+
+// Listener of the config parser that gives tokens their meanings by the schema
+type listener struct {
+	a      *analysis
+	frames []*frame
+	// what the values of the current field are read for
+	key   *appconfig.Block    // keyed block whose variant is read next
+	prop  *appconfig.Property // property of the values
+	block *appconfig.Block    // block of the next struct
+	// snippet whose parameters are set
+	usage *snippetDef
+}
+
+func (l *listener) reset() {
+	l.key, l.prop, l.block = nil, nil, nil
+}
+
+func (l *listener) BeginStruct(pos config.TokenPosition) {
+	t := l.a.byPos[pos]
+	if t == nil {
+		return
+	}
+	block := l.block
+	if len(l.frames) == 0 {
+		// the server block is read after its keyword, which is not a field
+		block = appconfig.ServerSchema
+		if k := l.a.prev(t); k != nil && k.text == "server" && k.role == roleNone {
+			k.role, k.block = roleKeyword, block
+		}
+	}
+	f, created := l.a.frameAt(t, frameBlock)
+	if created {
+		f.block = block
+	}
+	l.frames = append(l.frames, f)
+	l.reset()
+}
+
+func (l *listener) EndStruct(pos config.TokenPosition) {
+	if len(l.frames) == 0 {
+		return
+	}
+	f := l.frames[len(l.frames)-1]
+	if f.end == nil {
+		f.end = l.a.byPos[pos]
+	}
+	l.frames = l.frames[:len(l.frames)-1]
+	l.reset()
+}
+
+func (l *listener) Field(name config.Token, pos config.TokenPosition) {
+	l.reset()
+	if len(l.frames) == 0 {
+		return
+	}
+	b := l.frames[len(l.frames)-1].block
+	if b == nil {
+		return
+	}
+	t := l.a.unmarked(pos)
+	if b.Key != "" {
+		l.key = b
+		if t != nil {
+			t.role, t.block = roleKey, b
+		}
+		return
+	}
+	p := b.Property(name.String())
+	if p == nil {
+		return
+	}
+	if t != nil {
+		t.role, t.block, t.prop = roleProperty, b, p
+	}
+	switch {
+	case p.Block != nil:
+		l.block = p.Block
+	case p.Type == appconfig.TypeMap:
+		// names in maps are user defined
+	default:
+		l.prop = p
+	}
+}
+
+func (l *listener) Value(value config.Token, pos config.TokenPosition) {
+	t := l.a.unmarked(pos)
+	if l.key != nil {
+		v := l.key.Variant(value.String())
+		l.key = nil
+		if t != nil {
+			t.role, t.block = roleVariant, v
+		}
+		if v != nil && v.Value != "" {
+			l.prop = &appconfig.Property{Name: v.Name, Type: v.Value, Doc: v.Doc}
+		} else {
+			l.block = v
+		}
+		return
+	}
+	if t != nil {
+		t.role, t.prop = roleValue, l.prop
+	}
+}
+
+func (l *listener) List(items []config.Token, pos config.TokenPosition) {
+	t := l.a.byPos[pos]
+	if t == nil {
+		return
+	}
+	if t.text != "[" {
+		l.Value(t.text, pos)
+		return
+	}
+	for c := l.a.next(t); c != nil && c.text != "]"; c = l.a.next(c) {
+		if c.text.IsLiteral() && c.role == roleNone {
+			c.role, c.prop = roleValue, l.prop
+		}
+	}
+}
+
+func (l *listener) Define(name config.Token, params []config.Token, pos config.TokenPosition) {
+	t := l.a.byPos[pos]
+	if t == nil {
+		return
+	}
+	def, ok := l.a.snippets[name]
+	if !ok {
+		def = &snippetDef{name: name, tok: t, params: params}
+		l.a.snippets[name] = def
+	}
+	if t.role == roleNone {
+		t.role, t.snippet = roleSnippetDef, def
+	}
+	if k := l.a.prev(t); k != nil && k.text == "define" {
+		k.role = roleKeyword
+	}
+	if open := l.a.next(t); open != nil && open.text == "{" {
+		if f, created := l.a.frameAt(open, frameSnippet); created {
+			f.end, f.snippet = l.a.closing(open), def
+		}
+	}
+}
+
+func (l *listener) Use(name config.Token, pos config.TokenPosition) {
+	t := l.a.byPos[pos]
+	if t == nil {
+		return
+	}
+	l.usage = l.a.snippets[name]
+	if t.role == roleNone {
+		t.role, t.snippet = roleSnippetUse, l.usage
+	}
+	if k := l.a.prev(t); k != nil && k.text == "use" {
+		k.role = roleKeyword
+	}
+	if open := l.a.next(t); open != nil && open.text == "{" {
+		if f, created := l.a.frameAt(open, frameArgs); created {
+			f.end, f.snippet = l.a.closing(open), l.usage
+		}
+	}
+}
+
+func (l *listener) Argument(param config.Token, pos config.TokenPosition) {
+	if t := l.a.unmarked(pos); t != nil {
+		t.role, t.snippet = roleParam, l.usage
+	}
+}
+
+// Find the token at position p
+func (a *analysis) tokenAt(p config.TokenPosition) *token {
+	for _, t := range a.tokens {
+		if t.contains(p) && t.text.IsLiteral() {
+			return t
+		}
+	}
+	return nil
+}
+
+// What the next token is expected to be
+type expect int
+
+const (
+	expectNone expect = iota
+	expectKeyword
+	expectField
+	expectVariant
+	expectSnippetUse
+	expectParam
+)
+
+// Context of a position in the config
+type context struct {
+	expect expect
+	// block of the fields or variants
+	block *appconfig.Block
+	// snippet of the parameters
+	snippet *snippetDef
+}
+
+// Find what is expected at position p from the meanings of the tokens before it, the literal
+// that is being typed at p is not included
+func (a *analysis) contextAt(p config.TokenPosition) context {
+	i := 0
+	for ; i < len(a.tokens); i++ {
+		t := a.tokens[i]
+		if comparePos(t.start, p) >= 0 || t.text.IsLiteral() && comparePos(p, t.end) <= 0 {
+			break
+		}
+	}
+	var prev *token
+	if i > 0 {
+		prev = a.tokens[i-1]
+	}
+
+	f := a.frameAround(i)
+	switch {
+	case f == nil:
+		if prev == nil || prev.text == "}" {
+			return context{expect: expectKeyword}
+		}
+	case f.kind == frameArgs:
+		if prev.text != ":" && prev.role != roleParam {
+			return context{expect: expectParam, snippet: f.snippet}
+		}
+	case prev.text == "use":
+		return context{expect: expectSnippetUse}
+	case prev.text == ":":
+		if key := a.prev(prev); key != nil && key.role == roleKey {
+			return context{expect: expectVariant, block: key.block}
+		}
+	case prev.text == "{" || prev.text == "}" || prev.text == "]" || prev.role == roleValue:
+		return context{expect: expectField, block: f.block}
+	}
+	return context{expect: expectNone}
+}
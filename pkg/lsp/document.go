@@ -0,0 +1,83 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/arrowinaknee/switchman/pkg/config"
+)
+
+// Text of an open document. Config positions count lines from 1 and characters in runes from 1,
+// LSP positions count lines from 0 and characters in UTF-16 code units from 0
+type document struct {
+	text  string
+	lines []string
+}
+
+func newDocument(text string) *document {
+	return &document{
+		text:  text,
+		lines: strings.Split(text, "\n"),
+	}
+}
+
+func (d *document) line(n int) string {
+	if n < 0 || n >= len(d.lines) {
+		return ""
+	}
+	return d.lines[n]
+}
+
+func (d *document) toLSP(p config.TokenPosition) Position {
+	line := d.line(p.Line - 1)
+	char := 0
+	for i, c := range []rune(line) {
+		if i >= p.Col-1 {
+			break
+		}
+		char += utf16Len(c)
+	}
+	return Position{Line: max(p.Line-1, 0), Character: char}
+}
+
+func (d *document) fromLSP(p Position) config.TokenPosition {
+	line := d.line(p.Line)
+	col := 1
+	units := 0
+	for _, c := range line {
+		if units >= p.Character {
+			break
+		}
+		units += utf16Len(c)
+		col += 1
+	}
+	return config.TokenPosition{Line: p.Line + 1, Col: col}
+}
+
+func (d *document) toRange(start, end config.TokenPosition) Range {
+	return Range{Start: d.toLSP(start), End: d.toLSP(end)}
+}
+
+// Range of the whole document
+func (d *document) fullRange() Range {
+	last := len(d.lines) - 1
+	return Range{
+		Start: Position{0, 0},
+		End:   Position{last, d.toLSP(config.TokenPosition{Line: last + 1, Col: utf8.RuneCountInString(d.lines[last]) + 1}).Character},
+	}
+}
+
+func utf16Len(c rune) int {
+	if c >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// Compare positions, returns a negative number if a is before b, positive if after, 0 if equal
+func comparePos(a, b config.TokenPosition) int {
+	if a.Line != b.Line {
+		return a.Line - b.Line
+	}
+	return a.Col - b.Col
+}
@@ -0,0 +1,187 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/arrowinaknee/switchman/pkg/appconfig"
+	"github.com/arrowinaknee/switchman/pkg/config"
+)
+
+var keywordDocs = map[config.Token]string{
+	"server": appconfig.ServerSchema.Doc,
+	"define": "Defines a snippet, a named list of fields that can be inserted into any block with `use`. Tokens like `$name` in the snippet are parameters",
+	"use":    "Inserts the fields of a snippet defined with `define`, parameters are set with `use name { param: value }`",
+}
+
+// Check the document with the config parser, and values of properties with their types. The
+// parser stops at the first error, values are checked in the whole document
+func diagnose(uri string, doc *document, a *analysis) []Diagnostic {
+	diags := []Diagnostic{}
+	var confErr *config.Error
+	if err := a.parseErr; err != nil {
+		diag := Diagnostic{
+			Severity: SeverityError,
+			Source:   "switchman",
+			Message:  err.Error(),
+		}
+		if errors.As(err, &confErr) {
+			diag.Message = confErr.Msg
+			diag.Range = doc.toRange(confErr.Pos, confErr.Pos)
+			if t := a.tokenStartingAt(confErr.Pos); t != nil {
+				diag.Range.End = doc.toLSP(t.end)
+			}
+			for _, use := range confErr.Trace {
+				diag.RelatedInformation = append(diag.RelatedInformation, DiagnosticRelatedInformation{
+					Location: Location{URI: uri, Range: doc.toRange(use.Pos, use.Pos)},
+					Message:  fmt.Sprintf("snippet %s is used here", use.Name.Quote()),
+				})
+			}
+		}
+		diags = append(diags, diag)
+	}
+
+	for _, t := range a.tokens {
+		if t.role != roleValue || t.prop == nil || confErr != nil && confErr.Pos == t.start {
+			continue
+		}
+		value, err := t.text.Unescaped()
+		if err != nil {
+			continue
+		}
+		if err := t.prop.CheckValue(value.String()); err != nil {
+			diags = append(diags, Diagnostic{
+				Range:    doc.toRange(t.start, t.end),
+				Severity: SeverityError,
+				Source:   "switchman",
+				Message:  err.Error(),
+			})
+		}
+	}
+	return diags
+}
+
+func (a *analysis) tokenStartingAt(p config.TokenPosition) *token {
+	return a.byPos[p]
+}
+
+func complete(a *analysis, p config.TokenPosition) []CompletionItem {
+	var items = []CompletionItem{}
+	c := a.contextAt(p)
+	switch c.expect {
+	case expectKeyword:
+		for _, k := range []config.Token{"server", "define"} {
+			items = append(items, CompletionItem{Label: k.String(), Kind: CompletionItemKindKeyword, Documentation: markdown(keywordDocs[k])})
+		}
+	case expectField:
+		if b := c.block; b != nil {
+			for _, prop := range b.Properties {
+				items = append(items, CompletionItem{
+					Label:         prop.Name,
+					Kind:          CompletionItemKindProperty,
+					Detail:        b.Name + " property",
+					Documentation: markdown(propertyDoc(prop)),
+				})
+			}
+		}
+		items = append(items, CompletionItem{Label: "use", Kind: CompletionItemKindKeyword, Documentation: markdown(keywordDocs["use"])})
+	case expectVariant:
+		for _, v := range c.block.Variants {
+			items = append(items, CompletionItem{
+				Label:         v.Name,
+				Kind:          CompletionItemKindClass,
+				Detail:        c.block.Name + " type",
+				Documentation: markdown(v.Doc),
+			})
+		}
+	case expectSnippetUse:
+		for name, def := range a.snippets {
+			// snippets can only be used after they are defined
+			if comparePos(def.tok.start, p) < 0 {
+				items = append(items, CompletionItem{Label: name.String(), Kind: CompletionItemKindSnippet, Detail: snippetSignature(def)})
+			}
+		}
+		slices.SortFunc(items, func(a, b CompletionItem) int {
+			return strings.Compare(a.Label, b.Label)
+		})
+	case expectParam:
+		if def := c.snippet; def != nil {
+			for _, param := range def.params {
+				items = append(items, CompletionItem{Label: param.String(), Kind: CompletionItemKindVariable, Detail: "parameter of " + def.name.String()})
+			}
+		}
+	}
+	return items
+}
+
+func hover(doc *document, a *analysis, p config.TokenPosition) *Hover {
+	t := a.tokenAt(p)
+	if t == nil {
+		return nil
+	}
+	var text string
+	switch t.role {
+	case roleKeyword:
+		text = keywordDocs[t.text]
+	case roleProperty:
+		text = fmt.Sprintf("**%s** (%s property)\n\n%s", t.prop.Name, t.block.Name, propertyDoc(t.prop))
+	case roleKey:
+		text = fmt.Sprintf("%s of %s", t.block.Key, t.block.Name)
+	case roleVariant:
+		if t.block != nil {
+			text = fmt.Sprintf("**%s**\n\n%s", t.block.Name, t.block.Doc)
+		}
+	case roleValue:
+		if t.prop != nil {
+			text = fmt.Sprintf("value of **%s**\n\n%s", t.prop.Name, propertyDoc(t.prop))
+		}
+	case roleSnippetDef, roleSnippetUse:
+		if t.snippet != nil {
+			text = fmt.Sprintf("snippet **%s**", snippetSignature(t.snippet))
+		}
+	case roleParam:
+		if t.snippet != nil {
+			text = fmt.Sprintf("parameter of snippet **%s**", snippetSignature(t.snippet))
+		}
+	}
+	if text == "" {
+		return nil
+	}
+	r := doc.toRange(t.start, t.end)
+	return &Hover{Contents: *markdown(text), Range: &r}
+}
+
+// Documentation of a property with its type and default value
+func propertyDoc(p *appconfig.Property) string {
+	doc := fmt.Sprintf("%s\n\nType: `%s`", p.Doc, p.Type)
+	if p.Default != "" {
+		doc += fmt.Sprintf(", default: `%s`", p.Default)
+	}
+	return doc
+}
+
+func snippetSignature(def *snippetDef) string {
+	if len(def.params) == 0 {
+		return def.name.String()
+	}
+	params := make([]string, len(def.params))
+	for i, p := range def.params {
+		params[i] = "$" + p.String()
+	}
+	return fmt.Sprintf("%s { %s }", def.name, strings.Join(params, " "))
+}
+
+// Find the definition of a snippet used at p
+func definition(uri string, doc *document, a *analysis, p config.TokenPosition) *Location {
+	t := a.tokenAt(p)
+	if t == nil || t.snippet == nil {
+		return nil
+	}
+	if t.role != roleSnippetUse && t.role != roleParam {
+		return nil
+	}
+	def := t.snippet.tok
+	return &Location{URI: uri, Range: doc.toRange(def.start, def.end)}
+}
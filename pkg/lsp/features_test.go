@@ -0,0 +1,230 @@
+package lsp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arrowinaknee/switchman/pkg/appconfig"
+)
+
+// Split the source at the '|' cursor mark, returning the document and the cursor position in it
+func withCursor(source string) (*document, Position) {
+	i := strings.IndexByte(source, '|')
+	before := source[:i]
+	line := strings.Count(before, "\n")
+	char := len([]rune(before[strings.LastIndexByte(before, '\n')+1:]))
+	return newDocument(before + source[i+1:]), Position{line, char}
+}
+
+func labels(items []CompletionItem) []string {
+	var result []string
+	for _, item := range items {
+		result = append(result, item.Label)
+	}
+	return result
+}
+
+// Properties of the server block in the schema
+func serverProperties() []string {
+	var result []string
+	for _, p := range appconfig.ServerSchema.Properties {
+		result = append(result, p.Name)
+	}
+	return result
+}
+
+// Properties of an endpoint type in the schema
+func endpointProperties(variant string) []string {
+	var result []string
+	for _, p := range appconfig.ServerSchema.Property("endpoints").Block.Variant(variant).Properties {
+		result = append(result, p.Name)
+	}
+	return result
+}
+
+func Test_complete(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "top_level",
+			source: "|",
+			want:   []string{"server", "define"},
+		}, {
+			name:   "server",
+			source: "server {\n\t|\n}",
+			want:   append(serverProperties(), "use"),
+		}, {
+			name:   "endpoint_type",
+			source: "server {\n\tendpoints {\n\t\t/test: pr|\n\t}\n}",
+			want:   []string{"files", "redirect", "proxy", "respond"},
+		}, {
+			name:   "endpoint_property",
+			source: "server {\n\tendpoints {\n\t\t/test: proxy {\n\t\t\t|\n\t\t}\n\t}\n}",
+			want:   append(endpointProperties("proxy"), "use"),
+		}, {
+			name:   "after_errors",
+			source: "server {\n\tendpoints {\n\t\t/a: proxy { url }\n\t\t/test: files {\n\t\t\ts|",
+			want:   append(endpointProperties("files"), "use"),
+		}, {
+			name:   "after_block_with_errors",
+			source: "server {\n\tendpoints {\n\t\t/a: unknown {\n\t\t\tx: y\n\t\t}\n\t\t/test: files {\n\t\t\t|\n\t\t}\n\t}\n}",
+			want:   append(endpointProperties("files"), "use"),
+		}, {
+			name:   "after_list",
+			source: "server {\n\tendpoints {\n\t\t/test: files {\n\t\t\tindex: [a, 'b']\n\t\t\t|",
+			want:   append(endpointProperties("files"), "use"),
+		}, {
+			name:   "list_item",
+			source: "server {\n\tendpoints {\n\t\t/test: files {\n\t\t\tindex: [a, |",
+			want:   nil,
+		}, {
+			name:   "value",
+			source: "server {\n\tendpoints {\n\t\t/test: files {\n\t\t\tsources: |",
+			want:   nil,
+		}, {
+			name:   "after_map",
+			source: "server {\n\tendpoints {\n\t\t/test: respond {\n\t\t\theaders {\n\t\t\t\tX-A: b\n\t\t\t}\n\t\t\t|",
+			want:   append(endpointProperties("respond"), "use"),
+		}, {
+			name:   "error_page_type",
+			source: "server {\n\terrors {\n\t\t404: f|\n\t}\n}",
+			want:   []string{"file", "template", "proxy"},
+		}, {
+			name:   "after_error_page",
+			source: "server {\n\terrors {\n\t\t404: file 404.html\n\t}\n\t|\n}",
+			want:   append(serverProperties(), "use"),
+		}, {
+			name:   "snippet",
+			source: "define b {}\ndefine a { x: $p }\nserver {\n\tuse |\n}\ndefine c {}",
+			want:   []string{"a", "b"},
+		}, {
+			name:   "snippet_params",
+			source: "define a {\n\tx: $one\n\ty: $two\n}\nserver {\n\tuse a { |",
+			want:   []string{"one", "two"},
+		}, {
+			name:   "snippet_body",
+			source: "define a {\n\t|\n}\nserver {}",
+			want:   []string{"use"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, pos := withCursor(tt.source)
+			got := labels(complete(analyze(doc.text), doc.fromLSP(pos)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("complete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_hover(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "property",
+			source: "server {\n\tendpoints {\n\t\t/test: files {\n\t\t\tsou|rces: /\n\t\t}\n\t}\n}",
+			want:   "**sources** (files property)",
+		}, {
+			name:   "endpoint_type",
+			source: "server {\n\tendpoints {\n\t\t/test: prox|y {}\n\t}\n}",
+			want:   "**proxy**",
+		}, {
+			name:   "error_page_value",
+			source: "server {\n\terrors {\n\t\t5xx: template err|or.html\n\t}\n}",
+			want:   "value of **template**",
+		}, {
+			name:   "snippet",
+			source: "define common { url: $x }\nserver {\n\tuse com|mon { x: 1 }\n}",
+			want:   "snippet **common { $x }**",
+		}, {
+			name:   "snippet_body",
+			source: "define s { sou|rces: $x }\nserver {\n\tendpoints {\n\t\t/a: files { use s { x: / } }\n\t}\n}",
+			want:   "**sources** (files property)",
+		}, {
+			name:   "none",
+			source: "server {\n\tendpoints {\n\t\t/test: files {\n\t\t\tunknown|: /\n\t\t}\n\t}\n}",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, pos := withCursor(tt.source)
+			h := hover(doc, analyze(doc.text), doc.fromLSP(pos))
+			var got string
+			if h != nil {
+				got, _, _ = strings.Cut(h.Contents.Value, "\n")
+			}
+			if got != tt.want {
+				t.Errorf("hover() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_definition(t *testing.T) {
+	doc, pos := withCursor("define common { url: $x }\nserver {\n\tuse co|mmon { x: 1 }\n}")
+	got := definition("file:///test", doc, analyze(doc.text), doc.fromLSP(pos))
+	want := &Location{URI: "file:///test", Range: Range{Position{0, 7}, Position{0, 13}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("definition() = %v, want %v", got, want)
+	}
+}
+
+func Test_diagnose(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []Range
+		related int
+	}{
+		{
+			name:   "valid",
+			source: "server {\n\tendpoints {\n\t\t/test: files { sources: / }\n\t}\n}",
+			want:   nil,
+		}, {
+			name:   "unrecognized",
+			source: "server {\n\tendpoints {\n\t\t/test: files { source: / }\n\t}\n}",
+			want:   []Range{{Position{2, 17}, Position{2, 23}}},
+		}, {
+			name:    "in_snippet",
+			source:  "define s {\n\tsource: $x\n}\nserver {\n\tendpoints {\n\t\t/test: files { use s { x: / } }\n\t}\n}",
+			want:    []Range{{Position{1, 1}, Position{1, 7}}},
+			related: 1,
+		}, {
+			name:   "value_type",
+			source: "server {\n\tendpoints {\n\t\t/api: proxy { timeout: 30 }\n\t}\n}",
+			want:   []Range{{Position{2, 25}, Position{2, 27}}},
+		}, {
+			name:   "value_types_after_error",
+			source: "server {\n\tendpoints {\n\t\t/a: files { source: / }\n\t\t/b: proxy { keepalive: many, dial_timeout: \"1m\" }\n\t\t/c: proxy { keepalive: off, upgrade_idle_timeout: off }\n\t}\n}",
+			want:   []Range{{Position{2, 14}, Position{2, 20}}, {Position{3, 25}, Position{3, 29}}},
+		}, {
+			name:   "unicode",
+			source: "server {\n\t\"😀\" {}\n}",
+			want:   []Range{{Position{1, 1}, Position{1, 5}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := newDocument(tt.source)
+			diags := diagnose("file:///test", doc, analyze(doc.text))
+			var got []Range
+			related := 0
+			for _, d := range diags {
+				got = append(got, d.Range)
+				related += len(d.RelatedInformation)
+			}
+			if !reflect.DeepEqual(got, tt.want) || related != tt.related {
+				t.Errorf("diagnose() = %v, want %v", diags, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package lsp
+
+import (
+	"strings"
+)
+
+// Format the document, indenting every line by the depth of blocks and lists it is in and removing
+// trailing whitespace. Contents of multiline strings are kept as is.
+func format(doc *document, a *analysis, indent string) (string, bool) {
+	if a.err != nil {
+		return "", false
+	}
+	var lines = len(doc.lines)
+	// depth of blocks at the start of each line
+	var depth = make([]int, lines)
+	// lines that start or end inside of a multiline token
+	var startsInToken = make([]bool, lines)
+	var endsInToken = make([]bool, lines)
+	// lines that start with a closing brace
+	var closing = make([]bool, lines)
+
+	var cur = 0
+	var next = 0
+	for _, t := range a.tokens {
+		for ; next < t.start.Line && next < lines; next++ {
+			depth[next] = cur
+		}
+		if (t.text == "}" || t.text == "]") && isFirstToken(doc.line(t.start.Line-1), t) {
+			closing[t.start.Line-1] = true
+		}
+		for l := t.start.Line; l < t.end.Line; l++ {
+			endsInToken[l-1] = true
+			startsInToken[l] = true
+		}
+		switch t.text {
+		case "{", "[":
+			cur += 1
+		case "}", "]":
+			cur = max(cur-1, 0)
+		}
+	}
+	for ; next < lines; next++ {
+		depth[next] = cur
+	}
+
+	var b strings.Builder
+	for i, text := range doc.lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		cr := strings.HasSuffix(text, "\r")
+		text = strings.TrimSuffix(text, "\r")
+		if !endsInToken[i] {
+			text = strings.TrimRight(text, " \t")
+		}
+		if !startsInToken[i] {
+			text = strings.TrimLeft(text, " \t")
+			if text != "" {
+				d := depth[i]
+				if closing[i] {
+					d = max(d-1, 0)
+				}
+				b.WriteString(strings.Repeat(indent, d))
+			}
+		}
+		b.WriteString(text)
+		if cr {
+			b.WriteByte('\r')
+		}
+	}
+	return b.String(), true
+}
+
+func isFirstToken(line string, t *token) bool {
+	prefix := []rune(line)[:min(t.start.Col-1, len([]rune(line)))]
+	return strings.TrimLeft(string(prefix), " \t") == ""
+}
@@ -0,0 +1,65 @@
+package lsp
+
+import (
+	"testing"
+)
+
+func Test_format(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		indent string
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "indent",
+			source: "server {\nendpoints {   \n      /a: files {\n  sources: /\n}\n  }\n   }\n",
+			indent: "\t",
+			want:   "server {\n\tendpoints {\n\t\t/a: files {\n\t\t\tsources: /\n\t\t}\n\t}\n}\n",
+			wantOk: true,
+		}, {
+			name:   "spaces",
+			source: "server {\n# comment\n\n\tendpoints {}\n}",
+			indent: "  ",
+			want:   "server {\n  # comment\n\n  endpoints {}\n}",
+			wantOk: true,
+		}, {
+			name:   "multiline_string",
+			source: "server {\nbody: \"\"\"  \n   keep  \n  \"\"\"   \n}",
+			indent: "\t",
+			want:   "server {\n\tbody: \"\"\"  \n   keep  \n  \"\"\"\n}",
+			wantOk: true,
+		}, {
+			name:   "crlf",
+			source: "server {\r\nendpoints {}\r\n}",
+			indent: "\t",
+			want:   "server {\r\n\tendpoints {}\r\n}",
+			wantOk: true,
+		}, {
+			name:   "list",
+			source: "server {\nindex: [\na,\n  b,\n  ]\n}",
+			indent: "\t",
+			want:   "server {\n\tindex: [\n\t\ta,\n\t\tb,\n\t]\n}",
+			wantOk: true,
+		}, {
+			name:   "invalid",
+			source: "server {\nendpoints \\ {}\n}",
+			indent: "\t",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := newDocument(tt.source)
+			got, ok := format(doc, analyze(doc.text), tt.indent)
+			if ok != tt.wantOk {
+				t.Errorf("format() ok = %v, want %v", ok, tt.wantOk)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("format() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Package lsp implements a Language Server Protocol server for switchman config files.
+//
+// The server communicates over a pair of streams, usually stdin and stdout of the process, and
+// provides diagnostics from the config parser, completion and hover documentation from the
+// config schema, definitions of snippets and formatting.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+
+	"github.com/arrowinaknee/switchman/pkg/config"
+)
+
+type server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*document
+
+	shutdown bool
+}
+
+// Serve the protocol on the streams until the client sends 'exit' or in is closed
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.respondError(nil, codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) handle(req *request) error {
+	if s.shutdown && req.ID != nil {
+		return s.respondError(req.ID, codeInvalidRequest, "server is shut down")
+	}
+	switch req.Method {
+	case "initialize":
+		return s.respond(req.ID, map[string]any{
+			"capabilities": map[string]any{
+				// full document is sent on every change
+				"textDocumentSync":           1,
+				"completionProvider":         map[string]any{},
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]any{"name": "switchman"},
+		})
+	case "initialized":
+		return nil
+	case "shutdown":
+		s.shutdown = true
+		return s.respond(req.ID, nil)
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.respondError(req.ID, codeInvalidParams, err.Error())
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.respondError(req.ID, codeInvalidParams, err.Error())
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.respondError(req.ID, codeInvalidParams, err.Error())
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+
+	case "textDocument/completion":
+		return s.handlePosition(req, func(uri string, doc *document, a *analysis, p config.TokenPosition) any {
+			return complete(a, p)
+		})
+	case "textDocument/hover":
+		return s.handlePosition(req, func(uri string, doc *document, a *analysis, p config.TokenPosition) any {
+			if h := hover(doc, a, p); h != nil {
+				return h
+			}
+			return nil
+		})
+	case "textDocument/definition":
+		return s.handlePosition(req, func(uri string, doc *document, a *analysis, p config.TokenPosition) any {
+			if l := definition(uri, doc, a, p); l != nil {
+				return l
+			}
+			return nil
+		})
+	case "textDocument/formatting":
+		var params DocumentFormattingParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.respondError(req.ID, codeInvalidParams, err.Error())
+		}
+		doc := s.docs[params.TextDocument.URI]
+		if doc == nil {
+			return s.respond(req.ID, nil)
+		}
+		text, ok := format(doc, analyze(doc.text), params.Options.indent())
+		if !ok || text == doc.text {
+			return s.respond(req.ID, []TextEdit{})
+		}
+		return s.respond(req.ID, []TextEdit{{Range: doc.fullRange(), NewText: text}})
+
+	default:
+		// notifications that are not supported are ignored
+		if req.ID == nil {
+			return nil
+		}
+		return s.respondError(req.ID, codeMethodNotFound, "method not supported: "+req.Method)
+	}
+}
+
+// Handle a request with text document position params
+func (s *server) handlePosition(req *request, f func(uri string, doc *document, a *analysis, p config.TokenPosition) any) error {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return s.respondError(req.ID, codeInvalidParams, err.Error())
+	}
+	uri := params.TextDocument.URI
+	doc := s.docs[uri]
+	if doc == nil {
+		return s.respond(req.ID, nil)
+	}
+	return s.respond(req.ID, f(uri, doc, analyze(doc.text), doc.fromLSP(params.Position)))
+}
+
+// Store the new text of a document and publish its diagnostics
+func (s *server) update(uri string, text string) error {
+	doc := newDocument(text)
+	s.docs[uri] = doc
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diagnose(uri, doc, analyze(text)),
+	})
+}
+
+func (s *server) respond(id *json.RawMessage, result any) error {
+	if id == nil {
+		// notifications are never responded to
+		return nil
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *server) respondError(id *json.RawMessage, code int, message string) error {
+	log.Printf("lsp: %s", message)
+	// errors in notifications can not be responded to, unless the id could not be read at all
+	if id == nil && code != codeParseError {
+		return nil
+	}
+	return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: responseError{Code: code, Message: message}})
+}
+
+func (s *server) notify(method string, params any) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
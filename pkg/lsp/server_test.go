@@ -0,0 +1,64 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	var in bytes.Buffer
+	send := func(msg string) {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	send(`{"jsonrpc":"2.0","method":"initialized","params":{}}`)
+	send(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.conf","version":1,"text":"server {\n\tendpoints {\n\t\t/: files {}\n\t}\n"}}}`)
+	send(`{"jsonrpc":"2.0","id":2,"method":"textDocument/completion","params":{"textDocument":{"uri":"file:///a.conf"},"position":{"line":2,"character":12}}}`)
+	send(`{"jsonrpc":"2.0","id":3,"method":"workspace/symbol","params":{}}`)
+	send(`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`)
+	send(`{"jsonrpc":"2.0","method":"exit"}`)
+
+	var out bytes.Buffer
+	if err := Serve(&in, &out); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+
+	var got []map[string]any
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("invalid message %s: %v", body, err)
+		}
+		got = append(got, msg)
+	}
+	if len(got) != 5 {
+		t.Fatalf("Serve() sent %d messages, want 5", len(got))
+	}
+
+	if _, ok := got[0]["result"].(map[string]any)["capabilities"]; !ok {
+		t.Errorf("initialize result = %v, want capabilities", got[0]["result"])
+	}
+	diags := got[1]["params"].(map[string]any)["diagnostics"].([]any)
+	if got[1]["method"] != "textDocument/publishDiagnostics" || len(diags) != 1 {
+		t.Errorf("didOpen published %v, want one diagnostic", got[1])
+	}
+	if items := got[2]["result"].([]any); len(items) < 2 || !strings.Contains(fmt.Sprint(items[0]), "sources") ||
+		!strings.Contains(fmt.Sprint(items[len(items)-1]), "use") {
+		t.Errorf("completion result = %v, want sources and use", items)
+	}
+	if code := got[3]["error"].(map[string]any)["code"]; code != float64(codeMethodNotFound) {
+		t.Errorf("unsupported method error code = %v, want %v", code, codeMethodNotFound)
+	}
+	if result, ok := got[4]["result"]; !ok || result != nil {
+		t.Errorf("shutdown result = %v, want null", got[4])
+	}
+}
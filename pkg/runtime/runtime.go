@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 
@@ -21,7 +22,8 @@ func New() *Runtime {
 	return &Runtime{}
 }
 
-// load server configuration at specified path and track the locaion
+// load server configuration at specified path and track the locaion. JSON and YAML files are
+// recognized by their extension
 func (r *Runtime) LoadServer(path string) error {
 	config_file, err := os.Open(path)
 	if err != nil {
@@ -29,19 +31,41 @@ func (r *Runtime) LoadServer(path string) error {
 	}
 	defer config_file.Close()
 
-	srv, err := appconfig.ParseServer(config_file)
+	srv, err := appconfig.ParseServerFormat(config_file, appconfig.FormatFromPath(path))
 	if err != nil {
 		return err
 	}
 
-	r.server = srv
+	r.setServer(srv)
 	r.configPath = path
 	return nil
 }
 
 // Update app state to use new server. Does not change the source file
 func (r *Runtime) UpdateServer(s Server) {
+	r.setServer(s)
+}
+
+// Replace the server. Background work of the new server, like health checks, is started.
+// Connections kept open by the previous one, like the pools of proxies, are closed once the
+// requests it is serving finish
+func (r *Runtime) setServer(s Server) {
+	old := r.server
+	if old == s {
+		return
+	}
+	if starter, ok := s.(interface{ Start() }); ok {
+		starter.Start()
+	}
 	r.server = s
+	if c, ok := old.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// Server that currently handles requests, nil if none was loaded
+func (r *Runtime) GetServer() Server {
+	return r.server
 }
 
 func (r *Runtime) GetConfigPath() string {
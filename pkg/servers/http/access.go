@@ -0,0 +1,186 @@
+package http
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Policies for symbolic links inside of the source of a files endpoint
+const (
+	SymlinksNever      = "never"       // links are not followed, requests through them get 404
+	SymlinksWithinRoot = "within-root" // links are followed if the target is inside of the source
+	SymlinksAlways     = "always"      // links are followed anywhere, the default
+)
+
+var SymlinkPolicies = []string{SymlinksNever, SymlinksWithinRoot, SymlinksAlways}
+
+// Policies for hidden files and directories whose names start with a dot
+const (
+	HiddenDeny     = "deny"     // not served and not listed
+	HiddenUnlisted = "unlisted" // served but not listed, the default
+	HiddenAllow    = "allow"    // served and listed like other files
+)
+
+var HiddenPolicies = []string{HiddenDeny, HiddenUnlisted, HiddenAllow}
+
+// Open a file of the source that may be served, the name is a slash separated path relative
+// to the source. Directories are reported as not existing
+func (f *EndpointFiles) open(name string) (*os.File, fs.FileInfo, error) {
+	file, err := f.openPath(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, fs.ErrNotExist
+	}
+	return file, info, nil
+}
+
+// Stat a file or directory of the source that may be served, links are resolved
+func (f *EndpointFiles) stat(name string) (fs.FileInfo, error) {
+	file, err := f.openPath(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+// Open a file or directory of the source that may be served. Paths resolved by the policies
+// are opened through a root of the source, so that a link changed after the path was checked
+// can't lead out of it
+func (f *EndpointFiles) openPath(name string) (*os.File, error) {
+	rel, err := f.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if f.FollowSymlinks == "" || f.FollowSymlinks == SymlinksAlways {
+		return os.Open(filepath.Join(f.Source, rel))
+	}
+	root, err := os.OpenRoot(f.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.Open(rel)
+}
+
+// Path of a file relative to the source, with links resolved if they are only followed within
+// the source. Files that are denied by the policies are reported as not existing, so that
+// clients can't tell them from missing ones
+func (f *EndpointFiles) resolve(name string) (string, error) {
+	name = strings.Trim(name, "/")
+	if name != "" && !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fs.ErrNotExist
+	}
+	if !f.allowed(name) {
+		return "", fs.ErrNotExist
+	}
+	rel := filepath.FromSlash(name)
+	if rel == "" {
+		rel = "."
+	}
+
+	switch f.FollowSymlinks {
+	case "", SymlinksAlways:
+		return rel, nil
+	case SymlinksNever:
+		current := f.Source
+		for _, part := range splitPath(name) {
+			current = filepath.Join(current, part)
+			info, err := os.Lstat(current)
+			if err != nil {
+				return "", err
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				return "", fs.ErrNotExist
+			}
+		}
+		return rel, nil
+	default:
+		// the source itself may be a link, only links inside of it are checked
+		root, err := filepath.EvalSymlinks(f.Source)
+		if err != nil {
+			return "", err
+		}
+		target, err := filepath.EvalSymlinks(filepath.Join(f.Source, rel))
+		if err != nil {
+			return "", err
+		}
+		rel, err = filepath.Rel(root, target)
+		if err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+			return "", fs.ErrNotExist
+		}
+		// a link must not make a hidden or denied file available under another name
+		if rel != "." && !f.allowed(filepath.ToSlash(rel)) {
+			return "", fs.ErrNotExist
+		}
+		return rel, nil
+	}
+}
+
+// Path is not hidden or matched by a deny pattern
+func (f *EndpointFiles) allowed(name string) bool {
+	parts := splitPath(name)
+	if f.Hidden == HiddenDeny {
+		for _, part := range parts {
+			if isHidden(part) {
+				return false
+			}
+		}
+	}
+	// patterns with a slash match the path from the source, others match any single name.
+	// Denying a directory denies everything inside of it
+	for _, pattern := range f.Deny {
+		pattern = strings.Trim(pattern, "/")
+		if strings.Contains(pattern, "/") {
+			for i := range parts {
+				if ok, _ := path.Match(pattern, strings.Join(parts[:i+1], "/")); ok {
+					return false
+				}
+			}
+			continue
+		}
+		for _, part := range parts {
+			if ok, _ := path.Match(pattern, part); ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Entry of a directory is shown in listings
+func (f *EndpointFiles) listed(name string) bool {
+	if f.Hidden != HiddenAllow {
+		for _, part := range splitPath(name) {
+			if isHidden(part) {
+				return false
+			}
+		}
+	}
+	return f.allowed(name)
+}
+
+// Names starting with a dot are hidden, except for '.well-known' that is public by definition
+// (RFC 8615) and is used for things like ACME challenges
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != ".well-known"
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(filepath.ToSlash(name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
@@ -0,0 +1,321 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Source with hidden files and links that point inside and outside of it
+func accessTestSource(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	for path, content := range map[string]string{
+		"root/public.txt":              "public",
+		"root/docs/page.txt":           "page",
+		"root/.git/config":             "git",
+		"root/.env":                    "env",
+		"root/.well-known/acme":        "acme",
+		"root/backup.bak":              "backup",
+		"root/private/key.txt":         "key",
+		"outside/secret.txt":           "secret",
+		"outside/nested/secret.txt":    "nested",
+		"root-sibling/secret.txt":      "sibling",
+		"root/docs/.hidden/secret.txt": "hidden",
+	} {
+		path = filepath.Join(base, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"link_dir":     "docs",
+		"link_file":    "public.txt",
+		"link_out":     "../outside",
+		"link_abs":     filepath.Join(base, "outside", "secret.txt"),
+		"link_sibling": "../root-sibling",
+		"link_git":     ".git/config",
+		"docs/up":      "..",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Skipf("symbolic links are not supported: %v", err)
+		}
+	}
+	return root
+}
+
+func TestEndpointFiles_Access(t *testing.T) {
+	root := accessTestSource(t)
+
+	tests := []struct {
+		name       string
+		ep         *EndpointFiles
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "public",
+			ep:         &EndpointFiles{},
+			url:        "/static/public.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "public",
+		}, {
+			name:       "dotdot",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksAlways},
+			url:        "/static/../outside/secret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "encoded_dotdot",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksAlways},
+			url:        "/static/..%2f..%2foutside%2fsecret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "encoded_dots",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksAlways},
+			url:        "/static/%2e%2e/%2e%2e/outside/secret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "encoded_backslash",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksAlways},
+			url:        "/static/..%5c..%5coutside%5csecret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			// links and hidden files are served unless a policy is set
+			name:       "default_link_out",
+			ep:         &EndpointFiles{},
+			url:        "/static/link_out/secret.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "secret",
+		}, {
+			name:       "default_hidden",
+			ep:         &EndpointFiles{},
+			url:        "/static/.env",
+			wantStatus: http.StatusOK,
+			wantBody:   "env",
+		}, {
+			name:       "within_root_dir",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot},
+			url:        "/static/link_dir/page.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "page",
+		}, {
+			name:       "within_root_file",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot},
+			url:        "/static/link_file",
+			wantStatus: http.StatusOK,
+			wantBody:   "public",
+		}, {
+			name:       "within_root_loop",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot},
+			url:        "/static/docs/up/docs/up/public.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "public",
+		}, {
+			name:       "within_root_out",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot},
+			url:        "/static/link_out/secret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "within_root_encoded_out",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot},
+			url:        "/static/link_out%2fnested%2fsecret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "within_root_absolute",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot},
+			url:        "/static/link_abs",
+			wantStatus: http.StatusNotFound,
+		}, {
+			// a prefix check of the path would accept '/base/root-sibling' for '/base/root'
+			name:       "within_root_sibling",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot},
+			url:        "/static/link_sibling/secret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "within_root_up_and_out",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot},
+			url:        "/static/docs/up/link_out/secret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "within_root_hidden_target",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot, Hidden: HiddenDeny},
+			url:        "/static/link_git",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "never_dir",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksNever},
+			url:        "/static/link_dir/page.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "never_file",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksNever},
+			url:        "/static/link_file",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "never_regular",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksNever},
+			url:        "/static/docs/page.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "page",
+		}, {
+			name:       "always_out",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksAlways},
+			url:        "/static/link_out/secret.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "secret",
+		}, {
+			name:       "hidden_dir",
+			ep:         &EndpointFiles{Hidden: HiddenDeny},
+			url:        "/static/.git/config",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "hidden_encoded",
+			ep:         &EndpointFiles{Hidden: HiddenDeny},
+			url:        "/static/%2egit%2fconfig",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "hidden_file",
+			ep:         &EndpointFiles{Hidden: HiddenDeny},
+			url:        "/static/.env",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "hidden_nested",
+			ep:         &EndpointFiles{Hidden: HiddenDeny},
+			url:        "/static/docs/.hidden/secret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "hidden_through_link",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot, Hidden: HiddenDeny},
+			url:        "/static/link_dir/.hidden/secret.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "well_known",
+			ep:         &EndpointFiles{Hidden: HiddenDeny},
+			url:        "/static/.well-known/acme",
+			wantStatus: http.StatusOK,
+			wantBody:   "acme",
+		}, {
+			name:       "hidden_unlisted",
+			ep:         &EndpointFiles{Hidden: HiddenUnlisted},
+			url:        "/static/.env",
+			wantStatus: http.StatusOK,
+			wantBody:   "env",
+		}, {
+			name:       "hidden_allow",
+			ep:         &EndpointFiles{Hidden: HiddenAllow},
+			url:        "/static/.git/config",
+			wantStatus: http.StatusOK,
+			wantBody:   "git",
+		}, {
+			name:       "deny_name",
+			ep:         &EndpointFiles{Deny: []string{"*.bak"}},
+			url:        "/static/backup.bak",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "deny_dir",
+			ep:         &EndpointFiles{Deny: []string{"private"}},
+			url:        "/static/private/key.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "deny_path",
+			ep:         &EndpointFiles{Deny: []string{"/private/*"}},
+			url:        "/static/private/key.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "deny_path_other",
+			ep:         &EndpointFiles{Deny: []string{"private/*"}},
+			url:        "/static/docs/page.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "page",
+		}, {
+			name:       "deny_through_link",
+			ep:         &EndpointFiles{FollowSymlinks: SymlinksWithinRoot, Deny: []string{"public.txt"}},
+			url:        "/static/link_file",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "deny_fallback",
+			ep:         &EndpointFiles{Deny: []string{"private"}, Fallback: "private/key.txt"},
+			url:        "/static/missing",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.ep.Source = root
+			server := &Server{Endpoints: []Endpoint{{Location: "/static/", Function: tt.ep}}}
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestEndpointFiles_AccessListing(t *testing.T) {
+	root := accessTestSource(t)
+
+	tests := []struct {
+		name string
+		ep   *EndpointFiles
+		want []string
+	}{
+		{
+			name: "default",
+			ep:   &EndpointFiles{},
+			want: []string{".well-known", "docs", "link_dir", "link_out", "link_sibling", "private", "backup.bak", "link_abs", "link_file", "link_git", "public.txt"},
+		}, {
+			name: "within_root",
+			ep:   &EndpointFiles{FollowSymlinks: SymlinksWithinRoot, Hidden: HiddenDeny},
+			want: []string{".well-known", "docs", "link_dir", "private", "backup.bak", "link_file", "public.txt"},
+		}, {
+			name: "never",
+			ep:   &EndpointFiles{FollowSymlinks: SymlinksNever},
+			want: []string{".well-known", "docs", "private", "backup.bak", "public.txt"},
+		}, {
+			name: "hidden_unlisted",
+			ep:   &EndpointFiles{FollowSymlinks: SymlinksWithinRoot, Hidden: HiddenUnlisted, Deny: []string{"*.bak", "private"}},
+			want: []string{".well-known", "docs", "link_dir", "link_file", "link_git", "public.txt"},
+		}, {
+			name: "hidden_allow",
+			ep:   &EndpointFiles{Hidden: HiddenAllow, FollowSymlinks: SymlinksNever},
+			want: []string{".git", ".well-known", "docs", "private", ".env", "backup.bak", "public.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.ep.Source = root
+			tt.ep.Browse = true
+			tt.ep.Index = []string{}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			tt.ep.Serve(w, r, "")
+
+			var listing dirListing
+			if err := json.NewDecoder(w.Body).Decode(&listing); err != nil {
+				t.Fatalf("Serve() listing error = %v", err)
+			}
+			got := []string{}
+			for _, e := range listing.Entries {
+				got = append(got, e.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Serve() listing = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package http
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policies that choose the server of a pool for a request
+const (
+	BalanceRoundRobin = "round_robin"
+	// Round robin where servers get requests in proportion to their weights
+	BalanceWeighted = "weighted"
+	// Server with the fewest requests in progress relative to its weight
+	BalanceLeastConn = "least_conn"
+	// Less loaded of two random servers
+	BalanceRandomTwo = "random_two"
+	// Consistent hash of the client address, a header or a cookie, so that a client keeps
+	// getting the same server while the pool doesn't change
+	BalanceHash = "hash"
+)
+
+var BalancePolicies = []string{BalanceRoundRobin, BalanceWeighted, BalanceLeastConn, BalanceRandomTwo, BalanceHash}
+
+// Upstream is a server of a pool
+type Upstream struct {
+	Proto  string `json:"proto"`
+	Host   string `json:"host"`
+	Weight int    `json:"weight"` // 1 if zero
+
+	// requests in progress
+	active atomic.Int64
+	// weight accumulated by weighted round robin, guarded by the mutex of the pool
+	current int
+	health  upstreamHealth
+}
+
+// UpstreamPool is a group of servers that requests of proxies are balanced between. Pools are
+// defined once for the server and can be shared by several proxies
+type UpstreamPool struct {
+	Name    string      `json:"name"`
+	Servers []*Upstream `json:"servers"`
+	Balance string      `json:"balance"` // BalanceRoundRobin if empty
+	// Header or cookie whose value is hashed with BalanceHash instead of the client address.
+	// Requests without it are hashed by the address
+	HashHeader string `json:"hash_header,omitempty"`
+	HashCookie string `json:"hash_cookie,omitempty"`
+	// Active check of the servers, nil if there is none
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+	// Failed requests in a row that eject a server from the pool, DefaultMaxFails if zero.
+	// Negative values disable ejection
+	MaxFails int `json:"max_fails,omitempty"`
+	// Time an ejected server gets no requests, DefaultFailTimeout if zero
+	FailTimeout time.Duration `json:"fail_timeout,omitempty"`
+	// Settings of https connections to the servers, they replace the ones of proxies
+	TLS *ProxyTLS `json:"tls,omitempty"`
+
+	next atomic.Uint64
+	mu   sync.Mutex
+	once sync.Once
+	ring []ringPoint
+
+	checkMu    sync.Mutex
+	stopChecks context.CancelFunc
+}
+
+func (u *Upstream) weight() int {
+	if u.Weight <= 0 {
+		return 1
+	}
+	return u.Weight
+}
+
+// Mark the request to the server as finished
+func (u *Upstream) done() {
+	u.active.Add(-1)
+}
+
+// Choose an available server for the request, done must be called on it when the request is
+// finished. Servers that were already tried for the request are avoided while there are others.
+// Nil if all servers are down or ejected
+func (p *UpstreamPool) pick(r *http.Request, tried ...*Upstream) *Upstream {
+	servers := p.available()
+	if len(servers) == 0 {
+		return nil
+	}
+	if len(tried) > 0 {
+		untried := slices.DeleteFunc(slices.Clone(servers), func(u *Upstream) bool {
+			return slices.Contains(tried, u)
+		})
+		if len(untried) > 0 {
+			servers = untried
+		}
+	}
+	var u *Upstream
+	switch {
+	case len(servers) == 1:
+		u = servers[0]
+	case p.Balance == BalanceWeighted:
+		u = p.pickWeighted(servers)
+	case p.Balance == BalanceLeastConn:
+		u = p.pickLeastConn(servers)
+	case p.Balance == BalanceRandomTwo:
+		u = pickRandomTwo(servers)
+	case p.Balance == BalanceHash:
+		u = p.pickHash(r, servers)
+	default:
+		u = servers[(p.next.Add(1)-1)%uint64(len(servers))]
+	}
+	u.active.Add(1)
+	return u
+}
+
+// Servers that can be sent requests
+func (p *UpstreamPool) available() []*Upstream {
+	now := time.Now()
+	servers := make([]*Upstream, 0, len(p.Servers))
+	for _, u := range p.Servers {
+		if u.available(now) {
+			servers = append(servers, u)
+		}
+	}
+	return servers
+}
+
+// Smooth weighted round robin: every server gains its weight on each pick and the one with the
+// most accumulated weight is chosen and loses the total, which spreads the picks evenly
+func (p *UpstreamPool) pickWeighted(servers []*Upstream) *Upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *Upstream
+	total := 0
+	for _, u := range servers {
+		u.current += u.weight()
+		total += u.weight()
+		if best == nil || u.current > best.current {
+			best = u
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func (p *UpstreamPool) pickLeastConn(servers []*Upstream) *Upstream {
+	// the search starts at the next server in turn so that ties are spread
+	start := int((p.next.Add(1) - 1) % uint64(len(servers)))
+	best := servers[start]
+	for i := 1; i < len(servers); i++ {
+		u := servers[(start+i)%len(servers)]
+		if lessLoaded(u, best) {
+			best = u
+		}
+	}
+	return best
+}
+
+func pickRandomTwo(servers []*Upstream) *Upstream {
+	i := rand.Intn(len(servers))
+	j := rand.Intn(len(servers) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := servers[i], servers[j]
+	if lessLoaded(b, a) {
+		return b
+	}
+	return a
+}
+
+// Whether a has fewer requests in progress than b relative to their weights
+func lessLoaded(a, b *Upstream) bool {
+	return a.active.Load()*int64(b.weight()) < b.active.Load()*int64(a.weight())
+}
+
+// Points of a server on the hash ring
+const ringPointsPerWeight = 160
+
+type ringPoint struct {
+	hash   uint64
+	server *Upstream
+}
+
+// Consistent hashing: servers get points on a ring in proportion to their weights and a key
+// goes to the first point after its hash. Adding or removing a server only moves the keys of
+// its points, keys of servers that are not available go to the next available point
+func (p *UpstreamPool) pickHash(r *http.Request, servers []*Upstream) *Upstream {
+	p.once.Do(func() {
+		for _, u := range p.Servers {
+			for i := 0; i < u.weight()*ringPointsPerWeight; i++ {
+				p.ring = append(p.ring, ringPoint{hash: hashKey(u.Host + "#" + strconv.Itoa(i)), server: u})
+			}
+		}
+		sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	})
+	h := hashKey(p.requestKey(r))
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	for n := 0; n < len(p.ring); n++ {
+		point := p.ring[(i+n)%len(p.ring)]
+		if slices.Contains(servers, point.server) {
+			return point.server
+		}
+	}
+	return servers[0]
+}
+
+// Value of the request that is hashed
+func (p *UpstreamPool) requestKey(r *http.Request) string {
+	if p.HashHeader != "" {
+		if v := r.Header.Get(p.HashHeader); v != "" {
+			return v
+		}
+	}
+	if p.HashCookie != "" {
+		if c, err := r.Cookie(p.HashCookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// FNV of similar keys is close, mixing spreads them over the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func newTestPool(balance string, weights ...int) *UpstreamPool {
+	pool := &UpstreamPool{Name: "test", Balance: balance}
+	for i, w := range weights {
+		pool.Servers = append(pool.Servers, &Upstream{Proto: "http", Host: fmt.Sprintf("10.0.0.%d:80", i+1), Weight: w})
+	}
+	return pool
+}
+
+// Hosts picked for n requests, the requests are finished right away
+func pickHosts(pool *UpstreamPool, r *http.Request, n int) []string {
+	var hosts []string
+	for i := 0; i < n; i++ {
+		u := pool.pick(r)
+		u.done()
+		hosts = append(hosts, u.Host)
+	}
+	return hosts
+}
+
+func TestUpstreamPool_pick(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	tests := []struct {
+		name string
+		pool *UpstreamPool
+		want []string
+	}{
+		{
+			name: "round_robin",
+			pool: newTestPool(BalanceRoundRobin, 1, 1, 1),
+			want: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.1:80"},
+		}, {
+			name: "default",
+			pool: newTestPool("", 5, 1),
+			want: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.1:80"},
+		}, {
+			// picks of the heavier server are spread instead of coming in a row
+			name: "weighted",
+			pool: newTestPool(BalanceWeighted, 5, 1, 1),
+			want: []string{"10.0.0.1:80", "10.0.0.1:80", "10.0.0.2:80", "10.0.0.1:80", "10.0.0.3:80", "10.0.0.1:80", "10.0.0.1:80"},
+		}, {
+			name: "single",
+			pool: newTestPool(BalanceHash, 1),
+			want: []string{"10.0.0.1:80", "10.0.0.1:80"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickHosts(tt.pool, r, len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pick() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamPool_pickLoaded(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, balance := range []string{BalanceLeastConn, BalanceRandomTwo} {
+		t.Run(balance, func(t *testing.T) {
+			pool := newTestPool(balance, 1, 2)
+			// the second server has twice the weight, so it is less loaded with 3 requests
+			// than the first one with 2
+			pool.Servers[0].active.Store(2)
+			pool.Servers[1].active.Store(3)
+			for _, host := range pickHosts(pool, r, 10) {
+				if host != "10.0.0.2:80" {
+					t.Fatalf("pick() = %v, want the least loaded server", host)
+				}
+			}
+			u := pool.pick(r)
+			if u.active.Load() != 4 {
+				t.Errorf("active = %v after pick, want 4", u.active.Load())
+			}
+			u.done()
+		})
+	}
+}
+
+func TestUpstreamPool_pickHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		pool   *UpstreamPool
+		setup  func(r *http.Request, i int)
+		sticky func(r *http.Request)
+	}{
+		{
+			name:   "ip",
+			pool:   newTestPool(BalanceHash, 1, 1, 1, 1),
+			setup:  func(r *http.Request, i int) { r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i) },
+			sticky: func(r *http.Request) { r.RemoteAddr = r.RemoteAddr[:len(r.RemoteAddr)-4] + "5678" },
+		}, {
+			name:   "header",
+			pool:   &UpstreamPool{Balance: BalanceHash, HashHeader: "X-User", Servers: newTestPool("", 1, 1, 1, 1).Servers},
+			setup:  func(r *http.Request, i int) { r.Header.Set("X-User", fmt.Sprint("user", i)) },
+			sticky: func(r *http.Request) { r.RemoteAddr = "198.51.100.1:1234" },
+		}, {
+			name:   "cookie",
+			pool:   &UpstreamPool{Balance: BalanceHash, HashCookie: "session", Servers: newTestPool("", 1, 1, 1, 1).Servers},
+			setup:  func(r *http.Request, i int) { r.AddCookie(&http.Cookie{Name: "session", Value: fmt.Sprint("s", i)}) },
+			sticky: func(r *http.Request) { r.RemoteAddr = "198.51.100.1:1234" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a smaller pool with the last server removed
+			smaller := &UpstreamPool{
+				Balance:    BalanceHash,
+				HashHeader: tt.pool.HashHeader,
+				HashCookie: tt.pool.HashCookie,
+				Servers:    tt.pool.Servers[:3],
+			}
+			counts := map[string]int{}
+			moved := 0
+			const clients = 200
+			for i := 0; i < clients; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				tt.setup(r, i)
+				host := pickHosts(tt.pool, r, 1)[0]
+				counts[host]++
+
+				tt.sticky(r)
+				if again := pickHosts(tt.pool, r, 1)[0]; again != host {
+					t.Fatalf("pick() = %v for the same key, was %v", again, host)
+				}
+				if other := pickHosts(smaller, r, 1)[0]; other != host {
+					moved++
+					if host != "10.0.0.4:80" {
+						t.Fatalf("pick() moved a key from %v that is still in the pool", host)
+					}
+				}
+			}
+			for _, u := range tt.pool.Servers {
+				if counts[u.Host] < clients/8 {
+					t.Errorf("pick() counts = %v, keys are not spread", counts)
+				}
+			}
+			if moved != counts["10.0.0.4:80"] {
+				t.Errorf("moved %v keys, want %v", moved, counts["10.0.0.4:80"])
+			}
+		})
+	}
+}
+
+func TestEndpointProxy_Upstream(t *testing.T) {
+	pool := &UpstreamPool{Name: "test"}
+	for _, name := range []string{"a", "b"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.URL.Path))
+		}))
+		defer server.Close()
+		u, _ := url.Parse(server.URL)
+		pool.Servers = append(pool.Servers, &Upstream{Proto: "http", Host: u.Host})
+	}
+	proxy := &EndpointProxy{Path: "/api/", Upstream: pool}
+	defer proxy.Close()
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/v1/items", nil), "items")
+		got = append(got, w.Body.String())
+	}
+	want := []string{"a /api/items", "b /api/items", "a /api/items"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Serve() = %v, want %v", got, want)
+	}
+	for _, u := range pool.Servers {
+		if u.active.Load() != 0 {
+			t.Errorf("active = %v for %v after the requests finished", u.active.Load(), u.Host)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry of a directory listing, also passed to listing templates
+type dirEntry struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"` // Relative url of the entry, directories end with a slash
+	Dir      bool      `json:"dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Data of listing templates
+type dirListing struct {
+	Path    string     `json:"path"` // Request path of the directory
+	Entries []dirEntry `json:"entries"`
+}
+
+var defaultListingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1.5em 0.2em 0; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.URL}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td class="size">{{if not .Dir}}{{.Size}}{{end}}</td><td>{{.Modified.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// List the directory as a html page or as JSON if the client asks for it. Hidden and denied
+// files are not listed, neither are links that can't be followed
+func (f *EndpointFiles) serveListing(w http.ResponseWriter, r *http.Request, name string) {
+	dir, err := f.openPath(name)
+	if err != nil {
+		respondWith404(w, r)
+		return
+	}
+	defer dir.Close()
+	files, err := dir.ReadDir(-1)
+	if err != nil {
+		log.Printf("EndpointFiles.Serve: error reading directory '%s': %v", dir.Name(), err)
+		respondWithError(w, r)
+		return
+	}
+	listing := dirListing{Path: r.URL.Path, Entries: []dirEntry{}}
+	for _, file := range files {
+		fileName := path.Join(name, file.Name())
+		if !f.listed(fileName) {
+			continue
+		}
+		// info of the link target, links that are not followed or are broken are skipped
+		info, err := f.stat(fileName)
+		if err != nil {
+			continue
+		}
+		entry := dirEntry{
+			Name:     file.Name(),
+			URL:      (&url.URL{Path: file.Name()}).String(),
+			Dir:      info.IsDir(),
+			Modified: info.ModTime(),
+		}
+		// names like 'a:b' would be read as a scheme
+		if strings.Contains(entry.URL, ":") {
+			entry.URL = "./" + entry.URL
+		}
+		if entry.Dir {
+			entry.URL += "/"
+		} else {
+			entry.Size = info.Size()
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+	// directories first, then by name
+	sort.Slice(listing.Entries, func(i, j int) bool {
+		a, b := listing.Entries[i], listing.Entries[j]
+		if a.Dir != b.Dir {
+			return a.Dir
+		}
+		return a.Name < b.Name
+	})
+
+	if prefersJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(listing); err != nil {
+			log.Printf("EndpointFiles.Serve: error writing listing: %v", err)
+		}
+		return
+	}
+
+	tmpl := defaultListingTemplate
+	if f.BrowseTemplate != "" {
+		// parsed on each request so that changes to the template are shown without a reload
+		tmpl, err = template.ParseFiles(f.BrowseTemplate)
+		if err != nil {
+			log.Printf("EndpointFiles.Serve: error reading listing template: %v", err)
+			respondWithError(w, r)
+			return
+		}
+	}
+	var page strings.Builder
+	if err := tmpl.Execute(&page, listing); err != nil {
+		log.Printf("EndpointFiles.Serve: error rendering listing template: %v", err)
+		respondWithError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page.String()))
+}
+
+// Client asks for JSON with the 'Accept' header and doesn't accept html
+func prefersJSON(r *http.Request) bool {
+	html, json := false, false
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			json = true
+		case "text/html":
+			html = true
+		}
+	}
+	return json && !html
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEndpointFiles_Browse(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"b.txt":           "bb",
+		"a.txt":           "a",
+		".hidden":         "secret",
+		"z/file":          "",
+		"a?b/file":        "",
+		"100%.txt":        "",
+		"site/index.html": "<p>index</p>",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	template := filepath.Join(t.TempDir(), "listing.html")
+	if err := os.WriteFile(template, []byte(`{{.Path}}:{{range .Entries}} {{.Name}}{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		ep           *EndpointFiles
+		url          string
+		localPath    string
+		accept       string
+		wantStatus   int
+		wantLocation string
+		wantBody     []string
+	}{
+		{
+			name:       "html",
+			ep:         &EndpointFiles{Source: dir, Browse: true},
+			url:        "/files/",
+			localPath:  "/",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"Index of /files/", `<a href="z/">z/</a>`, `<a href="a.txt">a.txt</a>`, `<a href="../">`},
+		}, {
+			name:         "redirect_dir",
+			ep:           &EndpointFiles{Source: dir, Browse: true},
+			url:          "/files/z?sort=name",
+			localPath:    "/z",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/files/z/?sort=name",
+		}, {
+			name:         "redirect_root",
+			ep:           &EndpointFiles{Source: dir},
+			url:          "/files",
+			localPath:    "",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/files/",
+		}, {
+			name:         "redirect_file",
+			ep:           &EndpointFiles{Source: dir, Browse: true},
+			url:          "/files/a.txt/",
+			localPath:    "/a.txt/",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/files/a.txt",
+		}, {
+			name:         "redirect_dir_escaped",
+			ep:           &EndpointFiles{Source: dir},
+			url:          "/files/a%3Fb?sort=name",
+			localPath:    "/a?b",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/files/a%3Fb/?sort=name",
+		}, {
+			name:         "redirect_file_escaped",
+			ep:           &EndpointFiles{Source: dir},
+			url:          "/files/100%25.txt/",
+			localPath:    "/100%.txt/",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/files/100%25.txt",
+		}, {
+			name:       "index",
+			ep:         &EndpointFiles{Source: dir, Browse: true},
+			url:        "/files/site/",
+			localPath:  "/site/",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"<p>index</p>"},
+		}, {
+			name:       "disabled",
+			ep:         &EndpointFiles{Source: dir},
+			url:        "/files/",
+			localPath:  "/",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "template",
+			ep:         &EndpointFiles{Source: dir, Browse: true, BrowseTemplate: template},
+			url:        "/files/",
+			localPath:  "/",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"/files/: a?b site z 100%.txt a.txt b.txt"},
+		}, {
+			name:       "template_missing",
+			ep:         &EndpointFiles{Source: dir, Browse: true, BrowseTemplate: template + ".missing"},
+			url:        "/files/",
+			localPath:  "/",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			tt.ep.Serve(w, r, tt.localPath)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Serve() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Serve() Location = %q, want %q", got, tt.wantLocation)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("Serve() body = %q, want it to contain %q", w.Body.String(), want)
+				}
+			}
+			if strings.Contains(w.Body.String(), ".hidden") {
+				t.Errorf("Serve() listed hidden file: %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestEndpointFiles_BrowseJSON(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ep := &EndpointFiles{Source: dir, Browse: true}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	ep.Serve(w, r, "/")
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Serve() Content-Type = %q, want application/json", ct)
+	}
+	var got dirListing
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid listing %s: %v", w.Body.String(), err)
+	}
+	if len(got.Entries) != 2 {
+		t.Fatalf("listing = %+v, want 2 entries", got)
+	}
+	if e := got.Entries[0]; e.Name != "sub dir" || !e.Dir || e.URL != "sub%20dir/" {
+		t.Errorf("first entry = %+v, want directory 'sub dir'", e)
+	}
+	if e := got.Entries[1]; e.Name != "file.txt" || e.Dir || e.Size != 5 || e.Modified.IsZero() {
+		t.Errorf("second entry = %+v, want file.txt of 5 bytes", e)
+	}
+}
+
+func Test_prefersJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"application/json", true},
+		{"application/json; q=0.9, */*", true},
+		{"text/html,application/xhtml+xml,application/json;q=0.9,*/*;q=0.8", false},
+		{"*/*", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+			if got := prefersJSON(r); got != tt.want {
+				t.Errorf("prefersJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
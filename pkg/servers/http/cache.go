@@ -0,0 +1,754 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of EndpointCache
+const (
+	DefaultCacheMaxSize      int64 = 64 << 20
+	DefaultCacheMaxEntrySize int64 = 1 << 20
+	DefaultCacheDiskMaxSize  int64 = 1 << 30
+)
+
+// Parts of the request that keys of cached responses can be made of
+const (
+	CacheKeyScheme = "scheme"
+	CacheKeyHost   = "host"
+	CacheKeyPath   = "path"
+	CacheKeyQuery  = "query"
+	// Prefixes of parts with the values of a header or a cookie, like 'header:Accept-Language'
+	CacheKeyHeader = "header:"
+	CacheKeyCookie = "cookie:"
+)
+
+var CacheKeyParts = []string{CacheKeyScheme, CacheKeyHost, CacheKeyPath, CacheKeyQuery}
+
+var DefaultCacheKey = []string{CacheKeyHost, CacheKeyPath, CacheKeyQuery}
+
+// Values of the X-Cache header of responses
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheStale       = "STALE"
+	cacheRevalidated = "REVALIDATED"
+	cacheBypass      = "BYPASS"
+)
+
+// Statuses whose responses can be stored without Cache-Control or Expires, RFC 9110 15.1.
+// Partial responses are not stored
+var heuristicStatuses = []int{200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501}
+
+// EndpointCache is an endpoint function that stores responses of the proxy it wraps and
+// answers requests with them while they are fresh, as told by Cache-Control and Expires.
+// Requests it can't answer are passed to the proxy, concurrent ones for the same response
+// wait for the first of them instead of all going to the upstream
+type EndpointCache struct {
+	Proxy *EndpointProxy `json:"proxy"`
+	// Parts of the request that tell responses apart, DefaultCacheKey if nil. Headers listed
+	// in Vary of a response are added to its key
+	Key []string `json:"key,omitempty"`
+	// Memory the stored responses take, DefaultCacheMaxSize if zero. Responses in memory are
+	// dropped when the config is replaced
+	MaxSize int64 `json:"max_size,omitempty"`
+	// Responses with larger bodies are not stored, DefaultCacheMaxEntrySize if zero
+	MaxEntrySize int64 `json:"max_entry_size,omitempty"`
+	// Directory that responses are also written to, so that they outlive the memory and
+	// restarts. They are only kept in memory if empty
+	Dir string `json:"dir,omitempty"`
+	// Space the responses take in Dir, DefaultCacheDiskMaxSize if zero
+	DiskMaxSize int64 `json:"disk_max_size,omitempty"`
+	// Time responses without Cache-Control or Expires are fresh for. If zero they are only
+	// stored if they can be revalidated with ETag or Last-Modified
+	DefaultTTL time.Duration `json:"default_ttl,omitempty"`
+	// Time stale responses are still served while a new one is fetched in the background,
+	// for responses without their own stale-while-revalidate
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate,omitempty"`
+
+	once     sync.Once
+	store    *cacheStore
+	mu       sync.Mutex
+	flights  map[string]*cacheFlight
+	counters cacheCounters
+}
+
+// CacheMetrics are counters of the requests of a cache and the size of what it stores
+type CacheMetrics struct {
+	// Requests answered with a fresh stored response
+	Hits uint64 `json:"hits"`
+	// Requests passed to the proxy, to fetch or revalidate a response
+	Misses uint64 `json:"misses"`
+	// Requests answered with a stale response while it was revalidated
+	Stale uint64 `json:"stale"`
+	// Stored responses the upstream confirmed with 304
+	Revalidated uint64 `json:"revalidated"`
+	// Requests that can't be cached, like POST, passed to the proxy as is
+	Bypassed    uint64 `json:"bypassed"`
+	Entries     int    `json:"entries"`
+	Size        int64  `json:"size"`
+	DiskEntries int    `json:"disk_entries,omitempty"`
+	DiskSize    int64  `json:"disk_size,omitempty"`
+}
+
+type cacheCounters struct {
+	hits, misses, stale, revalidated, bypassed atomic.Uint64
+}
+
+// Metrics of the cache since the config was loaded
+func (c *EndpointCache) Metrics() CacheMetrics {
+	m := CacheMetrics{
+		Hits:        c.counters.hits.Load(),
+		Misses:      c.counters.misses.Load(),
+		Stale:       c.counters.stale.Load(),
+		Revalidated: c.counters.revalidated.Load(),
+		Bypassed:    c.counters.bypassed.Load(),
+	}
+	c.entries().metrics(&m)
+	return m
+}
+
+// Stored responses, the store is created on the first request. If the directory can't be
+// used, responses are only kept in memory
+func (c *EndpointCache) entries() *cacheStore {
+	c.once.Do(func() {
+		var disk *diskStore
+		if c.Dir != "" {
+			var err error
+			disk, err = openDiskStore(c.Dir, orDefault(c.DiskMaxSize, DefaultCacheDiskMaxSize))
+			if err != nil {
+				log.Printf("EndpointCache: error opening cache directory, responses are kept in memory: %v", err)
+			}
+		}
+		c.store = newCacheStore(orDefault(c.MaxSize, DefaultCacheMaxSize), disk)
+	})
+	return c.store
+}
+
+// Value, or the default if it's zero
+func orDefault[T comparable](v T, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+	return v
+}
+
+// Largest body that is stored. Responses larger than the memory are only kept on disk
+func (c *EndpointCache) maxEntrySize() int64 {
+	return orDefault(c.MaxEntrySize, DefaultCacheMaxEntrySize)
+}
+
+// Close the connections of the proxy
+func (c *EndpointCache) Close() error {
+	return c.Proxy.Close()
+}
+
+// Purge removes the stored responses to requests for the host and path and returns how many
+// there were. An empty host matches all hosts, a path ending with '*' matches the paths it is
+// a prefix of
+func (c *EndpointCache) Purge(host string, path string) int {
+	prefix, isPrefix := strings.CutSuffix(path, "*")
+	return c.entries().purge(func(m *cacheMeta) bool {
+		if host != "" && !strings.EqualFold(m.Host, host) {
+			return false
+		}
+		if isPrefix {
+			return strings.HasPrefix(m.Path, prefix)
+		}
+		return path == "" || m.Path == path
+	})
+}
+
+func (c *EndpointCache) Serve(w http.ResponseWriter, r *http.Request, localPath string) {
+	cc := parseCacheControl(r.Header.Values("Cache-Control"))
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || upgradeType(r.Header) != "" || cc.has("no-store") {
+		c.counters.bypassed.Add(1)
+		w.Header().Set("X-Cache", cacheBypass)
+		c.Proxy.Serve(w, r, localPath)
+		return
+	}
+	store := c.entries()
+	primary := c.key(r)
+	key := variantKey(primary, store.varyOf(primary), r)
+	stored := store.get(key)
+	if stored != nil && !requestNoCache(r, cc) {
+		now := time.Now()
+		if stored.fresh(now, cc) {
+			c.counters.hits.Add(1)
+			serveEntry(w, r, stored, now, cacheHit)
+			return
+		}
+		// a client that limits the age wants a response that isn't stale
+		if _, limited := cc.duration("max-age"); !limited && stored.age(now) < stored.Lifetime+stored.StaleWhileRevalidate {
+			c.counters.stale.Add(1)
+			serveEntry(w, r, stored, now, cacheStale)
+			// the client already has its response, the revalidation goes on without it
+			out := r.Clone(context.WithoutCancel(r.Context()))
+			out.Method = http.MethodGet
+			go c.revalidate(out, localPath, primary, stored)
+			return
+		}
+	}
+	if cc.has("only-if-cached") {
+		respondWithStatus(w, r, http.StatusGatewayTimeout)
+		return
+	}
+	if r.Method == http.MethodHead && stored == nil {
+		// the response has no body to store, it is stored once a GET asks for it
+		c.counters.misses.Add(1)
+		w.Header().Set("X-Cache", cacheMiss)
+		c.Proxy.Serve(w, r, localPath)
+		return
+	}
+
+	flight, first := c.join(key)
+	if !first {
+		select {
+		case <-flight.done:
+		case <-r.Context().Done():
+			return
+		}
+		key = variantKey(primary, store.varyOf(primary), r)
+		stored = store.get(key)
+		if now := time.Now(); stored != nil && stored.fresh(now, cc) {
+			c.counters.hits.Add(1)
+			serveEntry(w, r, stored, now, cacheHit)
+			return
+		}
+		// the response of the first request wasn't stored, this one fetches its own
+		flight = nil
+	}
+	c.counters.misses.Add(1)
+	cw := &cacheWriter{cache: c, client: w, r: r, primary: primary, stale: stored, flight: flight, hold: hasConditional(r.Header)}
+	c.Proxy.Serve(cw, upstreamRequest(r, stored), localPath)
+	cw.finish()
+}
+
+// Fetch the response again after a stale one was served, if another request isn't already
+// fetching it
+func (c *EndpointCache) revalidate(r *http.Request, localPath string, primary string, stale *cacheEntry) {
+	flight, first := c.join(stale.Key)
+	if !first {
+		return
+	}
+	c.counters.misses.Add(1)
+	cw := &cacheWriter{cache: c, r: r, primary: primary, stale: stale, flight: flight}
+	c.Proxy.Serve(cw, upstreamRequest(r, stale), localPath)
+	cw.finish()
+}
+
+// Key of the request made of the parts of the cache key
+func (c *EndpointCache) key(r *http.Request) string {
+	parts := c.Key
+	if parts == nil {
+		parts = DefaultCacheKey
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		var value string
+		switch {
+		case part == CacheKeyScheme:
+			value = "http"
+			if r.TLS != nil {
+				value = "https"
+			}
+		case part == CacheKeyHost:
+			value = strings.ToLower(r.Host)
+		case part == CacheKeyPath:
+			value = r.URL.EscapedPath()
+		case part == CacheKeyQuery:
+			value = r.URL.RawQuery
+		case strings.HasPrefix(part, CacheKeyHeader):
+			value = strings.Join(r.Header.Values(strings.TrimPrefix(part, CacheKeyHeader)), ",")
+		case strings.HasPrefix(part, CacheKeyCookie):
+			if cookie, err := r.Cookie(strings.TrimPrefix(part, CacheKeyCookie)); err == nil {
+				value = cookie.Value
+			}
+		}
+		b.WriteString(part)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(value))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Key of the variant of a response that varies by the headers
+func variantKey(primary string, vary []string, r *http.Request) string {
+	if len(vary) == 0 {
+		return primary
+	}
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range vary {
+		b.WriteString("vary:")
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(strings.Join(r.Header.Values(name), ",")))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Canonical names of the headers in Vary, sorted. ok is false if the response varies by
+// something other than headers
+func varyNames(h http.Header) (names []string, ok bool) {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name = http.CanonicalHeaderKey(name); name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, true
+}
+
+// Whether the client asks for the stored response to be revalidated before it is used
+func requestNoCache(r *http.Request, cc cacheControl) bool {
+	if cc.has("no-cache") {
+		return true
+	}
+	// Pragma only counts when Cache-Control is not there, RFC 9111 5.4
+	return r.Header.Get("Cache-Control") == "" && headerHasToken(r.Header, "Pragma", "no-cache")
+}
+
+// Headers that make the response depend on what the client already has. They are answered
+// from the stored response, the upstream is only asked for whole ones
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", "Range"}
+
+func hasConditional(h http.Header) bool {
+	for _, name := range conditionalHeaders {
+		if _, ok := h[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Request sent to the upstream for a response to store. Validators and ranges of the client
+// are removed so that the whole response comes back, the validators of the stored response
+// are added to revalidate it
+func upstreamRequest(r *http.Request, stale *cacheEntry) *http.Request {
+	var etag, modified string
+	if stale != nil {
+		etag, modified = stale.Header.Get("ETag"), stale.Header.Get("Last-Modified")
+	}
+	if etag == "" && modified == "" && !hasConditional(r.Header) {
+		return r
+	}
+	out := r.Clone(r.Context())
+	for _, name := range conditionalHeaders {
+		out.Header.Del(name)
+	}
+	if etag != "" {
+		out.Header.Set("If-None-Match", etag)
+	}
+	if modified != "" {
+		out.Header.Set("If-Modified-Since", modified)
+	}
+	return out
+}
+
+// Entry for the response to the request, nil if the response can't be stored
+func (c *EndpointCache) newEntry(r *http.Request, primary string, status int, header http.Header, now time.Time) *cacheEntry {
+	// partial and not modified responses are answers to a single client
+	if r.Method != http.MethodGet || status == http.StatusPartialContent || status == http.StatusNotModified {
+		return nil
+	}
+	cc := parseCacheControl(header.Values("Cache-Control"))
+	if cc.has("no-store") || cc.has("private") {
+		return nil
+	}
+	// cookies belong to a single client, trailers are not stored
+	if _, ok := header["Set-Cookie"]; ok {
+		return nil
+	}
+	if _, ok := header["Trailer"]; ok {
+		return nil
+	}
+	if r.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return nil
+	}
+	vary, ok := varyNames(header)
+	if !ok {
+		return nil
+	}
+	if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && length > c.maxEntrySize() {
+		return nil
+	}
+
+	e := &cacheEntry{cacheMeta: cacheMeta{
+		Key:     variantKey(primary, vary, r),
+		Primary: primary,
+		Host:    strings.ToLower(r.Host),
+		Path:    r.URL.Path,
+		Vary:    vary,
+		Status:  status,
+		Header:  header.Clone(),
+	}}
+	explicit := c.setFreshness(e, now)
+	if !explicit && !slices.Contains(heuristicStatuses, status) {
+		return nil
+	}
+	if e.Lifetime <= 0 && e.Header.Get("ETag") == "" && e.Header.Get("Last-Modified") == "" {
+		// it could never be used without fetching it again
+		return nil
+	}
+	return e
+}
+
+// Set the time the entry is stored and how long it can be used after that from its headers.
+// explicit is false if the headers didn't say how long the response is fresh
+func (c *EndpointCache) setFreshness(e *cacheEntry, now time.Time) (explicit bool) {
+	e.Stored = now
+	e.Age = 0
+	if age, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && age > 0 {
+		e.Age = time.Duration(age) * time.Second
+	}
+	e.Header.Del("Age")
+	e.Header.Del("X-Cache")
+
+	cc := parseCacheControl(e.Header.Values("Cache-Control"))
+	e.Lifetime, explicit = c.DefaultTTL, false
+	if d, ok := cc.duration("s-maxage"); ok {
+		e.Lifetime, explicit = d, true
+	} else if d, ok := cc.duration("max-age"); ok {
+		e.Lifetime, explicit = d, true
+	} else if expires := e.Header.Get("Expires"); expires != "" {
+		e.Lifetime, explicit = 0, true
+		// invalid dates mean the response is already stale
+		if t, err := http.ParseTime(expires); err == nil {
+			date, err := http.ParseTime(e.Header.Get("Date"))
+			if err != nil {
+				date = now
+			}
+			e.Lifetime = max(t.Sub(date), 0)
+		}
+	}
+
+	e.StaleWhileRevalidate = c.StaleWhileRevalidate
+	if d, ok := cc.duration("stale-while-revalidate"); ok {
+		e.StaleWhileRevalidate = d
+	}
+	if cc.has("no-cache") {
+		e.Lifetime, e.StaleWhileRevalidate = 0, 0
+	}
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") {
+		e.StaleWhileRevalidate = 0
+	}
+	return
+}
+
+// Entry refreshed with the headers of a 304 response to its revalidation
+func (c *EndpointCache) refresh(stale *cacheEntry, header http.Header, now time.Time) *cacheEntry {
+	e := &cacheEntry{cacheMeta: stale.cacheMeta, Body: stale.Body}
+	e.Header = stale.Header.Clone()
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Content-Range", "X-Cache":
+			// they describe the body of the 304 response, not the stored one
+		default:
+			e.Header[name] = values
+		}
+	}
+	if _, ok := header["Age"]; !ok {
+		e.Header.Del("Age")
+	}
+	c.setFreshness(e, now)
+	return e
+}
+
+// Time since the response was fetched from the upstream
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.Age + max(now.Sub(e.Stored), 0)
+}
+
+// Whether the response can be used without revalidation for a request with the directives
+func (e *cacheEntry) fresh(now time.Time, cc cacheControl) bool {
+	age := e.age(now)
+	if maxAge, ok := cc.duration("max-age"); ok && age > maxAge {
+		return false
+	}
+	return age < e.Lifetime
+}
+
+// Answer the request with the stored response
+func serveEntry(w http.ResponseWriter, r *http.Request, e *cacheEntry, now time.Time, status string) {
+	h := w.Header()
+	for name, values := range e.Header {
+		h[name] = slices.Clone(values)
+	}
+	h.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	h.Set("X-Cache", status)
+	if e.Status == http.StatusOK {
+		// conditional and range requests are answered from the stored body
+		modified, _ := http.ParseTime(e.Header.Get("Last-Modified"))
+		http.ServeContent(w, r, "", modified, bytes.NewReader(e.Body))
+		return
+	}
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		w.Write(e.Body)
+	}
+}
+
+// Fetch of a response that other requests for it wait for
+type cacheFlight struct {
+	key  string
+	done chan struct{}
+	once sync.Once
+}
+
+// Flight of the key, first is true if there was none and the caller has to land it
+func (c *EndpointCache) join(key string) (flight *cacheFlight, first bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f, false
+	}
+	if c.flights == nil {
+		c.flights = map[string]*cacheFlight{}
+	}
+	f := &cacheFlight{key: key, done: make(chan struct{})}
+	c.flights[key] = f
+	return f, true
+}
+
+// Let the requests waiting for the flight go, it can be called more than once
+func (c *EndpointCache) land(f *cacheFlight) {
+	f.once.Do(func() {
+		c.mu.Lock()
+		if c.flights[f.key] == f {
+			delete(c.flights, f.key)
+		}
+		c.mu.Unlock()
+		close(f.done)
+	})
+}
+
+// Writer that the proxy responds to, it passes the response to the client and keeps a copy
+// of it to store. Held responses are only sent once they are stored
+type cacheWriter struct {
+	cache *EndpointCache
+	// Writer of the client, nil when the response is revalidated in the background
+	client  http.ResponseWriter
+	r       *http.Request
+	primary string
+	// Stored response that is revalidated, nil if there is none
+	stale *cacheEntry
+	// Flight that other requests wait on, nil if there is none
+	flight *cacheFlight
+	// Answer the client from the stored response instead of passing the one of the upstream,
+	// for clients that asked for a range or sent validators
+	hold bool
+
+	header      http.Header
+	sent        http.Header
+	status      int
+	notModified bool
+	// Whether the response of the upstream was passed to the client
+	forwarded bool
+	// Entry of the response if it's stored, and its body so far
+	entry *cacheEntry
+	body  bytes.Buffer
+}
+
+func (cw *cacheWriter) Header() http.Header {
+	if cw.header == nil {
+		cw.header = http.Header{}
+	}
+	return cw.header
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	if status < 200 {
+		if cw.client != nil {
+			cw.client.WriteHeader(status)
+		}
+		return
+	}
+	cw.status = status
+	if status == http.StatusNotModified && cw.stale != nil {
+		// the client gets the stored response once the proxy is done
+		cw.notModified = true
+		return
+	}
+	cw.entry = cw.cache.newEntry(cw.r, cw.primary, status, cw.Header(), time.Now())
+	if cw.entry == nil {
+		cw.land()
+	}
+	if cw.entry == nil || !cw.hold {
+		cw.forward()
+	}
+}
+
+// Send the status and headers of the upstream response to the client
+func (cw *cacheWriter) forward() {
+	if cw.client == nil || cw.forwarded {
+		return
+	}
+	cw.forwarded = true
+	h := cw.client.Header()
+	for name, values := range cw.header {
+		h[name] = values
+	}
+	h.Set("X-Cache", cacheMiss)
+	cw.sent = cw.header.Clone()
+	cw.client.WriteHeader(cw.status)
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.notModified {
+		return len(p), nil
+	}
+	if cw.entry != nil {
+		if int64(cw.body.Len()+len(p)) > cw.cache.maxEntrySize() {
+			cw.discard()
+		} else {
+			cw.body.Write(p)
+		}
+	}
+	if !cw.forwarded {
+		return len(p), nil
+	}
+	n, err := cw.client.Write(p)
+	if err != nil {
+		cw.discard()
+	}
+	return n, err
+}
+
+func (cw *cacheWriter) Flush() {
+	if cw.forwarded {
+		http.NewResponseController(cw.client).Flush()
+	}
+}
+
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.client
+}
+
+// The proxy couldn't pass the whole body, what was written is not stored
+func (cw *cacheWriter) abort() {
+	cw.discard()
+}
+
+// Don't store the response, and let the requests waiting for it fetch their own. A client
+// whose answer was held gets the response of the upstream after all
+func (cw *cacheWriter) discard() {
+	held := cw.body.Bytes()
+	cw.entry = nil
+	cw.body = bytes.Buffer{}
+	cw.land()
+	if cw.status != 0 && !cw.notModified && !cw.forwarded {
+		cw.forward()
+		if cw.forwarded {
+			cw.client.Write(held)
+		}
+	}
+}
+
+func (cw *cacheWriter) land() {
+	if cw.flight != nil {
+		cw.cache.land(cw.flight)
+	}
+}
+
+// Store the response once the proxy is done with it, or answer the client with the stored one
+// if the upstream confirmed it
+func (cw *cacheWriter) finish() {
+	defer cw.land()
+	now := time.Now()
+	if cw.notModified {
+		e := cw.cache.refresh(cw.stale, cw.header, now)
+		cw.cache.entries().put(e)
+		cw.cache.counters.revalidated.Add(1)
+		if cw.client != nil {
+			serveEntry(cw.client, cw.r, e, now, cacheRevalidated)
+		}
+		return
+	}
+	if cw.entry != nil && cw.complete() {
+		cw.entry.Body = cw.body.Bytes()
+		cw.cache.entries().put(cw.entry)
+		if cw.client != nil && !cw.forwarded {
+			serveEntry(cw.client, cw.r, cw.entry, now, cacheMiss)
+		}
+		return
+	}
+	cw.discard()
+	if cw.forwarded {
+		// trailers are set after the body
+		h := cw.client.Header()
+		for name, values := range cw.header {
+			if _, ok := cw.sent[name]; !ok {
+				h[name] = values
+			}
+		}
+	}
+}
+
+// Whether the whole body of the response was written, without trailers that aren't stored
+func (cw *cacheWriter) complete() bool {
+	for name := range cw.header {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			return false
+		}
+	}
+	length, err := strconv.ParseInt(cw.entry.Header.Get("Content-Length"), 10, 64)
+	return err != nil || length == int64(cw.body.Len())
+}
+
+// Directives of a Cache-Control header, by their lowercase names
+type cacheControl map[string]string
+
+func parseCacheControl(values []string) cacheControl {
+	cc := cacheControl{}
+	for _, v := range values {
+		for _, directive := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// Value of a directive in seconds like max-age, ok is false if it's missing or invalid
+func (cc cacheControl) duration(name string) (d time.Duration, ok bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(min(seconds, int64(1<<63-1)/int64(time.Second))) * time.Second, true
+}
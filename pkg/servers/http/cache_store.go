@@ -0,0 +1,397 @@
+package http
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stored response, the metadata is kept apart from the body so that the disk store can index
+// its files without reading them whole
+type cacheEntry struct {
+	cacheMeta
+	Body []byte
+}
+
+type cacheMeta struct {
+	// Key of the variant, and the key of the request before headers of Vary were added
+	Key     string
+	Primary string
+	// Host and path of the request, for purges
+	Host string
+	Path string
+	// Canonical names of the headers the response varies by
+	Vary   []string
+	Status int
+	Header http.Header
+	// Time the response was received and the age it had then
+	Stored time.Time
+	Age    time.Duration
+	// Time the response is fresh for, and how long after that it is served while it is
+	// revalidated in the background
+	Lifetime             time.Duration
+	StaleWhileRevalidate time.Duration
+}
+
+// Approximate memory the entry takes
+func (e *cacheEntry) size() int64 {
+	size := int64(len(e.Body) + len(e.Key) + len(e.Primary) + len(e.Host) + len(e.Path))
+	for name, values := range e.Header {
+		size += int64(len(name))
+		for _, v := range values {
+			size += int64(len(v))
+		}
+	}
+	return size
+}
+
+// Least recently used list of items with a limit on their total size
+type lru struct {
+	max   int64
+	size  int64
+	order *list.List // of *lruItem, the most recent at the front
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	meta *cacheMeta
+	// Body and metadata of the response, nil in the index of the disk store
+	entry *cacheEntry
+	size  int64
+}
+
+func newLRU(max int64) *lru {
+	return &lru{max: max, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// Item of the key marked as the most recently used, nil if there is none
+func (l *lru) get(key string) *lruItem {
+	el, ok := l.items[key]
+	if !ok {
+		return nil
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruItem)
+}
+
+func (l *lru) has(key string) bool {
+	_, ok := l.items[key]
+	return ok
+}
+
+// Add the item in place of the one with the same key, and return the items evicted to make
+// room for it. Items larger than the whole list are not added
+func (l *lru) add(item *lruItem) (evicted []*lruItem) {
+	l.remove(item.meta.Key)
+	if item.size > l.max {
+		return nil
+	}
+	l.items[item.meta.Key] = l.order.PushFront(item)
+	l.size += item.size
+	for l.size > l.max {
+		evicted = append(evicted, l.remove(l.order.Back().Value.(*lruItem).meta.Key))
+	}
+	return
+}
+
+// Remove the item of the key, nil if there was none
+func (l *lru) remove(key string) *lruItem {
+	el, ok := l.items[key]
+	if !ok {
+		return nil
+	}
+	item := l.order.Remove(el).(*lruItem)
+	delete(l.items, key)
+	l.size -= item.size
+	return item
+}
+
+// Store of the responses of a cache. They are kept in memory, and in a directory if the store
+// has one. Each of them evicts the least recently used responses when it is full
+type cacheStore struct {
+	mu     sync.Mutex
+	memory *lru
+	disk   *diskStore
+	// Headers that responses vary by and the number of their stored variants, by the primary
+	// key of the request
+	vary map[string]*cacheVary
+}
+
+type cacheVary struct {
+	names    []string
+	variants int
+}
+
+func newCacheStore(maxSize int64, disk *diskStore) *cacheStore {
+	return &cacheStore{memory: newLRU(maxSize), disk: disk, vary: map[string]*cacheVary{}}
+}
+
+// Headers that responses to requests with the primary key vary by
+func (s *cacheStore) varyOf(primary string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.vary[primary]; ok {
+		return v.names
+	}
+	return nil
+}
+
+// Response stored with the key, nil if there is none. Responses found on disk are loaded to
+// memory
+func (s *cacheStore) get(key string) *cacheEntry {
+	s.mu.Lock()
+	if item := s.memory.get(key); item != nil {
+		s.mu.Unlock()
+		return item.entry
+	}
+	onDisk := s.disk != nil && s.disk.index.get(key) != nil
+	s.mu.Unlock()
+	if !onDisk {
+		return nil
+	}
+
+	e, err := s.disk.read(key)
+	if err != nil {
+		log.Printf("cacheStore.get: error reading cached response: %v", err)
+		s.mu.Lock()
+		if item := s.disk.index.remove(key); item != nil {
+			s.disk.delete(key)
+			s.forget(item.meta)
+		}
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memory.add(&lruItem{meta: &e.cacheMeta, entry: e, size: e.size()})
+	return e
+}
+
+// Store the response in place of the one with the same key
+func (s *cacheStore) put(e *cacheEntry) {
+	var size int64
+	if s.disk != nil {
+		var err error
+		size, err = s.disk.write(e)
+		if err != nil {
+			log.Printf("cacheStore.put: error writing cached response: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := s.memory.has(e.Key) || s.disk != nil && s.disk.index.has(e.Key)
+	if v, ok := s.vary[e.Primary]; !ok || !slices.Equal(v.names, e.Vary) {
+		// variants of other headers can't be found anymore, they are left to be evicted
+		s.vary[e.Primary] = &cacheVary{names: e.Vary, variants: 1}
+	} else if !known {
+		v.variants++
+	}
+	for _, item := range s.memory.add(&lruItem{meta: &e.cacheMeta, entry: e, size: e.size()}) {
+		s.forget(item.meta)
+	}
+	if s.disk != nil && size > 0 {
+		for _, item := range s.disk.index.add(&lruItem{meta: &e.cacheMeta, size: size}) {
+			s.disk.delete(item.meta.Key)
+			s.forget(item.meta)
+		}
+		if !s.disk.index.has(e.Key) {
+			// larger than the whole directory may take
+			s.disk.delete(e.Key)
+		}
+	}
+	// the response may be too large for both
+	s.forget(&e.cacheMeta)
+}
+
+// Count a response that was removed from one of the stores, the headers its request varies by
+// are forgotten once no variant is left. The lock must be held
+func (s *cacheStore) forget(meta *cacheMeta) {
+	if s.memory.has(meta.Key) || s.disk != nil && s.disk.index.has(meta.Key) {
+		return
+	}
+	if v, ok := s.vary[meta.Primary]; ok {
+		if v.variants--; v.variants <= 0 {
+			delete(s.vary, meta.Primary)
+		}
+	}
+}
+
+// Remove the responses whose metadata matches, and return how many were removed
+func (s *cacheStore) purge(match func(m *cacheMeta) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matched []*cacheMeta
+	for _, el := range s.memory.items {
+		if meta := el.Value.(*lruItem).meta; match(meta) {
+			matched = append(matched, meta)
+		}
+	}
+	if s.disk != nil {
+		// responses in both stores are only counted once
+		for key, el := range s.disk.index.items {
+			if meta := el.Value.(*lruItem).meta; !s.memory.has(key) && match(meta) {
+				matched = append(matched, meta)
+			}
+		}
+	}
+	for _, meta := range matched {
+		s.memory.remove(meta.Key)
+		if s.disk != nil {
+			s.disk.remove(meta.Key)
+		}
+		s.forget(meta)
+	}
+	return len(matched)
+}
+
+// Number and size of the responses in memory and on disk
+func (s *cacheStore) metrics(m *CacheMetrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.Entries, m.Size = len(s.memory.items), s.memory.size
+	if s.disk != nil {
+		m.DiskEntries, m.DiskSize = len(s.disk.index.items), s.disk.index.size
+	}
+}
+
+// Directory with a file for each stored response. The index of the files is kept in memory,
+// it is guarded by the lock of the store
+type diskStore struct {
+	dir   string
+	index *lru
+}
+
+// Open the directory and index the responses stored there before, it is created if needed
+func openDiskStore(dir string, maxSize int64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	d := &diskStore{dir: dir, index: newLRU(maxSize)}
+	var items []*lruItem
+	for _, f := range files {
+		name := filepath.Join(dir, f.Name())
+		if f.IsDir() || len(f.Name()) != sha256.Size*2 {
+			if filepath.Ext(f.Name()) == ".tmp" {
+				// left by a write that didn't finish
+				os.Remove(name)
+			}
+			continue
+		}
+		meta, size, err := readCacheMeta(name)
+		if err == nil && d.file(meta.Key) != name {
+			err = fmt.Errorf("name doesn't match the key")
+		}
+		if err != nil {
+			log.Printf("openDiskStore: removing unreadable cache file '%s': %v", name, err)
+			os.Remove(name)
+			continue
+		}
+		items = append(items, &lruItem{meta: meta, size: size})
+	}
+	// the most recently stored responses are the last to be evicted
+	sort.Slice(items, func(i, j int) bool { return items[i].meta.Stored.Before(items[j].meta.Stored) })
+	for _, item := range items {
+		for _, evicted := range d.index.add(item) {
+			d.delete(evicted.meta.Key)
+		}
+	}
+	return d, nil
+}
+
+func readCacheMeta(name string) (*cacheMeta, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	meta := &cacheMeta{}
+	if err = gob.NewDecoder(f).Decode(meta); err != nil {
+		return nil, 0, err
+	}
+	return meta, info.Size(), nil
+}
+
+// File of the response with the key
+func (d *diskStore) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+func (d *diskStore) read(key string) (*cacheEntry, error) {
+	f, err := os.Open(d.file(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	e := &cacheEntry{}
+	dec := gob.NewDecoder(f)
+	if err = dec.Decode(&e.cacheMeta); err != nil {
+		return nil, err
+	}
+	if err = dec.Decode(&e.Body); err != nil {
+		return nil, err
+	}
+	if e.Key != key {
+		return nil, fmt.Errorf("file '%s' has the response of another key", d.file(key))
+	}
+	return e, nil
+}
+
+// Write the response to its file, readers see either the previous file or the whole new one.
+// It returns the size of the file
+func (d *diskStore) write(e *cacheEntry) (int64, error) {
+	f, err := os.CreateTemp(d.dir, "*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	enc := gob.NewEncoder(f)
+	err = enc.Encode(&e.cacheMeta)
+	if err == nil {
+		err = enc.Encode(e.Body)
+	}
+	var size int64
+	if err == nil {
+		size, err = f.Seek(0, io.SeekCurrent)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size, os.Rename(f.Name(), d.file(e.Key))
+}
+
+// Remove the response from the index and delete its file
+func (d *diskStore) remove(key string) {
+	if d.index.remove(key) != nil {
+		d.delete(key)
+	}
+}
+
+func (d *diskStore) delete(key string) {
+	if err := os.Remove(d.file(key)); err != nil && !os.IsNotExist(err) {
+		log.Printf("diskStore.delete: error removing cached response: %v", err)
+	}
+}
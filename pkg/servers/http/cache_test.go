@@ -0,0 +1,412 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Upstream that responds with the headers and a body that counts its requests. Ranges and
+// validators are answered like a file server does
+func countingUpstream(header http.Header) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		body := fmt.Sprintf("%d %s", n, r.Header.Get("Accept-Language"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	return server, &requests
+}
+
+// Send a request to the cache and return the response
+func cacheGet(cache *EndpointCache, method string, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	cache.Serve(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	return w
+}
+
+func TestEndpointCache_Serve(t *testing.T) {
+	type request struct {
+		method     string
+		target     string
+		header     http.Header
+		wantStatus int
+		wantCache  string
+		wantBody   string
+	}
+	tests := []struct {
+		name     string
+		header   http.Header
+		requests []request
+	}{
+		{
+			name:   "max_age",
+			header: http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{
+				{wantCache: cacheMiss, wantBody: "1 "},
+				{wantCache: cacheHit, wantBody: "1 "},
+				{method: http.MethodHead, wantCache: cacheHit},
+				{target: "/items?page=2", wantCache: cacheMiss, wantBody: "2 "},
+				{header: http.Header{"Cache-Control": {"no-cache"}}, wantCache: cacheMiss, wantBody: "3 "},
+				{wantCache: cacheHit, wantBody: "3 "},
+				{method: http.MethodPost, wantCache: cacheBypass, wantBody: "4 "},
+			},
+		}, {
+			name:   "no_store",
+			header: http.Header{"Cache-Control": {"no-store"}},
+			requests: []request{
+				{wantCache: cacheMiss, wantBody: "1 "},
+				{wantCache: cacheMiss, wantBody: "2 "},
+			},
+		}, {
+			name:   "private",
+			header: http.Header{"Cache-Control": {"private, max-age=60"}},
+			requests: []request{
+				{wantCache: cacheMiss, wantBody: "1 "},
+				{wantCache: cacheMiss, wantBody: "2 "},
+			},
+		}, {
+			name:   "set_cookie",
+			header: http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"session=1"}},
+			requests: []request{
+				{wantCache: cacheMiss, wantBody: "1 "},
+				{wantCache: cacheMiss, wantBody: "2 "},
+			},
+		}, {
+			name:   "authorization",
+			header: http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{
+				{header: http.Header{"Authorization": {"Bearer a"}}, wantCache: cacheMiss, wantBody: "1 "},
+				{wantCache: cacheMiss, wantBody: "2 "},
+				{header: http.Header{"Authorization": {"Bearer a"}}, wantCache: cacheHit, wantBody: "2 "},
+			},
+		}, {
+			name:   "request_no_store",
+			header: http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{
+				{header: http.Header{"Cache-Control": {"no-store"}}, wantCache: cacheBypass, wantBody: "1 "},
+				{wantCache: cacheMiss, wantBody: "2 "},
+			},
+		}, {
+			name:   "expires",
+			header: http.Header{"Expires": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+			requests: []request{
+				{wantCache: cacheMiss, wantBody: "1 "},
+				{wantCache: cacheHit, wantBody: "1 "},
+			},
+		}, {
+			name:   "expired",
+			header: http.Header{"Expires": {"0"}},
+			requests: []request{
+				{wantCache: cacheMiss, wantBody: "1 "},
+				{wantCache: cacheMiss, wantBody: "2 "},
+			},
+		}, {
+			name:   "vary",
+			header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"accept-language"}},
+			requests: []request{
+				{header: http.Header{"Accept-Language": {"en"}}, wantCache: cacheMiss, wantBody: "1 en"},
+				{header: http.Header{"Accept-Language": {"de"}}, wantCache: cacheMiss, wantBody: "2 de"},
+				{header: http.Header{"Accept-Language": {"en"}}, wantCache: cacheHit, wantBody: "1 en"},
+				{header: http.Header{"Accept-Language": {"de"}}, wantCache: cacheHit, wantBody: "2 de"},
+			},
+		}, {
+			name:   "vary_all",
+			header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}},
+			requests: []request{
+				{wantCache: cacheMiss, wantBody: "1 "},
+				{wantCache: cacheMiss, wantBody: "2 "},
+			},
+		}, {
+			name:   "head_miss",
+			header: http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{
+				{method: http.MethodHead, wantCache: cacheMiss},
+				{wantCache: cacheMiss, wantBody: "2 "},
+				{wantCache: cacheHit, wantBody: "2 "},
+			},
+		}, {
+			name:   "range",
+			header: http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{
+				{header: http.Header{"Range": {"bytes=0-0"}}, wantStatus: http.StatusPartialContent, wantCache: cacheMiss, wantBody: "1"},
+				{wantStatus: http.StatusOK, wantCache: cacheHit, wantBody: "1 "},
+				{header: http.Header{"Range": {"bytes=1-1"}}, wantStatus: http.StatusPartialContent, wantCache: cacheHit, wantBody: " "},
+			},
+		}, {
+			name:   "conditional",
+			header: http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"a"`}},
+			requests: []request{
+				{header: http.Header{"If-None-Match": {`"a"`}}, wantStatus: http.StatusNotModified, wantCache: cacheMiss},
+				{wantStatus: http.StatusOK, wantCache: cacheHit, wantBody: "1 "},
+				{header: http.Header{"If-None-Match": {`"b"`}}, wantStatus: http.StatusOK, wantCache: cacheHit, wantBody: "1 "},
+				{header: http.Header{"If-None-Match": {`"a"`}}, wantStatus: http.StatusNotModified, wantCache: cacheHit},
+			},
+		}, {
+			name:   "conditional_not_stored",
+			header: http.Header{"Cache-Control": {"no-store"}, "Etag": {`"a"`}},
+			requests: []request{
+				{header: http.Header{"Range": {"bytes=0-0"}}, wantStatus: http.StatusOK, wantCache: cacheMiss, wantBody: "1 "},
+				{wantStatus: http.StatusOK, wantCache: cacheMiss, wantBody: "2 "},
+			},
+		}, {
+			name:   "only_if_cached",
+			header: http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{
+				{header: http.Header{"Cache-Control": {"only-if-cached"}}, wantStatus: http.StatusGatewayTimeout},
+				{wantCache: cacheMiss, wantBody: "1 "},
+				{header: http.Header{"Cache-Control": {"only-if-cached"}}, wantCache: cacheHit, wantBody: "1 "},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, _ := countingUpstream(tt.header)
+			defer upstream.Close()
+			cache := &EndpointCache{Proxy: proxyTo(upstream, ProxyTransport{})}
+			defer cache.Close()
+			for i, req := range tt.requests {
+				if req.method == "" {
+					req.method = http.MethodGet
+				}
+				if req.target == "" {
+					req.target = "/items"
+				}
+				w := cacheGet(cache, req.method, req.target, req.header)
+				if req.wantStatus != 0 && w.Code != req.wantStatus {
+					t.Errorf("request %d: status = %v, want %v", i, w.Code, req.wantStatus)
+				}
+				if req.wantCache == "" {
+					continue
+				}
+				if got := w.Header().Get("X-Cache"); got != req.wantCache || w.Body.String() != req.wantBody {
+					t.Errorf("request %d: X-Cache = %q, body %q, want %q, %q", i, got, w.Body.String(), req.wantCache, req.wantBody)
+				}
+			}
+		})
+	}
+}
+
+// Responses to a single client are never stored, whatever their Cache-Control says
+func TestEndpointCache_newEntry(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantStored bool
+	}{
+		{name: "ok", status: http.StatusOK, wantStored: true},
+		{name: "found", status: http.StatusFound, wantStored: true},
+		{name: "partial", status: http.StatusPartialContent},
+		{name: "not_modified", status: http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &EndpointCache{}
+			header := http.Header{"Cache-Control": {"public, max-age=60"}, "Etag": {`"a"`}}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if got := cache.newEntry(r, "key", tt.status, header, time.Now()) != nil; got != tt.wantStored {
+				t.Errorf("newEntry() stored = %v, want %v", got, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestEndpointCache_Revalidate(t *testing.T) {
+	var requests, notModified atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body"))
+	}))
+	defer upstream.Close()
+	cache := &EndpointCache{Proxy: proxyTo(upstream, ProxyTransport{})}
+	defer cache.Close()
+
+	cacheGet(cache, http.MethodGet, "/", nil)
+	w := cacheGet(cache, http.MethodGet, "/", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "body" || w.Header().Get("X-Cache") != cacheRevalidated {
+		t.Errorf("revalidated response = %v %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	// the client's own validators are checked against the stored response
+	w = cacheGet(cache, http.MethodGet, "/", http.Header{"If-None-Match": {`"v1"`}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional response = %v, want %v", w.Code, http.StatusNotModified)
+	}
+	if requests.Load() != 3 || notModified.Load() != 2 {
+		t.Errorf("upstream got %d requests, %d not modified, want 3 and 2", requests.Load(), notModified.Load())
+	}
+	if m := cache.Metrics(); m.Revalidated != 2 || m.Entries != 1 {
+		t.Errorf("Metrics() = %+v", m)
+	}
+}
+
+func TestEndpointCache_StaleWhileRevalidate(t *testing.T) {
+	upstream, requests := countingUpstream(http.Header{"Cache-Control": {"max-age=1, stale-while-revalidate=60"}})
+	defer upstream.Close()
+	cache := &EndpointCache{Proxy: proxyTo(upstream, ProxyTransport{})}
+	defer cache.Close()
+
+	cacheGet(cache, http.MethodGet, "/", nil)
+	// the stored response was fetched long ago
+	e := cache.entries().get(cache.key(httptest.NewRequest(http.MethodGet, "/", nil)))
+	e.Stored = e.Stored.Add(-10 * time.Second)
+
+	w := cacheGet(cache, http.MethodGet, "/", nil)
+	if w.Body.String() != "1 " || w.Header().Get("X-Cache") != cacheStale || w.Header().Get("Age") != "10" {
+		t.Errorf("stale response = %q %v", w.Body.String(), w.Header())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		w = cacheGet(cache, http.MethodGet, "/", nil)
+		if w.Header().Get("X-Cache") == cacheHit {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("response wasn't revalidated in the background, X-Cache %q", w.Header().Get("X-Cache"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.Body.String() != "2 " || requests.Load() != 2 {
+		t.Errorf("revalidated response = %q after %d requests, want %q after 2", w.Body.String(), requests.Load(), "2 ")
+	}
+}
+
+func TestEndpointCache_Coalesce(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("body"))
+	}))
+	defer upstream.Close()
+	cache := &EndpointCache{Proxy: proxyTo(upstream, ProxyTransport{})}
+	defer cache.Close()
+
+	const clients = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = cacheGet(cache, http.MethodGet, "/", nil).Body.String()
+		}(i)
+	}
+	// let all clients reach the cache before the upstream responds
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for i, body := range bodies {
+		if body != "body" {
+			t.Errorf("client %d got %q", i, body)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("upstream got %d requests, want 1", requests.Load())
+	}
+}
+
+func TestEndpointCache_Limits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(strings.Repeat("x", len(r.URL.Path)*100)))
+	}))
+	defer upstream.Close()
+	cache := &EndpointCache{Proxy: proxyTo(upstream, ProxyTransport{}), MaxSize: 1200, MaxEntrySize: 500}
+	defer cache.Close()
+
+	// responses of 300 bytes with headers, two of them fit
+	for _, path := range []string{"/aa", "/bb", "/aa", "/cc", "/aa", "/bb"} {
+		cacheGet(cache, http.MethodGet, path, nil)
+	}
+	wantCache := map[string]string{"/aa": cacheHit, "/bb": cacheHit, "/cc": cacheMiss, "/dddddd": cacheMiss}
+	for _, path := range []string{"/aa", "/bb", "/cc", "/dddddd", "/dddddd"} {
+		if got := cacheGet(cache, http.MethodGet, path, nil).Header().Get("X-Cache"); got != wantCache[path] {
+			t.Errorf("%s: X-Cache = %q, want %q", path, got, wantCache[path])
+		}
+	}
+}
+
+func TestEndpointCache_Disk(t *testing.T) {
+	upstream, requests := countingUpstream(http.Header{"Cache-Control": {"max-age=60"}, "Content-Type": {"text/plain"}})
+	defer upstream.Close()
+	dir := t.TempDir()
+	cache := &EndpointCache{Proxy: proxyTo(upstream, ProxyTransport{}), Dir: dir, MaxSize: 1}
+	defer cache.Close()
+	cacheGet(cache, http.MethodGet, "/a", nil)
+	cacheGet(cache, http.MethodGet, "/b", nil)
+	// responses don't fit in memory and are read from disk
+	if w := cacheGet(cache, http.MethodGet, "/a", nil); w.Header().Get("X-Cache") != cacheHit || w.Body.String() != "1 " {
+		t.Errorf("response from disk = %q %v", w.Body.String(), w.Header())
+	}
+
+	// a new cache finds the responses of the previous one
+	reloaded := &EndpointCache{Proxy: proxyTo(upstream, ProxyTransport{}), Dir: dir}
+	defer reloaded.Close()
+	w := cacheGet(reloaded, http.MethodGet, "/b", nil)
+	if w.Header().Get("X-Cache") != cacheHit || w.Body.String() != "2 " || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("response after reload = %q %v", w.Body.String(), w.Header())
+	}
+	if m := reloaded.Metrics(); m.DiskEntries != 2 || m.Entries != 1 {
+		t.Errorf("Metrics() = %+v", m)
+	}
+	if n := reloaded.Purge("", "/a"); n != 1 {
+		t.Errorf("Purge() = %d, want 1", n)
+	}
+	if w := cacheGet(reloaded, http.MethodGet, "/a", nil); w.Header().Get("X-Cache") != cacheMiss || requests.Load() != 3 {
+		t.Errorf("purged response = %v after %d requests", w.Header().Get("X-Cache"), requests.Load())
+	}
+}
+
+func TestEndpointCache_Purge(t *testing.T) {
+	tests := []struct {
+		name       string
+		host       string
+		path       string
+		wantPurged int
+	}{
+		{name: "all", wantPurged: 3},
+		{name: "path", path: "/api/a", wantPurged: 2},
+		{name: "prefix", path: "/api/*", wantPurged: 3},
+		{name: "host", host: "Example.com", path: "/api/a", wantPurged: 1},
+		{name: "other_host", host: "example.org", wantPurged: 0},
+		{name: "no_match", path: "/api", wantPurged: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, _ := countingUpstream(http.Header{"Cache-Control": {"max-age=60"}})
+			defer upstream.Close()
+			cache := &EndpointCache{Proxy: proxyTo(upstream, ProxyTransport{})}
+			defer cache.Close()
+			for _, target := range []string{"http://example.com/api/a", "http://example.net/api/a", "http://example.com/api/b?x=1"} {
+				cacheGet(cache, http.MethodGet, target, nil)
+			}
+			if got := cache.Purge(tt.host, tt.path); got != tt.wantPurged {
+				t.Errorf("Purge() = %d, want %d", got, tt.wantPurged)
+			}
+			if m := cache.Metrics(); m.Entries != 3-tt.wantPurged {
+				t.Errorf("%d entries left, want %d", m.Entries, 3-tt.wantPurged)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package http
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Compression compresses responses of an endpoint for clients that accept it
+type Compression struct {
+	// Encodings in order of preference, names are keys of CompressEncodings. Nil means
+	// DefaultCompressEncodings
+	Encodings []string `json:"encodings"`
+	// Responses smaller than this are sent as is, as compression wouldn't save much
+	MinSize int64 `json:"min_size"`
+	// Media types that are compressed, patterns like 'text/*' are allowed. Nil means
+	// DefaultCompressTypes
+	Types []string `json:"types"`
+}
+
+// CompressEncodings maps supported content encodings to their writers
+var CompressEncodings = map[string]func(w io.Writer) io.WriteCloser{
+	"br": func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	},
+	"gzip": func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	// deflate content coding is the zlib format, not raw deflate
+	"deflate": func(w io.Writer) io.WriteCloser {
+		return zlib.NewWriter(w)
+	},
+}
+
+var DefaultCompressEncodings = []string{"br", "gzip", "deflate"}
+
+// Text formats that compress well, images and archives are compressed already
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// Wrap the response writer, Close must be called after the response is written
+func (c *Compression) wrap(w http.ResponseWriter, r *http.Request) *compressWriter {
+	return &compressWriter{ResponseWriter: w, c: c, r: r}
+}
+
+// Choose an encoding accepted by the client, empty if there is none
+func (c *Compression) negotiate(r *http.Request) string {
+	encodings := c.Encodings
+	if encodings == nil {
+		encodings = DefaultCompressEncodings
+	}
+	accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	candidates := []string{}
+	for _, e := range encodings {
+		if _, ok := CompressEncodings[e]; ok && accepted(e) > 0 {
+			candidates = append(candidates, e)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return accepted(candidates[i]) > accepted(candidates[j])
+	})
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+func (c *Compression) allowsType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := c.Types
+	if types == nil {
+		types = DefaultCompressTypes
+	}
+	for _, pattern := range types {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter decides whether to compress when the response starts. Without a known length
+// the body is buffered up to the minimum size before deciding
+type compressWriter struct {
+	http.ResponseWriter
+	c *Compression
+	r *http.Request
+
+	status  int
+	started bool // headers are sent to the client
+	decided bool
+	buf     []byte
+	encoder io.WriteCloser
+	hijack  bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.hijack {
+		return
+	}
+	// informational responses don't end the header
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if !cw.eligible() {
+		cw.decide(false)
+		return
+	}
+	if length := cw.Header().Get("Content-Length"); length != "" {
+		n, err := strconv.ParseInt(length, 10, 64)
+		cw.decide(err == nil && n >= cw.c.MinSize)
+	} else if cw.c.MinSize <= 0 {
+		cw.decide(true)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.hijack {
+		return 0, http.ErrHijacked
+	}
+	if cw.status == 0 {
+		// the content type is sniffed like net/http does, to know if it can be compressed
+		if cw.Header().Get("Content-Type") == "" && cw.Header().Get("Content-Encoding") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if int64(len(cw.buf)) >= cw.c.MinSize {
+			if err := cw.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Response can be compressed if the client accepts it, the Vary header is set in any case
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+	switch {
+	case cw.status < 200, cw.status == http.StatusNoContent, cw.status == http.StatusNotModified:
+		return false
+	// ranges are of the uncompressed content
+	case cw.status == http.StatusPartialContent, h.Get("Content-Range") != "":
+		return false
+	// already encoded by the endpoint or the upstream server
+	case h.Get("Content-Encoding") != "" && !strings.EqualFold(h.Get("Content-Encoding"), "identity"):
+		return false
+	case !cw.c.allowsType(h.Get("Content-Type")):
+		return false
+	// the endpoint or the upstream asks proxies to pass the body as is
+	case parseCacheControl(h.Values("Cache-Control")).has("no-transform"):
+		return false
+	}
+	h.Add("Vary", "Accept-Encoding")
+	return true
+}
+
+// Send the headers and the buffered body, with compression if enabled and accepted
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if compress {
+		if encoding := cw.c.negotiate(cw.r); encoding != "" {
+			h.Set("Content-Encoding", encoding)
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			// the compressed body is another representation, a strong tag would claim it is the
+			// same bytes
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			// HEAD gets the headers of the GET response, there is no body to encode
+			if cw.r.Method != http.MethodHead {
+				cw.encoder = CompressEncodings[encoding](cw.ResponseWriter)
+			}
+		}
+	}
+	cw.started = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what was written so far. Streaming responses are compressed without waiting for
+// the minimum size
+func (cw *compressWriter) Flush() {
+	if cw.hijack {
+		return
+	}
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response, small bodies that were buffered are sent uncompressed
+func (cw *compressWriter) Close() error {
+	if cw.hijack {
+		return nil
+	}
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
+
+// Hijack lets upgraded connections through, it's only possible before the response starts
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if cw.started {
+		return nil, nil, errors.New("http: response already started")
+	}
+	conn, rw, err := http.NewResponseController(cw.ResponseWriter).Hijack()
+	if err == nil {
+		cw.hijack = true
+	}
+	return conn, rw, err
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
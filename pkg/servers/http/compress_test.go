@@ -0,0 +1,332 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Reader that undoes a content encoding
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader = bytes.NewReader(body)
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(r)
+	case "deflate":
+		r, err = zlib.NewReader(r)
+	case "br":
+		r = brotli.NewReader(r)
+	}
+	if err != nil {
+		t.Fatalf("decode %s: %v", encoding, err)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decode %s: %v", encoding, err)
+	}
+	return string(decoded)
+}
+
+// Function that writes a fixed response
+type testFunction struct {
+	header  map[string]string
+	status  int
+	chunks  []string
+	flushes bool
+}
+
+func (f *testFunction) Serve(w http.ResponseWriter, r *http.Request, localPath string) {
+	for k, v := range f.header {
+		w.Header().Set(k, v)
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+	}
+	for _, chunk := range f.chunks {
+		io.WriteString(w, chunk)
+		if f.flushes {
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	text := strings.Repeat("compressible text ", 100)
+
+	tests := []struct {
+		name           string
+		compress       *Compression
+		function       *testFunction
+		method         string
+		acceptEncoding string
+		wantEncoding   string
+		wantStatus     int
+		wantHeader     map[string]string
+	}{
+		{
+			name:           "gzip",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, chunks: []string{text}},
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantHeader:     map[string]string{"Vary": "Accept-Encoding"},
+		}, {
+			name:           "deflate",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, chunks: []string{text}},
+			acceptEncoding: "deflate",
+			wantEncoding:   "deflate",
+		}, {
+			name:           "br_preferred",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, chunks: []string{text}},
+			acceptEncoding: "gzip, deflate, br",
+			wantEncoding:   "br",
+		}, {
+			name:           "client_quality",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, chunks: []string{text}},
+			acceptEncoding: "br;q=0.5, gzip",
+			wantEncoding:   "gzip",
+		}, {
+			name:           "configured_encodings",
+			compress:       &Compression{Encodings: []string{"gzip"}, MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, chunks: []string{text}},
+			acceptEncoding: "br",
+			wantEncoding:   "",
+		}, {
+			name:           "not_accepted",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, chunks: []string{text}},
+			acceptEncoding: "",
+			wantEncoding:   "",
+			wantHeader:     map[string]string{"Vary": "Accept-Encoding"},
+		}, {
+			name:           "small",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, chunks: []string{"small"}},
+			acceptEncoding: "gzip",
+			wantEncoding:   "",
+		}, {
+			name:     "small_with_length",
+			compress: &Compression{MinSize: 1024},
+			function: &testFunction{
+				header: map[string]string{"Content-Type": "text/plain", "Content-Length": "5"},
+				chunks: []string{"small"},
+			},
+			acceptEncoding: "gzip",
+			wantEncoding:   "",
+			wantHeader:     map[string]string{"Content-Length": "5"},
+		}, {
+			name:           "chunks",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, chunks: []string{text[:500], text[500:]}},
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+		}, {
+			name:           "sniffed_type",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{chunks: []string{text}},
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantHeader:     map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+		}, {
+			name:           "disallowed_type",
+			compress:       &Compression{MinSize: 1024},
+			function:       &testFunction{header: map[string]string{"Content-Type": "image/png"}, chunks: []string{text}},
+			acceptEncoding: "gzip",
+			wantEncoding:   "",
+			wantHeader:     map[string]string{"Vary": ""},
+		}, {
+			name:           "configured_types",
+			compress:       &Compression{MinSize: 1024, Types: []string{"image/*"}},
+			function:       &testFunction{header: map[string]string{"Content-Type": "image/png"}, chunks: []string{text}},
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+		}, {
+			name:     "already_encoded",
+			compress: &Compression{MinSize: 1024},
+			function: &testFunction{
+				header: map[string]string{"Content-Type": "text/plain", "Content-Encoding": "gzip"},
+				chunks: []string{text},
+			},
+			acceptEncoding: "br",
+			wantEncoding:   "gzip",
+		}, {
+			name:     "headers",
+			compress: &Compression{MinSize: 1024},
+			function: &testFunction{
+				header: map[string]string{
+					"Content-Type":   "text/plain",
+					"Content-Length": "1800",
+					"Accept-Ranges":  "bytes",
+					"ETag":           `"tag"`,
+				},
+				chunks: []string{text},
+			},
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantHeader: map[string]string{
+				"Content-Length": "",
+				"Accept-Ranges":  "",
+				"ETag":           `W/"tag"`,
+			},
+		}, {
+			name:           "no_content",
+			compress:       &Compression{MinSize: 0},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}, status: http.StatusNoContent},
+			acceptEncoding: "gzip",
+			wantEncoding:   "",
+			wantStatus:     http.StatusNoContent,
+		}, {
+			name:     "no_transform",
+			compress: &Compression{MinSize: 1024},
+			function: &testFunction{
+				header: map[string]string{"Content-Type": "text/plain", "Cache-Control": "public, no-transform"},
+				chunks: []string{text},
+			},
+			acceptEncoding: "gzip",
+			wantEncoding:   "",
+			wantHeader:     map[string]string{"Vary": ""},
+		}, {
+			name:           "head",
+			compress:       &Compression{MinSize: 0},
+			function:       &testFunction{header: map[string]string{"Content-Type": "text/plain"}},
+			method:         http.MethodHead,
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantHeader:     map[string]string{"Vary": "Accept-Encoding"},
+		}, {
+			name:     "head_with_length",
+			compress: &Compression{MinSize: 1024},
+			function: &testFunction{
+				header: map[string]string{"Content-Type": "text/plain", "Content-Length": "1800"},
+			},
+			method:         http.MethodHead,
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantHeader:     map[string]string{"Content-Length": "", "Vary": "Accept-Encoding"},
+		}, {
+			name:     "head_small",
+			compress: &Compression{MinSize: 1024},
+			function: &testFunction{
+				header: map[string]string{"Content-Type": "text/plain", "Content-Length": "5"},
+			},
+			method:         http.MethodHead,
+			acceptEncoding: "gzip",
+			wantEncoding:   "",
+			wantHeader:     map[string]string{"Content-Length": "5", "Vary": "Accept-Encoding"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			ep := &Endpoint{Location: "/", Function: tt.function, Compress: tt.compress}
+			ep.handle(w, r)
+
+			wantStatus := tt.wantStatus
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if w.Code != wantStatus {
+				t.Errorf("handle() status = %v, want %v", w.Code, wantStatus)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("handle() Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			for k, v := range tt.wantHeader {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("handle() header %s = %q, want %q", k, got, v)
+				}
+			}
+			if method == http.MethodHead || w.Header().Get("Content-Encoding") != tt.wantEncoding {
+				return
+			}
+			// responses that were encoded upstream are passed through untouched
+			want := strings.Join(tt.function.chunks, "")
+			got := w.Body.String()
+			if tt.wantEncoding != "" && tt.function.header["Content-Encoding"] == "" {
+				got = decodeBody(t, tt.wantEncoding, w.Body.Bytes())
+			}
+			if got != want {
+				t.Errorf("handle() body = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// Streaming responses are compressed and flushed without waiting for the minimum size
+func TestCompression_Flush(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	ep := &Endpoint{
+		Location: "/",
+		Function: &testFunction{
+			header:  map[string]string{"Content-Type": "text/event-stream"},
+			chunks:  []string{"data: 1\n\n", "data: 2\n\n"},
+			flushes: true,
+		},
+		Compress: &Compression{MinSize: 1024},
+	}
+	ep.handle(w, r)
+
+	if !w.Flushed {
+		t.Errorf("handle() did not flush")
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("handle() Content-Encoding = %q, want gzip", got)
+	}
+	if got := decodeBody(t, "gzip", w.Body.Bytes()); got != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("handle() body = %q", got)
+	}
+}
+
+// Ranges of files are sent as is, since they refer to the uncompressed content
+func TestCompression_Range(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("0123456789", 200)
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoint{
+		Location: "/",
+		Function: &EndpointFiles{Source: dir},
+		Compress: &Compression{MinSize: 0},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=10-19")
+	w := httptest.NewRecorder()
+	ep.handle(w, r)
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("handle() status = %v, want %v", w.Code, http.StatusPartialContent)
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("handle() Content-Encoding = %q, want none", got)
+	}
+	if got := w.Body.String(); got != "0123456789" {
+		t.Errorf("handle() body = %q", got)
+	}
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Policies for forwarded headers that come with the request
+const (
+	// Incoming headers are dropped, the upstream only sees this hop
+	ForwardedReplace = "replace"
+	// Incoming headers are kept and this hop is appended to them
+	ForwardedAppend = "append"
+	// Incoming headers are kept only if the client is one of the trusted proxies
+	ForwardedTrusted = "trusted"
+)
+
+var ForwardedPolicies = []string{ForwardedReplace, ForwardedAppend, ForwardedTrusted}
+
+// Pseudonym of the proxy in Via headers
+const viaName = "switchman"
+
+// Headers that apply to a single connection and are not passed by proxies, RFC 9110 7.6.1.
+// Headers listed in Connection are removed as well
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Headers that describe the client and the hops the request went through
+var forwardedHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// Set the headers of the request sent to the upstream from the ones of the incoming request
+func (f *EndpointProxy) setRequestHeaders(out *http.Request, r *http.Request) {
+	removeHopHeaders(out.Header)
+	// the upgrade is asked of the upstream for the client, HTTP/2 has its own way to do it
+	if upgrade := upgradeType(r.Header); upgrade != "" && r.ProtoMajor == 1 {
+		out.Header.Set("Connection", "Upgrade")
+		out.Header.Set("Upgrade", upgrade)
+	}
+	// trailers can only be sent back if the upstream knows the client accepts them
+	if headerHasToken(r.Header, "Te", "trailers") {
+		out.Header.Set("Te", "trailers")
+	}
+	// keep the transport from adding its own
+	if _, ok := out.Header["User-Agent"]; !ok {
+		out.Header.Set("User-Agent", "")
+	}
+	if f.PreserveHost {
+		out.Host = r.Host
+	} else {
+		out.Host = ""
+	}
+
+	if !f.keepForwarded(r) {
+		for _, name := range forwardedHeaders {
+			out.Header.Del(name)
+		}
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	appendHeader(out.Header, "X-Forwarded-For", client)
+	// the first proxy knows the host and the scheme the client used
+	if out.Header.Get("X-Forwarded-Host") == "" {
+		out.Header.Set("X-Forwarded-Host", r.Host)
+	}
+	if out.Header.Get("X-Forwarded-Proto") == "" {
+		out.Header.Set("X-Forwarded-Proto", proto)
+	}
+	appendHeader(out.Header, "Forwarded", fmt.Sprintf("for=%s;host=%s;proto=%s",
+		forwardedNode(client), forwardedValue(r.Host), proto))
+	appendHeader(out.Header, "Via", viaEntry(r.ProtoMajor, r.ProtoMinor))
+}
+
+// Set the headers of the response sent to the client from the ones of the upstream response
+func setResponseHeaders(head http.Header, resp *http.Response) {
+	removeHopHeaders(resp.Header)
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			head.Add(k, v)
+		}
+	}
+	appendHeader(head, "Via", viaEntry(resp.ProtoMajor, resp.ProtoMinor))
+}
+
+// Whether forwarded headers of the request are passed to the upstream
+func (f *EndpointProxy) keepForwarded(r *http.Request) bool {
+	switch f.Forwarded {
+	case ForwardedAppend:
+		return true
+	case ForwardedTrusted:
+		return fromTrustedProxy(r, f.TrustedProxies)
+	}
+	return false
+}
+
+// Whether the request was sent from one of the trusted proxies
+func fromTrustedProxy(r *http.Request, proxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, p := range proxies {
+		if p.Contains(addr.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Append the value to a comma separated header, earlier lines are joined into one
+func appendHeader(h http.Header, name string, value string) {
+	if prior := h.Values(name); len(prior) > 0 {
+		value = strings.Join(prior, ", ") + ", " + value
+	}
+	h.Set(name, value)
+}
+
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			// parameters like 'trailers;q=1' are not used
+			t, _, _ = strings.Cut(t, ";")
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func viaEntry(major, minor int) string {
+	if major == 0 && minor == 0 {
+		major, minor = 1, 1
+	}
+	if minor == 0 && major > 1 {
+		// HTTP/2 and HTTP/3 have no minor version
+		return fmt.Sprintf("%d %s", major, viaName)
+	}
+	return fmt.Sprintf("%d.%d %s", major, minor, viaName)
+}
+
+// Client address for the Forwarded header, IPv6 addresses are quoted in brackets
+func forwardedNode(addr string) string {
+	if strings.Contains(addr, ":") {
+		return `"[` + addr + `]"`
+	}
+	return forwardedValue(addr)
+}
+
+// Quote the value if it is not a token, like hosts with a port
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c rune) bool {
+	return c < 0x7f && (c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+}
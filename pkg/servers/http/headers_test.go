@@ -0,0 +1,182 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestEndpointProxy_RequestHeaders(t *testing.T) {
+	// upstream that sends back the host and the headers it got
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		head := r.Header.Clone()
+		head.Set("Host", r.Host)
+		json.NewEncoder(w).Encode(head)
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	tests := []struct {
+		name       string
+		proxy      *EndpointProxy
+		remoteAddr string
+		header     http.Header
+		want       http.Header
+	}{
+		{
+			name:       "replace",
+			proxy:      &EndpointProxy{},
+			remoteAddr: "192.0.2.1:1234",
+			header: http.Header{
+				"Connection":      {"keep-alive, X-Secret"},
+				"Keep-Alive":      {"timeout=5"},
+				"X-Secret":        {"token"},
+				"Te":              {"trailers, deflate"},
+				"Upgrade":         {"h2c"},
+				"X-Custom":        {"a"},
+				"User-Agent":      {"test"},
+				"X-Forwarded-For": {"203.0.113.5"},
+				"Forwarded":       {"for=203.0.113.5"},
+			},
+			want: http.Header{
+				"Host":              {upstreamURL.Host},
+				"Te":                {"trailers"},
+				"X-Custom":          {"a"},
+				"User-Agent":        {"test"},
+				"X-Forwarded-For":   {"192.0.2.1"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Proto": {"http"},
+				"Forwarded":         {"for=192.0.2.1;host=example.com;proto=http"},
+				"Via":               {"1.1 switchman"},
+			},
+		}, {
+			name:       "append",
+			proxy:      &EndpointProxy{Forwarded: ForwardedAppend},
+			remoteAddr: "192.0.2.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.5", "198.51.100.7"},
+				"X-Forwarded-Host":  {"example.org"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=203.0.113.5;proto=https"},
+				"Via":               {"1.1 cdn"},
+			},
+			want: http.Header{
+				"Host":              {upstreamURL.Host},
+				"X-Forwarded-For":   {"203.0.113.5, 198.51.100.7, 192.0.2.1"},
+				"X-Forwarded-Host":  {"example.org"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=203.0.113.5;proto=https, for=192.0.2.1;host=example.com;proto=http"},
+				"Via":               {"1.1 cdn, 1.1 switchman"},
+			},
+		}, {
+			name: "trusted",
+			proxy: &EndpointProxy{
+				Forwarded:      ForwardedTrusted,
+				TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			},
+			remoteAddr: "10.1.2.3:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.5"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			want: http.Header{
+				"Host":              {upstreamURL.Host},
+				"X-Forwarded-For":   {"203.0.113.5, 10.1.2.3"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=10.1.2.3;host=example.com;proto=http"},
+				"Via":               {"1.1 switchman"},
+			},
+		}, {
+			name: "untrusted",
+			proxy: &EndpointProxy{
+				Forwarded:      ForwardedTrusted,
+				TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			},
+			remoteAddr: "192.0.2.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.5"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			want: http.Header{
+				"Host":              {upstreamURL.Host},
+				"X-Forwarded-For":   {"192.0.2.1"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Proto": {"http"},
+				"Forwarded":         {"for=192.0.2.1;host=example.com;proto=http"},
+				"Via":               {"1.1 switchman"},
+			},
+		}, {
+			name:       "preserve_host_ipv6",
+			proxy:      &EndpointProxy{PreserveHost: true},
+			remoteAddr: "[2001:db8::1]:1234",
+			want: http.Header{
+				"Host":              {"example.com"},
+				"X-Forwarded-For":   {"2001:db8::1"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Proto": {"http"},
+				"Forwarded":         {`for="[2001:db8::1]";host=example.com;proto=http`},
+				"Via":               {"1.1 switchman"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := tt.proxy
+			proxy.Proto, proxy.Host, proxy.Path = "http", upstreamURL.Host, "/"
+			defer proxy.Close()
+
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, vs := range tt.header {
+				r.Header[k] = vs
+			}
+			w := httptest.NewRecorder()
+			proxy.Serve(w, r, "")
+
+			var got http.Header
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("Serve() body error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("upstream headers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointProxy_ResponseHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		head := w.Header()
+		head.Set("Connection", "X-Hop")
+		head.Set("X-Hop", "1")
+		head.Set("Keep-Alive", "timeout=5")
+		head.Set("Proxy-Authenticate", "Basic")
+		head.Set("X-Kept", "1")
+		head.Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+	proxy := &EndpointProxy{Proto: "http", Host: upstreamURL.Host, Path: "/"}
+	defer proxy.Close()
+
+	w := httptest.NewRecorder()
+	proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "")
+
+	head := w.Header()
+	delete(head, "Date")
+	want := http.Header{
+		"Content-Length": {"2"},
+		"Content-Type":   {"text/plain"},
+		"X-Kept":         {"1"},
+		"Via":            {"1.1 switchman"},
+	}
+	if !reflect.DeepEqual(head, want) {
+		t.Errorf("response headers = %v, want %v", head, want)
+	}
+}
@@ -0,0 +1,264 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Defaults of health checks of upstream pools
+const (
+	DefaultHealthInterval           = 10 * time.Second
+	DefaultHealthTimeout            = 5 * time.Second
+	DefaultHealthyThreshold         = 2
+	DefaultUnhealthyThreshold       = 3
+	DefaultMaxFails                 = 3
+	DefaultFailTimeout              = 30 * time.Second
+	maxHealthBodySize         int64 = 64 << 10
+)
+
+// HealthCheck is an active check of the servers of a pool: a request is sent to every server on
+// an interval and servers that fail it are not sent traffic until they pass it again
+type HealthCheck struct {
+	Path     string        `json:"path"`
+	Interval time.Duration `json:"interval,omitempty"` // DefaultHealthInterval if zero
+	Timeout  time.Duration `json:"timeout,omitempty"`  // DefaultHealthTimeout if zero
+	// Expected status, any 2xx status if zero
+	Status int `json:"status,omitempty"`
+	// Checks in a row that a server must pass to be up again, DefaultHealthyThreshold if zero
+	HealthyThreshold int `json:"healthy_threshold,omitempty"`
+	// Checks in a row that a server must fail to be down, DefaultUnhealthyThreshold if zero
+	UnhealthyThreshold int `json:"unhealthy_threshold,omitempty"`
+}
+
+// Health of a server of a pool, guarded by its mutex
+type upstreamHealth struct {
+	mu sync.Mutex
+	// failed the active check
+	down bool
+	// checks in a row with the same result, counted until the threshold
+	passed, failed int
+	// consecutive failed requests
+	fails int
+	// the server was ejected after failed requests and gets traffic again after the time
+	ejected      bool
+	ejectedUntil time.Time
+}
+
+// UpstreamStatus is the health of a server of a pool as shown by the API
+type UpstreamStatus struct {
+	Pool      string `json:"pool"`
+	Host      string `json:"host"`
+	Available bool   `json:"available"`
+	// Result of the active check, true if the pool has none
+	Healthy bool `json:"healthy"`
+	// Requests in progress
+	Active int64 `json:"active"`
+	// Consecutive failed requests
+	Fails        int        `json:"fails"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+}
+
+// Whether the server can be sent requests
+func (u *Upstream) available(now time.Time) bool {
+	u.health.mu.Lock()
+	defer u.health.mu.Unlock()
+	return !u.health.down && !now.Before(u.health.ejectedUntil)
+}
+
+func (u *Upstream) status(pool string) UpstreamStatus {
+	u.health.mu.Lock()
+	defer u.health.mu.Unlock()
+	ejected := time.Now().Before(u.health.ejectedUntil)
+	s := UpstreamStatus{
+		Pool:      pool,
+		Host:      u.Host,
+		Available: !u.health.down && !ejected,
+		Healthy:   !u.health.down,
+		Active:    u.active.Load(),
+		Fails:     u.health.fails,
+	}
+	if ejected {
+		until := u.health.ejectedUntil
+		s.EjectedUntil = &until
+	}
+	return s
+}
+
+// Status of the servers of the pool
+func (p *UpstreamPool) Status() []UpstreamStatus {
+	var status []UpstreamStatus
+	for _, u := range p.Servers {
+		status = append(status, u.status(p.Name))
+	}
+	return status
+}
+
+// Record the result of a request to the server. Servers are ejected from the pool after
+// MaxFails failures in a row and get traffic again after FailTimeout
+func (p *UpstreamPool) report(u *Upstream, err error) {
+	maxFails := p.MaxFails
+	if maxFails == 0 {
+		maxFails = DefaultMaxFails
+	}
+	if maxFails < 0 {
+		return
+	}
+	u.health.mu.Lock()
+	defer u.health.mu.Unlock()
+	if err == nil {
+		if u.health.ejected {
+			u.health.ejected = false
+			log.Printf("Upstream %s of pool '%s' is back after ejection", u.Host, p.Name)
+		}
+		u.health.fails = 0
+		return
+	}
+	u.health.fails++
+	if u.health.fails < maxFails || time.Now().Before(u.health.ejectedUntil) {
+		return
+	}
+	failTimeout := p.FailTimeout
+	if failTimeout == 0 {
+		failTimeout = DefaultFailTimeout
+	}
+	u.health.ejected = true
+	u.health.ejectedUntil = time.Now().Add(failTimeout)
+	log.Printf("Upstream %s of pool '%s' is ejected for %v after %d failed requests: %v", u.Host, p.Name, failTimeout, u.health.fails, err)
+}
+
+// Error of a request to a server for passive checks, responses that say the server can't
+// handle requests count as failures
+func upstreamError(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("server responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// Record the result of an active check of the server
+func (p *UpstreamPool) checked(u *Upstream, err error) {
+	check := p.HealthCheck
+	u.health.mu.Lock()
+	defer u.health.mu.Unlock()
+	if err == nil {
+		u.health.failed = 0
+		u.health.passed++
+		threshold := check.HealthyThreshold
+		if threshold == 0 {
+			threshold = DefaultHealthyThreshold
+		}
+		if u.health.down && u.health.passed >= threshold {
+			u.health.down = false
+			// a server that passes the check is tried again right away
+			u.health.fails = 0
+			u.health.ejectedUntil = time.Time{}
+			log.Printf("Upstream %s of pool '%s' is up", u.Host, p.Name)
+		}
+		return
+	}
+	u.health.passed = 0
+	u.health.failed++
+	threshold := check.UnhealthyThreshold
+	if threshold == 0 {
+		threshold = DefaultUnhealthyThreshold
+	}
+	if !u.health.down && u.health.failed >= threshold {
+		u.health.down = true
+		log.Printf("Upstream %s of pool '%s' is down: %v", u.Host, p.Name, err)
+	}
+}
+
+// Start checking the servers of the pool if it has a health check, until stopHealthChecks is
+// called. Pools shared by several proxies are started once
+func (p *UpstreamPool) startHealthChecks() {
+	if p.HealthCheck == nil {
+		return
+	}
+	p.checkMu.Lock()
+	defer p.checkMu.Unlock()
+	if p.stopChecks != nil {
+		return
+	}
+	interval := p.HealthCheck.Interval
+	if interval == 0 {
+		interval = DefaultHealthInterval
+	}
+	timeout := p.HealthCheck.Timeout
+	if timeout == 0 {
+		timeout = DefaultHealthTimeout
+	}
+	// checks open a connection each time, they are far apart. Redirects are not followed, they
+	// are an answer of the server
+	conns, err := newProxyPool(ProxyTransport{Keepalive: -1, DialTimeout: timeout, TLS: p.TLS})
+	if err != nil {
+		log.Printf("UpstreamPool %s: health checks are off, error loading TLS settings: %v", p.Name, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopChecks = cancel
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			var wg sync.WaitGroup
+			for _, u := range p.Servers {
+				wg.Add(1)
+				go func(u *Upstream) {
+					defer wg.Done()
+					err := p.probe(ctx, conns, u, timeout)
+					if ctx.Err() == nil {
+						p.checked(u, err)
+					}
+				}(u)
+			}
+			wg.Wait()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *UpstreamPool) stopHealthChecks() {
+	p.checkMu.Lock()
+	defer p.checkMu.Unlock()
+	if p.stopChecks != nil {
+		p.stopChecks()
+	}
+}
+
+// Send the check request to the server, nil if it passed
+func (p *UpstreamPool) probe(ctx context.Context, conns *proxyPool, u *Upstream, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	scheme, host, ctx := upstreamURL(ctx, u.Proto, u.Host)
+	target := &url.URL{Scheme: scheme, Host: host, Path: p.HealthCheck.Path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", viaName+" health check")
+	resp, err := conns.roundTrip(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxHealthBodySize))
+	resp.Body.Close()
+	if want := p.HealthCheck.Status; want != 0 && resp.StatusCode != want ||
+		want == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("health check responded with %s", resp.Status)
+	}
+	return nil
+}
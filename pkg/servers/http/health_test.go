@@ -0,0 +1,151 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func upstreamFor(server *httptest.Server) *Upstream {
+	u, _ := url.Parse(server.URL)
+	return &Upstream{Proto: "http", Host: u.Host}
+}
+
+// Wait until the server has the availability or the time runs out
+func waitAvailable(t *testing.T, u *Upstream, want bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for u.available(time.Now()) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("available() = %v for %v, want %v", !want, u.Host, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUpstreamPool_passive(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("good"))
+	}))
+	defer good.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	tests := []struct {
+		name       string
+		maxFails   int
+		wantFailed int
+	}{
+		{name: "ejected", maxFails: 2, wantFailed: 2},
+		{name: "off", maxFails: -1, wantFailed: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &UpstreamPool{
+				Name:        "test",
+				Servers:     []*Upstream{upstreamFor(failing), upstreamFor(good)},
+				MaxFails:    tt.maxFails,
+				FailTimeout: time.Minute,
+			}
+			proxy := &EndpointProxy{Path: "/", Upstream: pool}
+			defer proxy.Close()
+
+			failed := 0
+			for i := 0; i < 10; i++ {
+				w := httptest.NewRecorder()
+				proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "")
+				if w.Code != http.StatusOK {
+					failed++
+				}
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("failed requests = %v, want %v", failed, tt.wantFailed)
+			}
+			status := pool.Status()
+			if ejected := status[0].EjectedUntil != nil; ejected != (tt.maxFails > 0) || status[0].Available == ejected {
+				t.Errorf("Status() = %+v", status[0])
+			}
+			if !status[1].Available || status[1].Fails != 0 {
+				t.Errorf("Status() = %+v", status[1])
+			}
+		})
+	}
+}
+
+// An ejected server is tried again after the timeout and one success brings it back
+func TestUpstreamPool_report(t *testing.T) {
+	u := &Upstream{Host: "10.0.0.1:80"}
+	pool := &UpstreamPool{Name: "test", Servers: []*Upstream{u}, MaxFails: 1, FailTimeout: 20 * time.Millisecond}
+
+	pool.report(u, http.ErrHandlerTimeout)
+	if u.available(time.Now()) {
+		t.Fatal("available() = true after max_fails failures")
+	}
+	if pool.pick(httptest.NewRequest(http.MethodGet, "/", nil)) != nil {
+		t.Fatal("pick() returned an ejected server")
+	}
+	waitAvailable(t, u, true)
+	pool.report(u, nil)
+	if s := u.status(pool.Name); !s.Available || s.Fails != 0 {
+		t.Errorf("status() = %+v after a success", s)
+	}
+}
+
+func TestUpstreamPool_active(t *testing.T) {
+	var failing atomic.Bool
+	var checks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		checks.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	u := upstreamFor(server)
+	pool := &UpstreamPool{
+		Name:    "test",
+		Servers: []*Upstream{u},
+		HealthCheck: &HealthCheck{
+			Path:               "/health",
+			Interval:           10 * time.Millisecond,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 2,
+		},
+	}
+	s := &Server{Endpoints: []Endpoint{
+		{Location: "/a", Function: &EndpointProxy{Upstream: pool}},
+		{Location: "/b", Function: &EndpointProxy{Upstream: pool}},
+	}}
+	s.Start()
+
+	failing.Store(true)
+	waitAvailable(t, u, false)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ServeHTTP() status = %v with all servers down, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	if status := s.UpstreamStatus(); len(status) != 1 || status[0].Healthy {
+		t.Errorf("UpstreamStatus() = %+v", status)
+	}
+
+	failing.Store(false)
+	waitAvailable(t, u, true)
+
+	s.Close()
+	time.Sleep(30 * time.Millisecond)
+	stopped := checks.Load()
+	time.Sleep(50 * time.Millisecond)
+	if checks.Load() != stopped {
+		t.Error("health checks continue after Close()")
+	}
+}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// MarshalJSON describes the endpoint with the way it matches requests and the type of its
+// function, for example:
+//
+//	{"location": "/", "match": "prefix", "type": "files", "function": {"source": "/var/www"}}
+func (ep Endpoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location string           `json:"location"`
+		Match    string           `json:"match"`
+		Type     string           `json:"type"`
+		Function EndpointFunction `json:"function"`
+		Compress *Compression     `json:"compress,omitempty"`
+		Errors   ErrorPages       `json:"errors,omitempty"`
+	}{
+		Location: ep.Location,
+		Match:    "prefix",
+		Type:     FunctionType(ep.Function),
+		Function: ep.Function,
+		Compress: ep.Compress,
+		Errors:   ep.Errors,
+	})
+}
+
+// FunctionType is the name of an endpoint function type as used in configs, e.g. 'files'
+// for EndpointFiles
+func FunctionType(f EndpointFunction) string {
+	t := reflect.TypeOf(f)
+	if t == nil {
+		return ""
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return strings.ToLower(strings.TrimPrefix(t.Name(), "Endpoint"))
+}
+
+// Configs are marshaled with the defaults that zero values stand for, so that dumps show what
+// the server does. Each type is marshaled through a plain version of itself without methods,
+// the resolved fields shadow the ones of the plain version. Pointers are used so that the
+// state of the types isn't copied
+
+// Duration written like in configs, e.g. "1m30s" instead of nanoseconds
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (f *EndpointFiles) MarshalJSON() ([]byte, error) {
+	type plain EndpointFiles
+	index := f.Index
+	if index == nil {
+		index = defaultIndex
+	}
+	return json.Marshal(struct {
+		*plain
+		Index          []string `json:"index"`
+		FollowSymlinks string   `json:"follow_symlinks"`
+		Hidden         string   `json:"hidden"`
+	}{
+		plain:          (*plain)(f),
+		Index:          index,
+		FollowSymlinks: orDefault(f.FollowSymlinks, SymlinksAlways),
+		Hidden:         orDefault(f.Hidden, HiddenUnlisted),
+	})
+}
+
+func (f *EndpointRedirect) MarshalJSON() ([]byte, error) {
+	type plain EndpointRedirect
+	return json.Marshal(struct {
+		*plain
+		Status int `json:"status"`
+	}{(*plain)(f), orDefault(f.Status, http.StatusMovedPermanently)})
+}
+
+func (h *HTTPSRedirect) MarshalJSON() ([]byte, error) {
+	type plain HTTPSRedirect
+	return json.Marshal(struct {
+		*plain
+		Status int `json:"status"`
+		Port   int `json:"port"`
+	}{(*plain)(h), orDefault(h.Status, http.StatusPermanentRedirect), orDefault(h.Port, 443)})
+}
+
+func (f *EndpointRespond) MarshalJSON() ([]byte, error) {
+	type plain EndpointRespond
+	headers := make(map[string]string, len(f.Headers)+1)
+	for k, v := range f.Headers {
+		headers[k] = v
+	}
+	if f.contentType() == defaultRespondType {
+		headers["Content-Type"] = defaultRespondType
+	}
+	return json.Marshal(struct {
+		*plain
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+	}{(*plain)(f), orDefault(f.Status, http.StatusOK), headers})
+}
+
+func (c *Compression) MarshalJSON() ([]byte, error) {
+	type plain Compression
+	encodings, types := c.Encodings, c.Types
+	if encodings == nil {
+		encodings = DefaultCompressEncodings
+	}
+	if types == nil {
+		types = DefaultCompressTypes
+	}
+	return json.Marshal(struct {
+		*plain
+		Encodings []string `json:"encodings"`
+		Types     []string `json:"types"`
+	}{(*plain)(c), encodings, types})
+}
+
+func (f *EndpointProxy) MarshalJSON() ([]byte, error) {
+	type plain EndpointProxy
+	return json.Marshal(struct {
+		*plain
+		Forwarded             string       `json:"forwarded"`
+		Keepalive             int          `json:"keepalive"`
+		IdleTimeout           jsonDuration `json:"idle_timeout"`
+		DialTimeout           jsonDuration `json:"dial_timeout"`
+		ResponseHeaderTimeout jsonDuration `json:"response_header_timeout,omitempty"`
+		Timeout               jsonDuration `json:"timeout,omitempty"`
+		FlushInterval         jsonDuration `json:"flush_interval,omitempty"`
+		UpgradeIdleTimeout    jsonDuration `json:"upgrade_idle_timeout"`
+	}{
+		plain:                 (*plain)(f),
+		Forwarded:             orDefault(f.Forwarded, ForwardedReplace),
+		Keepalive:             orDefault(f.Keepalive, DefaultProxyKeepalive),
+		IdleTimeout:           jsonDuration(orDefault(f.IdleTimeout, DefaultProxyIdleTimeout)),
+		DialTimeout:           jsonDuration(orDefault(f.DialTimeout, DefaultProxyDialTimeout)),
+		ResponseHeaderTimeout: jsonDuration(f.ResponseHeaderTimeout),
+		Timeout:               jsonDuration(f.Timeout),
+		FlushInterval:         jsonDuration(f.FlushInterval),
+		UpgradeIdleTimeout:    jsonDuration(orDefault(f.UpgradeIdleTimeout, DefaultUpgradeIdleTimeout)),
+	})
+}
+
+func (p *RetryPolicy) MarshalJSON() ([]byte, error) {
+	type plain RetryPolicy
+	statuses := p.Statuses
+	if statuses == nil {
+		statuses = DefaultRetryStatuses
+	}
+	return json.Marshal(struct {
+		*plain
+		Attempts   int          `json:"attempts"`
+		Statuses   []int        `json:"statuses"`
+		Backoff    jsonDuration `json:"backoff"`
+		MaxBackoff jsonDuration `json:"max_backoff"`
+	}{
+		plain:      (*plain)(p),
+		Attempts:   orDefault(p.Attempts, DefaultRetryAttempts),
+		Statuses:   statuses,
+		Backoff:    jsonDuration(orDefault(p.Backoff, DefaultRetryBackoff)),
+		MaxBackoff: jsonDuration(orDefault(p.MaxBackoff, DefaultRetryMaxBackoff)),
+	})
+}
+
+func (b *CircuitBreaker) MarshalJSON() ([]byte, error) {
+	type plain CircuitBreaker
+	return json.Marshal(struct {
+		*plain
+		Failures    int          `json:"failures"`
+		OpenTimeout jsonDuration `json:"open_timeout"`
+	}{(*plain)(b), orDefault(b.Failures, DefaultBreakerFailures), jsonDuration(orDefault(b.OpenTimeout, DefaultBreakerOpenTimeout))})
+}
+
+func (p *UpstreamPool) MarshalJSON() ([]byte, error) {
+	type plain UpstreamPool
+	return json.Marshal(struct {
+		*plain
+		Balance     string       `json:"balance"`
+		MaxFails    int          `json:"max_fails"`
+		FailTimeout jsonDuration `json:"fail_timeout"`
+	}{
+		plain:       (*plain)(p),
+		Balance:     orDefault(p.Balance, BalanceRoundRobin),
+		MaxFails:    orDefault(p.MaxFails, DefaultMaxFails),
+		FailTimeout: jsonDuration(orDefault(p.FailTimeout, DefaultFailTimeout)),
+	})
+}
+
+func (u *Upstream) MarshalJSON() ([]byte, error) {
+	type plain Upstream
+	return json.Marshal(struct {
+		*plain
+		Weight int `json:"weight"`
+	}{(*plain)(u), orDefault(u.Weight, 1)})
+}
+
+func (c *HealthCheck) MarshalJSON() ([]byte, error) {
+	type plain HealthCheck
+	return json.Marshal(struct {
+		*plain
+		Interval           jsonDuration `json:"interval"`
+		Timeout            jsonDuration `json:"timeout"`
+		HealthyThreshold   int          `json:"healthy_threshold"`
+		UnhealthyThreshold int          `json:"unhealthy_threshold"`
+	}{
+		plain:              (*plain)(c),
+		Interval:           jsonDuration(orDefault(c.Interval, DefaultHealthInterval)),
+		Timeout:            jsonDuration(orDefault(c.Timeout, DefaultHealthTimeout)),
+		HealthyThreshold:   orDefault(c.HealthyThreshold, DefaultHealthyThreshold),
+		UnhealthyThreshold: orDefault(c.UnhealthyThreshold, DefaultUnhealthyThreshold),
+	})
+}
+
+func (c *EndpointCache) MarshalJSON() ([]byte, error) {
+	type plain EndpointCache
+	key := c.Key
+	if key == nil {
+		key = DefaultCacheKey
+	}
+	var diskMaxSize int64
+	if c.Dir != "" {
+		diskMaxSize = orDefault(c.DiskMaxSize, DefaultCacheDiskMaxSize)
+	}
+	return json.Marshal(struct {
+		*plain
+		Key                  []string     `json:"key"`
+		MaxSize              int64        `json:"max_size"`
+		MaxEntrySize         int64        `json:"max_entry_size"`
+		DiskMaxSize          int64        `json:"disk_max_size,omitempty"`
+		DefaultTTL           jsonDuration `json:"default_ttl,omitempty"`
+		StaleWhileRevalidate jsonDuration `json:"stale_while_revalidate,omitempty"`
+	}{
+		plain:                (*plain)(c),
+		Key:                  key,
+		MaxSize:              orDefault(c.MaxSize, DefaultCacheMaxSize),
+		MaxEntrySize:         c.maxEntrySize(),
+		DiskMaxSize:          diskMaxSize,
+		DefaultTTL:           jsonDuration(c.DefaultTTL),
+		StaleWhileRevalidate: jsonDuration(c.StaleWhileRevalidate),
+	})
+}
@@ -0,0 +1,157 @@
+package http
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServer_MarshalJSON(t *testing.T) {
+	srv := &Server{
+		Endpoints: []Endpoint{
+			{Location: "/static", Function: &EndpointFiles{Source: "/var/www"}},
+			{Location: "/old", Function: &EndpointRedirect{URL: "/new"}},
+			{Location: "/api", Function: &EndpointProxy{Proto: "http", Host: "localhost:8000", Path: "/"}},
+		},
+	}
+	data, err := json.Marshal(srv)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var got struct {
+		Endpoints []struct {
+			Location string         `json:"location"`
+			Match    string         `json:"match"`
+			Type     string         `json:"type"`
+			Function map[string]any `json:"function"`
+		} `json:"endpoints"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		location string
+		typ      string
+		field    string
+		value    any
+	}{
+		{"/static", "files", "source", "/var/www"},
+		{"/old", "redirect", "url", "/new"},
+		{"/api", "proxy", "host", "localhost:8000"},
+	}
+	if len(got.Endpoints) != len(tests) {
+		t.Fatalf("json.Marshal() = %s, want %d endpoints", data, len(tests))
+	}
+	for i, tt := range tests {
+		ep := got.Endpoints[i]
+		if ep.Location != tt.location || ep.Match != "prefix" || ep.Type != tt.typ {
+			t.Errorf("endpoint %d = %v %v %v, want %v prefix %v", i, ep.Location, ep.Match, ep.Type, tt.location, tt.typ)
+		}
+		if !reflect.DeepEqual(ep.Function[tt.field], tt.value) {
+			t.Errorf("endpoint %d %s = %v, want %v", i, tt.field, ep.Function[tt.field], tt.value)
+		}
+	}
+}
+
+func TestMarshalJSON_defaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  map[string]any
+	}{
+		{
+			name:  "files",
+			value: &EndpointFiles{Source: "/var/www"},
+			want:  map[string]any{"source": "/var/www", "index": []any{"index.html"}, "follow_symlinks": "always", "hidden": "unlisted"},
+		}, {
+			name:  "files_set",
+			value: &EndpointFiles{Index: []string{}, FollowSymlinks: SymlinksNever, Hidden: HiddenAllow},
+			want:  map[string]any{"index": []any{}, "follow_symlinks": "never", "hidden": "allow"},
+		}, {
+			name:  "redirect",
+			value: &EndpointRedirect{URL: "/new"},
+			want:  map[string]any{"url": "/new", "status": 301.0},
+		}, {
+			name:  "https_redirect",
+			value: &HTTPSRedirect{},
+			want:  map[string]any{"status": 308.0, "port": 443.0},
+		}, {
+			name:  "respond",
+			value: &EndpointRespond{Body: "ok"},
+			want:  map[string]any{"body": "ok", "status": 200.0, "headers": map[string]any{"Content-Type": "text/plain; charset=utf-8"}},
+		}, {
+			name:  "respond_set",
+			value: &EndpointRespond{Status: 503, Headers: map[string]string{"content-type": "application/json"}},
+			want:  map[string]any{"status": 503.0, "headers": map[string]any{"content-type": "application/json"}},
+		}, {
+			name:  "compression",
+			value: &Compression{},
+			want:  map[string]any{"encodings": []any{"br", "gzip", "deflate"}, "min_size": 0.0},
+		}, {
+			name: "proxy",
+			value: &EndpointProxy{Proto: "http", Host: "localhost:8000", ProxyTransport: ProxyTransport{Keepalive: -1},
+				Timeout: 90 * time.Second, Retry: &RetryPolicy{Attempts: 5}, CircuitBreaker: &CircuitBreaker{}},
+			want: map[string]any{
+				"host":                 "localhost:8000",
+				"forwarded":            "replace",
+				"keepalive":            -1.0,
+				"idle_timeout":         DefaultProxyIdleTimeout.String(),
+				"dial_timeout":         DefaultProxyDialTimeout.String(),
+				"upgrade_idle_timeout": DefaultUpgradeIdleTimeout.String(),
+				"timeout":              "1m30s",
+				"retry": map[string]any{
+					"attempts":    5.0,
+					"statuses":    []any{502.0, 503.0, 504.0},
+					"backoff":     DefaultRetryBackoff.String(),
+					"max_backoff": DefaultRetryMaxBackoff.String(),
+				},
+				"circuit_breaker": map[string]any{"failures": 5.0, "open_timeout": DefaultBreakerOpenTimeout.String()},
+			},
+		}, {
+			name: "upstream_pool",
+			value: &UpstreamPool{Name: "api", Servers: []*Upstream{{Proto: "http", Host: "a:80"}},
+				HealthCheck: &HealthCheck{Path: "/health", Interval: time.Second}},
+			want: map[string]any{
+				"name":         "api",
+				"servers":      []any{map[string]any{"proto": "http", "host": "a:80", "weight": 1.0}},
+				"balance":      "round_robin",
+				"max_fails":    3.0,
+				"fail_timeout": DefaultFailTimeout.String(),
+				"health_check": map[string]any{
+					"path":                "/health",
+					"interval":            "1s",
+					"timeout":             DefaultHealthTimeout.String(),
+					"healthy_threshold":   2.0,
+					"unhealthy_threshold": 3.0,
+				},
+			},
+		}, {
+			name:  "cache",
+			value: &EndpointCache{Proxy: &EndpointProxy{}, MaxSize: 1 << 10},
+			want: map[string]any{
+				"key":            []any{"host", "path", "query"},
+				"max_size":       1024.0,
+				"max_entry_size": float64(DefaultCacheMaxEntrySize),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			for field, want := range tt.want {
+				if !reflect.DeepEqual(got[field], want) {
+					t.Errorf("%s = %v, want %v", field, got[field], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrecompressedExtensions maps content encodings to extensions of precompressed files
+var PrecompressedExtensions = map[string]string{
+	"br":   ".br",
+	"zstd": ".zst",
+	"gzip": ".gz",
+}
+
+// Open the precompressed sibling of a file in the encoding preferred by the client. Encodings
+// the client values equally are chosen in the order of the list
+func (f *EndpointFiles) openPrecompressed(r *http.Request, name string) (encoding string, file *os.File, info fs.FileInfo, ok bool) {
+	accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	candidates := []string{}
+	for _, e := range f.Precompressed {
+		if accepted(e) > 0 {
+			candidates = append(candidates, e)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return accepted(candidates[i]) > accepted(candidates[j])
+	})
+	for _, e := range candidates {
+		ext, known := PrecompressedExtensions[e]
+		if !known {
+			continue
+		}
+		if file, info, err := f.open(name + ext); err == nil {
+			return e, file, info, true
+		}
+	}
+	return "", nil, nil, false
+}
+
+// Parse the Accept-Encoding header into a function returning the quality of an encoding,
+// 0 if it is not accepted
+func acceptedEncodings(header string) func(encoding string) float64 {
+	values := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		values[name] = q
+	}
+	return func(encoding string) float64 {
+		if q, ok := values[encoding]; ok {
+			return q
+		}
+		// x-gzip is an alias from older clients
+		if encoding == "gzip" {
+			if q, ok := values["x-gzip"]; ok {
+				return q
+			}
+		}
+		return values["*"]
+	}
+}
+
+// Detect the type of a file by its first bytes, leaving the file at the start
+func sniffContentType(file *os.File) string {
+	var buf [512]byte
+	n, _ := io.ReadFull(file, buf[:])
+	file.Seek(0, io.SeekStart)
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,216 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEndpointFiles_Precompressed(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"app.js":        "plain js",
+		"app.js.br":     "brotli js",
+		"app.js.gz":     "gzip js",
+		"style.css":     "plain css",
+		"style.css.zst": "zstd css",
+		"data":          "<html>no extension</html>",
+		"data.gz":       "gzip data",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all := []string{"br", "zstd", "gzip"}
+
+	tests := []struct {
+		name         string
+		encodings    []string
+		path         string
+		header       map[string]string
+		wantBody     string
+		wantEncoding string
+		wantType     string
+		wantVary     bool
+		wantStatus   int
+		wantETagFrom string // response to the same path with this Accept-Encoding must have another ETag
+	}{
+		{
+			name:         "brotli",
+			encodings:    all,
+			path:         "app.js",
+			header:       map[string]string{"Accept-Encoding": "gzip, deflate, br"},
+			wantBody:     "brotli js",
+			wantEncoding: "br",
+			wantType:     "text/javascript; charset=utf-8",
+			wantVary:     true,
+		}, {
+			name:         "gzip_preferred_by_client",
+			encodings:    all,
+			path:         "app.js",
+			header:       map[string]string{"Accept-Encoding": "br;q=0.5, gzip"},
+			wantBody:     "gzip js",
+			wantEncoding: "gzip",
+			wantVary:     true,
+			wantETagFrom: "br",
+		}, {
+			name:         "server_order",
+			encodings:    []string{"gzip", "br"},
+			path:         "app.js",
+			header:       map[string]string{"Accept-Encoding": "br, gzip"},
+			wantBody:     "gzip js",
+			wantEncoding: "gzip",
+			wantVary:     true,
+		}, {
+			name:         "not_accepted",
+			encodings:    all,
+			path:         "app.js",
+			header:       map[string]string{"Accept-Encoding": "br;q=0, identity"},
+			wantBody:     "plain js",
+			wantVary:     true,
+			wantETagFrom: "br",
+		}, {
+			name:         "wildcard",
+			encodings:    all,
+			path:         "style.css",
+			header:       map[string]string{"Accept-Encoding": "*"},
+			wantBody:     "zstd css",
+			wantEncoding: "zstd",
+			wantType:     "text/css; charset=utf-8",
+			wantVary:     true,
+		}, {
+			name:      "missing_sibling",
+			encodings: []string{"br"},
+			path:      "style.css",
+			header:    map[string]string{"Accept-Encoding": "br"},
+			wantBody:  "plain css",
+			wantVary:  true,
+		}, {
+			name:         "type_from_original",
+			encodings:    all,
+			path:         "data",
+			header:       map[string]string{"Accept-Encoding": "gzip"},
+			wantBody:     "gzip data",
+			wantEncoding: "gzip",
+			wantType:     "text/html; charset=utf-8",
+			wantVary:     true,
+		}, {
+			name:     "disabled",
+			path:     "app.js",
+			header:   map[string]string{"Accept-Encoding": "br"},
+			wantBody: "plain js",
+		}, {
+			name:       "range",
+			encodings:  all,
+			path:       "app.js",
+			header:     map[string]string{"Accept-Encoding": "br", "Range": "bytes=0-5"},
+			wantBody:   "brotli",
+			wantStatus: http.StatusPartialContent,
+			wantVary:   true,
+			// the range applies to the compressed representation
+			wantEncoding: "br",
+		},
+	}
+
+	serve := func(ep *EndpointFiles, path string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/"+path, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		ep.Serve(w, r, path)
+		return w
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep := &EndpointFiles{Source: dir, Precompressed: tt.encodings}
+			w := serve(ep, tt.path, tt.header)
+
+			wantStatus := tt.wantStatus
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if w.Code != wantStatus {
+				t.Errorf("Serve() status = %v, want %v", w.Code, wantStatus)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("Serve() body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Serve() Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := w.Header().Get("Content-Type"); tt.wantType != "" && got != tt.wantType {
+				t.Errorf("Serve() Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := w.Header().Get("Vary") == "Accept-Encoding"; got != tt.wantVary {
+				t.Errorf("Serve() Vary = %q, want Accept-Encoding: %v", w.Header().Get("Vary"), tt.wantVary)
+			}
+			if tt.wantETagFrom != "" {
+				other := serve(ep, tt.path, map[string]string{"Accept-Encoding": tt.wantETagFrom})
+				if other.Header().Get("ETag") == w.Header().Get("ETag") {
+					t.Errorf("Serve() ETag = %s is the same for encoding %s", w.Header().Get("ETag"), tt.wantETagFrom)
+				}
+			}
+		})
+	}
+}
+
+// A cached tag of one encoding must not validate a request for another
+func TestEndpointFiles_PrecompressedConditional(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{"app.js": "plain", "app.js.br": "brotli"} {
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ep := &EndpointFiles{Source: dir, Precompressed: []string{"br"}}
+
+	r := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	ep.Serve(w, r, "app.js")
+	etag := w.Header().Get("ETag")
+
+	r = httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	ep.Serve(w, r, "app.js")
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Serve() with matching tag status = %v, want %v", w.Code, http.StatusNotModified)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	ep.Serve(w, r, "app.js")
+	if w.Code != http.StatusOK || w.Body.String() != "plain" {
+		t.Errorf("Serve() without encoding = %v %q, want 200 plain", w.Code, w.Body.String())
+	}
+}
+
+func Test_acceptedEncodings(t *testing.T) {
+	tests := []struct {
+		header   string
+		encoding string
+		want     float64
+	}{
+		{"gzip, br", "br", 1},
+		{"gzip;q=0.8, br;q=0.5", "br", 0.5},
+		{"gzip; q=0", "gzip", 0},
+		{"x-gzip", "gzip", 1},
+		{"*;q=0.1", "zstd", 0.1},
+		{"br, *;q=0", "gzip", 0},
+		{"", "br", 0},
+		{"BR", "br", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header+"/"+tt.encoding, func(t *testing.T) {
+			if got := acceptedEncodings(tt.header)(tt.encoding); got != tt.want {
+				t.Errorf("acceptedEncodings(%q)(%q) = %v, want %v", tt.header, tt.encoding, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// Statuses that redirect endpoints can send. 301 and 302 let clients change the method to GET,
+// 307 and 308 keep it and 303 always changes it
+var RedirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// EndpointRedirect is an endpoint function that sends a redirect response
+type EndpointRedirect struct {
+	// Target of the redirect, a Go template executed with RequestData. Values in the path are
+	// escaped keeping slashes, values after '?' are escaped as query values
+	URL    string `json:"url"`
+	Status int    `json:"status"` // 301 if zero
+	// Append the part of the path after the location, so '/old/a' redirects to '/new/a'
+	PreservePath bool `json:"preserve_path"`
+	// Append the query of the request to the query of the target
+	PreserveQuery bool `json:"preserve_query"`
+
+	once sync.Once
+	tmpl *template.Template
+	err  error
+}
+
+// Check that the target template can be parsed, it is parsed for use on the first request
+func (f *EndpointRedirect) Check() error {
+	_, err := parseRedirectTemplate(f.URL)
+	return err
+}
+
+func (f *EndpointRedirect) compile() error {
+	f.once.Do(func() {
+		f.tmpl, f.err = parseRedirectTemplate(f.URL)
+	})
+	return f.err
+}
+
+func (f *EndpointRedirect) Serve(w http.ResponseWriter, r *http.Request, localPath string) {
+	if err := f.compile(); err != nil {
+		log.Printf("EndpointRedirect.Serve: error parsing target template: %v", err)
+		respondWithError(w, r)
+		return
+	}
+	var target strings.Builder
+	if err := f.tmpl.Execute(&target, newRequestData(r, localPath)); err != nil {
+		log.Printf("EndpointRedirect.Serve: error executing target template: %v", err)
+		respondWithError(w, r)
+		return
+	}
+	location, err := f.location(target.String(), r, localPath)
+	if err != nil {
+		log.Printf("EndpointRedirect.Serve: invalid target '%s': %v", target.String(), err)
+		respondWithError(w, r)
+		return
+	}
+	status := f.Status
+	if status == 0 {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, location, status)
+}
+
+// Add the path and the query of the request to the target if enabled
+func (f *EndpointRedirect) location(target string, r *http.Request, localPath string) (string, error) {
+	if !f.PreservePath && !f.PreserveQuery {
+		return target, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if f.PreservePath && localPath != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(localPath, "/")
+		u.RawPath = ""
+	}
+	if f.PreserveQuery && r.URL.RawQuery != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += r.URL.RawQuery
+	}
+	return u.String(), nil
+}
+
+// Parse the target template, values are escaped for the part of the url they are in
+func parseRedirectTemplate(target string) (*template.Template, error) {
+	tmpl, err := template.New("url").Funcs(template.FuncMap{
+		"_pathEscape": func(args ...any) string {
+			return (&url.URL{Path: fmt.Sprint(args...)}).EscapedPath()
+		},
+		"_queryEscape": func(args ...any) string {
+			return url.QueryEscape(fmt.Sprint(args...))
+		},
+	}).Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		inQuery := false
+		escapeActions(t.Tree.Root, func(n parse.Node) string {
+			if text, ok := n.(*parse.TextNode); ok {
+				inQuery = inQuery || strings.ContainsRune(string(text.Text), '?')
+				return ""
+			}
+			if inQuery {
+				return "_queryEscape"
+			}
+			return "_pathEscape"
+		})
+	}
+	return tmpl, nil
+}
+
+// HTTPSRedirect redirects requests that were not made over https to the same url with https.
+// Requests with 'X-Forwarded-Proto: https' from trusted proxies are considered secure, as they
+// come through a proxy that terminates TLS
+type HTTPSRedirect struct {
+	Status int `json:"status"` // 308 if zero, so that the method and body are kept
+	Port   int `json:"port"`   // 443 if zero
+	// Proxies whose X-Forwarded-Proto is believed, the header is ignored if there are none
+	TrustedProxies []netip.Prefix `json:"trusted_proxies,omitempty"`
+}
+
+// Path of ACME HTTP-01 challenges, they must be answered over http to get a certificate
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// Redirect the request if it was not made over https, false if it was
+func (h *HTTPSRedirect) redirect(w http.ResponseWriter, r *http.Request) bool {
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") && fromTrustedProxy(r, h.TrustedProxies) {
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+		return false
+	}
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 address without a port
+		host = "[" + strings.Trim(host, "[]") + "]"
+	}
+	if h.Port != 0 && h.Port != 443 {
+		host += ":" + strconv.Itoa(h.Port)
+	}
+	u := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	status := h.Status
+	if status == 0 {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, u.String(), status)
+	return true
+}
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestEndpointRedirect_Serve(t *testing.T) {
+	tests := []struct {
+		name         string
+		ep           *EndpointRedirect
+		location     string
+		url          string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:         "fixed",
+			ep:           &EndpointRedirect{URL: "https://example.com/"},
+			location:     "/old/",
+			url:          "/old/a/b?q=1",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/",
+		}, {
+			name:         "status",
+			ep:           &EndpointRedirect{URL: "/new", Status: http.StatusTemporaryRedirect},
+			location:     "/old",
+			url:          "/old",
+			wantStatus:   http.StatusTemporaryRedirect,
+			wantLocation: "/new",
+		}, {
+			name:         "preserve_path",
+			ep:           &EndpointRedirect{URL: "https://example.com/new/", PreservePath: true},
+			location:     "/old/",
+			url:          "/old/a/b?q=1",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/new/a/b",
+		}, {
+			name:         "preserve_path_no_slash",
+			ep:           &EndpointRedirect{URL: "https://example.com/new", PreservePath: true},
+			location:     "/old",
+			url:          "/old/a%20b",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/new/a%20b",
+		}, {
+			name:         "preserve_path_root",
+			ep:           &EndpointRedirect{URL: "https://example.com/new", PreservePath: true},
+			location:     "/old/",
+			url:          "/old/",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/new",
+		}, {
+			name:         "preserve_query",
+			ep:           &EndpointRedirect{URL: "https://example.com/new?src=old", PreserveQuery: true},
+			location:     "/old/",
+			url:          "/old/a?q=1&r=2",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/new?src=old&q=1&r=2",
+		}, {
+			name:         "preserve_both",
+			ep:           &EndpointRedirect{URL: "https://example.com/", PreservePath: true, PreserveQuery: true},
+			location:     "/",
+			url:          "/a/b?q=1",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/a/b?q=1",
+		}, {
+			name:         "template",
+			ep:           &EndpointRedirect{URL: "https://{{.Query.Get `site`}}.example.com/{{.LocalPath}}"},
+			location:     "/old/",
+			url:          "/old/a/b?site=docs",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://docs.example.com/a/b",
+		}, {
+			name:         "template_path_escaped",
+			ep:           &EndpointRedirect{URL: "https://example.com/{{.LocalPath}}"},
+			location:     "/old/",
+			url:          "/old/a%3Fb/c%23d",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/a%3Fb/c%23d",
+		}, {
+			name:         "template_query_escaped",
+			ep:           &EndpointRedirect{URL: "https://example.com/search?q={{.LocalPath}}"},
+			location:     "/find/",
+			url:          "/find/a&admin=1",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/search?q=a%26admin%3D1",
+		}, {
+			name:       "template_error",
+			ep:         &EndpointRedirect{URL: "/{{.Missing}}"},
+			location:   "/",
+			url:        "/a",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			ep := &Endpoint{Location: tt.location, Function: tt.ep}
+			ep.handle(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Serve() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Serve() Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		redirect     *HTTPSRedirect
+		url          string
+		header       map[string]string
+		tls          bool
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:         "default",
+			redirect:     &HTTPSRedirect{},
+			url:          "http://example.com/a/b?q=1",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/a/b?q=1",
+		}, {
+			name:         "port",
+			redirect:     &HTTPSRedirect{Status: http.StatusMovedPermanently, Port: 8443},
+			url:          "http://example.com:8080/a",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com:8443/a",
+		}, {
+			name:         "default_port",
+			redirect:     &HTTPSRedirect{Port: 443},
+			url:          "http://example.com:8080/",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/",
+		}, {
+			name:         "ipv6",
+			redirect:     &HTTPSRedirect{},
+			url:          "http://[::1]:8080/",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://[::1]/",
+		}, {
+			name:       "tls",
+			redirect:   &HTTPSRedirect{},
+			url:        "https://example.com/",
+			tls:        true,
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "forwarded_https",
+			redirect:   &HTTPSRedirect{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}},
+			url:        "http://example.com/",
+			header:     map[string]string{"X-Forwarded-Proto": "https"},
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:         "forwarded_https_untrusted",
+			redirect:     &HTTPSRedirect{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+			url:          "http://example.com/",
+			header:       map[string]string{"X-Forwarded-Proto": "https"},
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/",
+		}, {
+			name:         "forwarded_https_no_proxies",
+			redirect:     &HTTPSRedirect{},
+			url:          "http://example.com/",
+			header:       map[string]string{"X-Forwarded-Proto": "https"},
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/",
+		}, {
+			name:       "acme_challenge",
+			redirect:   &HTTPSRedirect{},
+			url:        "http://example.com/.well-known/acme-challenge/token",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			} else {
+				r.TLS = nil
+			}
+			w := httptest.NewRecorder()
+			server := &Server{HTTPSRedirect: tt.redirect}
+			server.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("ServeHTTP() Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
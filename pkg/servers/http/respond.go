@@ -0,0 +1,204 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// EndpointRespond is an endpoint function that sends a configured response, like a health
+// check, robots.txt or a maintenance notice
+type EndpointRespond struct {
+	Status  int               `json:"status"`  // 200 if zero
+	Headers map[string]string `json:"headers"` // Content-Type is text/plain if not set
+	// Go template executed with RequestData. Values are escaped for the content type: html
+	// pages use html/template, JSON and XML values are escaped as strings of the format
+	Body string `json:"body"`
+
+	once sync.Once
+	tmpl executor
+	err  error
+}
+
+// Template of either text/template or html/template
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// RequestData is passed to body templates of respond endpoints and targets of redirects
+type RequestData struct {
+	Method string
+	Scheme string // http or https
+	Path   string
+	// Part of the path after the location of the endpoint
+	LocalPath  string
+	Query      url.Values
+	Headers    http.Header
+	Host       string
+	RemoteAddr string
+}
+
+const defaultRespondType = "text/plain; charset=utf-8"
+
+// Check that the body template can be parsed, it is parsed for use on the first request
+func (f *EndpointRespond) Check() error {
+	_, err := parseRespondTemplate(f.Body, f.contentType())
+	return err
+}
+
+func (f *EndpointRespond) compile() error {
+	f.once.Do(func() {
+		f.tmpl, f.err = parseRespondTemplate(f.Body, f.contentType())
+	})
+	return f.err
+}
+
+func (f *EndpointRespond) contentType() string {
+	for k, v := range f.Headers {
+		if http.CanonicalHeaderKey(k) == "Content-Type" {
+			return v
+		}
+	}
+	return defaultRespondType
+}
+
+func (f *EndpointRespond) Serve(w http.ResponseWriter, r *http.Request, localPath string) {
+	if err := f.compile(); err != nil {
+		log.Printf("EndpointRespond.Serve: error parsing body template: %v", err)
+		respondWithError(w, r)
+		return
+	}
+	// the body is rendered first so that errors are not sent in the middle of it
+	var body bytes.Buffer
+	err := f.tmpl.Execute(&body, newRequestData(r, localPath))
+	if err != nil {
+		log.Printf("EndpointRespond.Serve: error executing body template: %v", err)
+		respondWithError(w, r)
+		return
+	}
+
+	head := w.Header()
+	for k, v := range f.Headers {
+		head.Set(k, v)
+	}
+	if head.Get("Content-Type") == "" {
+		head.Set("Content-Type", defaultRespondType)
+	}
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	// informational, 204 and 304 responses can't have a body
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return
+	}
+	head.Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+func newRequestData(r *http.Request, localPath string) RequestData {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return RequestData{
+		Method:     r.Method,
+		Scheme:     scheme,
+		Path:       r.URL.Path,
+		LocalPath:  localPath,
+		Query:      r.URL.Query(),
+		Headers:    r.Header,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+	}
+}
+
+// Parse the body template with escaping for the content type
+func parseRespondTemplate(body string, contentType string) (executor, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		return htmltemplate.New("body").Parse(body)
+	}
+	escape := respondEscaper(mediaType)
+	if escape == nil {
+		return template.New("body").Parse(body)
+	}
+	tmpl, err := template.New("body").Funcs(template.FuncMap{"_escape": escape}).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		escapeActions(t.Tree.Root, func(parse.Node) string { return "_escape" })
+	}
+	return tmpl, nil
+}
+
+// Escaper of values for the media type, nil if values are written as is
+func respondEscaper(mediaType string) func(args ...any) string {
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		// escaped as the contents of a string, the template has the quotes
+		return func(args ...any) string {
+			b, _ := json.Marshal(fmt.Sprint(args...))
+			return string(b[1 : len(b)-1])
+		}
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return func(args ...any) string {
+			return template.HTMLEscapeString(fmt.Sprint(args...))
+		}
+	}
+	return nil
+}
+
+// Pipe the output of every action of the template through an escaper, like html/template
+// does for html. The escaper function is called for text and actions in the order they are
+// written and returns the name of the escaper for actions
+func escapeActions(node parse.Node, escaper func(n parse.Node) string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeActions(child, escaper)
+		}
+	case *parse.TextNode:
+		escaper(n)
+	case *parse.ActionNode:
+		// actions that only declare variables have no output
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		name := escaper(n)
+		if name == "" {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(name).SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		escapeActions(n.List, escaper)
+		escapeActions(n.ElseList, escaper)
+	case *parse.RangeNode:
+		escapeActions(n.List, escaper)
+		escapeActions(n.ElseList, escaper)
+	case *parse.WithNode:
+		escapeActions(n.List, escaper)
+		escapeActions(n.ElseList, escaper)
+	}
+}
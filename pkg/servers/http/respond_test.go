@@ -0,0 +1,119 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEndpointRespond_Serve(t *testing.T) {
+	tests := []struct {
+		name       string
+		ep         *EndpointRespond
+		method     string
+		url        string
+		header     map[string]string
+		wantStatus int
+		wantType   string
+		wantBody   string
+	}{
+		{
+			name:       "default",
+			ep:         &EndpointRespond{Body: "ok"},
+			url:        "/api/health",
+			wantStatus: http.StatusOK,
+			wantType:   "text/plain; charset=utf-8",
+			wantBody:   "ok",
+		}, {
+			name: "status_headers",
+			ep: &EndpointRespond{
+				Status:  http.StatusServiceUnavailable,
+				Headers: map[string]string{"Retry-After": "60", "content-type": "text/plain"},
+				Body:    "maintenance",
+			},
+			url:        "/api/",
+			wantStatus: http.StatusServiceUnavailable,
+			wantType:   "text/plain",
+			wantBody:   "maintenance",
+		}, {
+			name:       "request_data",
+			ep:         &EndpointRespond{Body: `{{.Method}} {{.Path}} {{.LocalPath}} {{.Query.Get "q"}} {{.Headers.Get "X-Name"}} {{.Host}}`},
+			method:     http.MethodPost,
+			url:        "/api/users?q=1",
+			header:     map[string]string{"X-Name": "test"},
+			wantStatus: http.StatusOK,
+			wantBody:   "POST /api/users users 1 test example.com",
+		}, {
+			name: "html_escaped",
+			ep: &EndpointRespond{
+				Headers: map[string]string{"Content-Type": "text/html"},
+				Body:    `<p>{{.Query.Get "name"}}</p><a href="/?q={{.Query.Get "name"}}">link</a>`,
+			},
+			url:        "/api/?name=%3Cscript%3E",
+			wantStatus: http.StatusOK,
+			wantBody:   `<p>&lt;script&gt;</p><a href="/?q=%3cscript%3e">link</a>`,
+		}, {
+			name: "json_escaped",
+			ep: &EndpointRespond{
+				Headers: map[string]string{"Content-Type": "application/json"},
+				Body:    `{"name": "{{.Query.Get "name"}}"{{with .Query.Get "x"}}, "x": "{{.}}"{{end}}}`,
+			},
+			url:        `/api/?name=a%22%2C%22admin%22%3Atrue%2C%22b&x=%5C`,
+			wantStatus: http.StatusOK,
+			wantType:   "application/json",
+			wantBody:   `{"name": "a\",\"admin\":true,\"b", "x": "\\"}`,
+		}, {
+			name: "xml_escaped",
+			ep: &EndpointRespond{
+				Headers: map[string]string{"Content-Type": "application/xml"},
+				Body:    `{{$name := .Query.Get "name"}}<name>{{$name}}</name>`,
+			},
+			url:        "/api/?name=%3C%2Fname%3E",
+			wantStatus: http.StatusOK,
+			wantBody:   `<name>&lt;/name&gt;</name>`,
+		}, {
+			name:       "no_content",
+			ep:         &EndpointRespond{Status: http.StatusNoContent, Body: "ignored"},
+			url:        "/api/",
+			wantStatus: http.StatusNoContent,
+			wantBody:   "",
+		}, {
+			name:       "invalid_template",
+			ep:         &EndpointRespond{Body: "{{.Missing"},
+			url:        "/api/",
+			wantStatus: http.StatusInternalServerError,
+			wantType:   "text/html; charset=utf-8",
+		}, {
+			name:       "template_error",
+			ep:         &EndpointRespond{Body: "{{.Missing}}"},
+			url:        "/api/",
+			wantStatus: http.StatusInternalServerError,
+			wantType:   "text/html; charset=utf-8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tt.url, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			ep := &Endpoint{Location: "/api/", Function: tt.ep}
+			ep.handle(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Serve() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantType != "" && w.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("Serve() Content-Type = %q, want %q", w.Header().Get("Content-Type"), tt.wantType)
+			}
+			if tt.wantStatus < 500 && w.Body.String() != tt.wantBody {
+				t.Errorf("Serve() body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -1,18 +1,226 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 )
 
-func respondWith404(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprint(w, "<h1>404</h1> <p>The page you requested does not seem to exist</p>")
+// ErrorPage is the body of error responses, only one of the fields is set
+type ErrorPage struct {
+	File     string         `json:"file,omitempty"`     // Path to a file sent as is
+	Template string         `json:"template,omitempty"` // Path to a Go template executed with ErrorData
+	Proxy    *EndpointProxy `json:"proxy,omitempty"`    // Server the page is requested from
 }
 
-func respondWithError(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusInternalServerError)
-	fmt.Fprint(w, "<h1>500</h1> <p>Internal server error</p>")
+// ErrorPages maps statuses like '404' or classes like '5xx' to the pages sent with them
+type ErrorPages map[string]*ErrorPage
+
+// Page for the status, an exact status takes precedence over its class
+func (p ErrorPages) find(status int) *ErrorPage {
+	if page, ok := p[strconv.Itoa(status)]; ok {
+		return page
+	}
+	return p[fmt.Sprintf("%dxx", status/100)]
+}
+
+// ErrorData is passed to error page templates and is the body of JSON errors
+type ErrorData struct {
+	Status    int    `json:"status"`
+	Error     string `json:"error"` // Text of the status, like 'Not Found'
+	Path      string `json:"path"`
+	RequestID string `json:"request_id"`
+}
+
+// Pages larger than this are cut, they are read in memory to fall back to the default page
+// if they fail
+const maxErrorPageSize = 1 << 20
+
+var defaultErrorTemplate = htmltemplate.Must(htmltemplate.New("error").Parse(
+	`<h1>{{.Status}}</h1> <p>{{if eq .Status 404}}The page you requested does not seem to exist{{else}}{{.Error}}{{end}}</p>`,
+))
+
+type errorPagesKey struct{}
+
+// Add error pages to the request, they take precedence over the pages added before. Servers
+// add their pages and endpoints override them with their own
+func withErrorPages(r *http.Request, pages ErrorPages) *http.Request {
+	if len(pages) == 0 {
+		return r
+	}
+	chain, _ := r.Context().Value(errorPagesKey{}).([]ErrorPages)
+	chain = append([]ErrorPages{pages}, chain...)
+	return r.WithContext(context.WithValue(r.Context(), errorPagesKey{}, chain))
+}
+
+func respondWith404(w http.ResponseWriter, r *http.Request) {
+	respondWithStatus(w, r, http.StatusNotFound)
+}
+
+func respondWithError(w http.ResponseWriter, r *http.Request) {
+	respondWithStatus(w, r, http.StatusInternalServerError)
+}
+
+// Send an error response with the page configured for the status, or JSON to clients that ask
+// for it. The default page is sent if there is no page or it fails
+func respondWithStatus(w http.ResponseWriter, r *http.Request, status int) {
+	data := ErrorData{
+		Status:    status,
+		Error:     http.StatusText(status),
+		Path:      r.URL.Path,
+		RequestID: requestID(w, r),
+	}
+	if status >= 500 {
+		log.Printf("Error %d for '%s', request id %s", status, data.Path, data.RequestID)
+	}
+	// headers of the content that failed don't apply to the error
+	head := w.Header()
+	for _, k := range []string{"Content-Encoding", "Content-Length", "ETag", "Last-Modified", "Accept-Ranges"} {
+		head.Del(k)
+	}
+
+	if prefersJSON(r) {
+		head.Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	chain, _ := r.Context().Value(errorPagesKey{}).([]ErrorPages)
+	for _, pages := range chain {
+		page := pages.find(status)
+		if page == nil {
+			continue
+		}
+		ctype, body, err := page.load(r, data)
+		if err != nil {
+			log.Printf("Error page for %d failed, sending the default one: %v", status, err)
+			break
+		}
+		if ctype == "" {
+			ctype = http.DetectContentType(body)
+		}
+		head.Set("Content-Type", ctype)
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+
+	head.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	defaultErrorTemplate.Execute(w, data)
+}
+
+// Content type and body of the page
+func (p *ErrorPage) load(r *http.Request, data ErrorData) (string, []byte, error) {
+	switch {
+	case p.File != "":
+		body, err := readErrorPageFile(p.File)
+		return mime.TypeByExtension(filepath.Ext(p.File)), body, err
+	case p.Template != "":
+		// parsed on each request so that changes to the template are shown without a reload
+		source, err := readErrorPageFile(p.Template)
+		if err != nil {
+			return "", nil, err
+		}
+		// values are escaped for the format like in bodies of respond endpoints, the path and
+		// the request id come from the client
+		ctype := mime.TypeByExtension(filepath.Ext(p.Template))
+		tmpl, err := parseRespondTemplate(string(source), ctype)
+		if err != nil {
+			return "", nil, err
+		}
+		var page bytes.Buffer
+		err = tmpl.Execute(&page, data)
+		return ctype, page.Bytes(), err
+	case p.Proxy != nil:
+		return p.fetch(r, data)
+	}
+	return "", nil, fmt.Errorf("error page is empty")
+}
+
+func readErrorPageFile(name string) ([]byte, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, maxErrorPageSize))
+}
+
+// Request the page from the proxy. The status, original uri and request id are passed in
+// headers so that the server can render a page for them
+func (p *ErrorPage) fetch(r *http.Request, data ErrorData) (string, []byte, error) {
+	scheme, host, ctx := upstreamURL(r.Context(), p.Proxy.Proto, p.Proxy.Host)
+	u := &url.URL{Scheme: scheme, Host: host, Path: p.Proxy.Path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", r.Header.Get("Accept"))
+	req.Header.Set("X-Error-Status", strconv.Itoa(data.Status))
+	req.Header.Set("X-Original-URI", r.URL.RequestURI())
+	req.Header.Set("X-Request-Id", data.RequestID)
+
+	pool, err := p.Proxy.connections()
+	if err != nil {
+		return "", nil, err
+	}
+	pool.acquire()
+	defer pool.release()
+	resp, err := pool.roundTrip(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("error page server responded with %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorPageSize))
+	return resp.Header.Get("Content-Type"), body, err
+}
+
+// Longest request id that is taken from the client
+const maxRequestIDLength = 128
+
+// ID of the request shown on error pages and in logs, taken from the X-Request-Id header if the
+// client or a proxy in front set it. IDs that aren't short tokens are replaced, generated IDs
+// are sent back in the same header
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); isRequestID(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	w.Header().Set("X-Request-Id", id)
+	return id
+}
+
+// Whether the id is a token of letters, digits, dots, dashes and underscores short enough to
+// be shown on pages and in logs as is
+func isRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
 }
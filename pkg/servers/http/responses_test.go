@@ -0,0 +1,214 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRespondWithStatus(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"404.html":      "<p>custom 404</p>",
+		"4xx.html":      "<p>{{.Status}} {{.Error}} at {{.Path}}</p>",
+		"5xx.txt":       "{{.Status}} for {{.Path}}, id {{.RequestID}}",
+		"broken.html":   "{{.Status",
+		"error.json":    `{"path":"{{.Path}}","id":"{{.RequestID}}"}`,
+		"site/page.txt": "page",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("remote " + r.Header.Get("X-Error-Status") + " " + r.Header.Get("X-Original-URI")))
+	}))
+	defer pageServer.Close()
+	pageURL, _ := url.Parse(pageServer.URL)
+	// nothing listens on the port after the server is closed
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL, _ := url.Parse(closed.URL)
+	closed.Close()
+
+	files := &EndpointFiles{Source: filepath.Join(dir, "site")}
+	server := &Server{
+		Endpoints: []Endpoint{
+			{Location: "/files/", Function: files},
+			{
+				Location: "/custom/",
+				Function: files,
+				Errors: ErrorPages{
+					"404": {Template: filepath.Join(dir, "4xx.html")},
+				},
+			},
+			{
+				Location: "/remote/",
+				Function: files,
+				Errors: ErrorPages{
+					"404": {Proxy: &EndpointProxy{Proto: "http", Host: pageURL.Host, Path: "/errors"}},
+				},
+			},
+			{
+				Location: "/broken/",
+				Function: files,
+				Errors:   ErrorPages{"4xx": {Template: filepath.Join(dir, "broken.html")}},
+			},
+			{
+				Location: "/api/",
+				Function: files,
+				Errors:   ErrorPages{"404": {Template: filepath.Join(dir, "error.json")}},
+			},
+			{
+				Location: "/upstream/",
+				Function: &EndpointProxy{Proto: "http", Host: closedURL.Host, Path: "/"},
+			},
+		},
+		Errors: ErrorPages{
+			"404": {File: filepath.Join(dir, "404.html")},
+			"5xx": {Template: filepath.Join(dir, "5xx.txt")},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		header     map[string]string
+		wantStatus int
+		wantType   string
+		wantBody   string
+	}{
+		{
+			name:       "routing",
+			url:        "/missing",
+			wantStatus: http.StatusNotFound,
+			wantType:   "text/html; charset=utf-8",
+			wantBody:   "<p>custom 404</p>",
+		}, {
+			name:       "files",
+			url:        "/files/missing.txt",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "<p>custom 404</p>",
+		}, {
+			name:       "endpoint_override",
+			url:        "/custom/missing.txt",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "<p>404 Not Found at /custom/missing.txt</p>",
+		}, {
+			name:       "template_escaped",
+			url:        "/custom/<b>",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "<p>404 Not Found at /custom/&lt;b&gt;</p>",
+		}, {
+			name:       "template_json_escaped",
+			url:        "/api/a%22b",
+			header:     map[string]string{"X-Request-Id": "abc"},
+			wantStatus: http.StatusNotFound,
+			wantType:   "application/json",
+			wantBody:   `{"path":"/api/a\"b","id":"abc"}`,
+		}, {
+			name:       "proxy_page",
+			url:        "/remote/missing.txt?a=1",
+			wantStatus: http.StatusNotFound,
+			wantType:   "text/plain",
+			wantBody:   "remote 404 /remote/missing.txt?a=1",
+		}, {
+			name:       "broken_page",
+			url:        "/broken/missing.txt",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "<h1>404</h1> <p>The page you requested does not seem to exist</p>",
+		}, {
+			name:       "upstream_down",
+			url:        "/upstream/page",
+			header:     map[string]string{"X-Request-Id": "abc"},
+			wantStatus: http.StatusBadGateway,
+			wantType:   "text/plain; charset=utf-8",
+			wantBody:   "502 for /upstream/page, id abc",
+		}, {
+			name:       "json",
+			url:        "/files/missing.txt",
+			header:     map[string]string{"Accept": "application/json", "X-Request-Id": "abc"},
+			wantStatus: http.StatusNotFound,
+			wantType:   "application/json",
+			wantBody:   `{"status":404,"error":"Not Found","path":"/files/missing.txt","request_id":"abc"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.URL, _ = url.ParseRequestURI(tt.url)
+			r.RequestURI = tt.url
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantType != "" && w.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("ServeHTTP() Content-Type = %q, want %q", w.Header().Get("Content-Type"), tt.wantType)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+// Request ids of clients are kept if they are short tokens, others are replaced with generated
+// ones that are sent back to the client
+func TestRespondWithStatus_RequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		wantKept bool
+	}{
+		{name: "none"},
+		{name: "token", id: "req-1.a_B", wantKept: true},
+		{name: "longest", id: strings.Repeat("a", 128), wantKept: true},
+		{name: "too_long", id: strings.Repeat("a", 129)},
+		{name: "quote", id: `a"b`},
+		{name: "space", id: "a b"},
+		{name: "markup", id: "<script>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/missing", nil)
+			r.Header.Set("Accept", "application/json")
+			if tt.id != "" {
+				r.Header.Set("X-Request-Id", tt.id)
+			}
+			w := httptest.NewRecorder()
+			(&Server{}).ServeHTTP(w, r)
+
+			var data ErrorData
+			if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+				t.Fatalf("ServeHTTP() body error = %v", err)
+			}
+			if tt.wantKept {
+				if data.RequestID != tt.id || w.Header().Get("X-Request-Id") != "" {
+					t.Errorf("ServeHTTP() request id = %q, header %q, want %q kept", data.RequestID, w.Header().Get("X-Request-Id"), tt.id)
+				}
+				return
+			}
+			id := w.Header().Get("X-Request-Id")
+			if id == "" || id == tt.id || data.RequestID != id {
+				t.Errorf("ServeHTTP() request id = %q, header %q, want a generated one", data.RequestID, id)
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				t.Errorf("ServeHTTP() Content-Type = %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of retries and circuit breakers of proxies
+const (
+	DefaultRetryAttempts      = 3
+	DefaultRetryBackoff       = 50 * time.Millisecond
+	DefaultRetryMaxBackoff    = time.Second
+	DefaultBreakerFailures    = 5
+	DefaultBreakerOpenTimeout = 30 * time.Second
+)
+
+// Statuses that are retried if the policy doesn't list its own
+var DefaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetryPolicy sends requests that failed again, to another server of the pool if there is one.
+// Only requests with idempotent methods and without a body are retried
+type RetryPolicy struct {
+	// Tries of a request including the first one, DefaultRetryAttempts if zero
+	Attempts int `json:"attempts,omitempty"`
+	// Responses with these statuses are retried like errors, DefaultRetryStatuses if nil
+	Statuses []int `json:"statuses,omitempty"`
+	// Wait before the first retry, doubled for each next one up to MaxBackoff. Waits are
+	// randomized so that clients don't retry at the same time
+	Backoff    time.Duration `json:"backoff,omitempty"`
+	MaxBackoff time.Duration `json:"max_backoff,omitempty"`
+}
+
+// CircuitBreaker stops sending requests to the upstream after failures in a row, requests are
+// answered with 503 until OpenTimeout passes. Then one request is let through and its result
+// closes the breaker or opens it again
+type CircuitBreaker struct {
+	// Failed requests in a row that open the breaker, DefaultBreakerFailures if zero
+	Failures    int           `json:"failures,omitempty"`
+	OpenTimeout time.Duration `json:"open_timeout,omitempty"` // DefaultBreakerOpenTimeout if zero
+
+	mu       sync.Mutex
+	failures int
+	state    string
+	openedAt time.Time
+}
+
+// States of circuit breakers
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// ProxyMetrics are counters of the requests of a proxy endpoint since the config was loaded
+type ProxyMetrics struct {
+	Location string `json:"location"`
+	Requests uint64 `json:"requests"`
+	Retries  uint64 `json:"retries"`
+	// Requests answered with 502 after the upstream failed
+	Errors uint64 `json:"errors"`
+	// Requests answered with 504 after the upstream didn't respond in time
+	Timeouts uint64 `json:"timeouts"`
+	// Requests answered with 503 without trying the upstream, because the circuit breaker was
+	// open or no server of the pool was available
+	Rejected uint64 `json:"rejected"`
+	Circuit  string `json:"circuit,omitempty"`
+	// Counters of the cache in front of the proxy, nil if it has none
+	Cache *CacheMetrics `json:"cache,omitempty"`
+}
+
+type proxyCounters struct {
+	requests, retries, errors, timeouts, rejected atomic.Uint64
+}
+
+// Counters of the requests of the proxy
+func (f *EndpointProxy) Metrics() ProxyMetrics {
+	m := ProxyMetrics{
+		Requests: f.counters.requests.Load(),
+		Retries:  f.counters.retries.Load(),
+		Errors:   f.counters.errors.Load(),
+		Timeouts: f.counters.timeouts.Load(),
+		Rejected: f.counters.rejected.Load(),
+	}
+	if f.CircuitBreaker != nil {
+		m.Circuit = f.CircuitBreaker.State()
+	}
+	return m
+}
+
+// Whether a request that failed with the response or the error can be sent again
+func (p *RetryPolicy) retry(r *http.Request, attempt int, resp *http.Response, err error) bool {
+	if p == nil || !isIdempotent(r.Method) || r.ContentLength != 0 {
+		return false
+	}
+	attempts := p.Attempts
+	if attempts == 0 {
+		attempts = DefaultRetryAttempts
+	}
+	if attempt >= attempts || r.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	statuses := p.Statuses
+	if statuses == nil {
+		statuses = DefaultRetryStatuses
+	}
+	return slices.Contains(statuses, resp.StatusCode)
+}
+
+// Wait before the retry, false if the context ended first
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) bool {
+	backoff := p.Backoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+	// between half and the whole backoff
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Methods that can be sent again without changing the result, RFC 9110 9.2.2
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Whether the error is a timeout of the upstream
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Whether a request can be sent to the upstream, the breaker is half-open after the timeout and
+// lets one request through
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		timeout := b.OpenTimeout
+		if timeout == 0 {
+			timeout = DefaultBreakerOpenTimeout
+		}
+		if time.Since(b.openedAt) < timeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// the trial request is in progress
+		return false
+	}
+	return true
+}
+
+// Record the result of a request that was let through
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		b.state = BreakerClosed
+		return
+	}
+	b.failures++
+	failures := b.Failures
+	if failures == 0 {
+		failures = DefaultBreakerFailures
+	}
+	if b.state == BreakerHalfOpen || b.failures >= failures {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release the trial request of a half-open breaker without a result, the next request is tried
+// instead
+func (b *CircuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+	}
+}
+
+// State of the breaker: closed, open or half-open
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == "" {
+		return BreakerClosed
+	}
+	return b.state
+}
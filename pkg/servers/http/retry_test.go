@@ -0,0 +1,226 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEndpointProxy_Retry(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("good"))
+	}))
+	defer good.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name        string
+		servers     []*httptest.Server
+		retry       *RetryPolicy
+		method      string
+		body        string
+		wantStatus  []int
+		wantMetrics ProxyMetrics
+	}{
+		{
+			name:        "next_server",
+			servers:     []*httptest.Server{failing, good},
+			retry:       &RetryPolicy{Backoff: time.Millisecond},
+			method:      http.MethodGet,
+			wantStatus:  []int{200, 200, 200, 200},
+			wantMetrics: ProxyMetrics{Requests: 4, Retries: 2},
+		}, {
+			name:        "no_policy",
+			servers:     []*httptest.Server{failing, good},
+			method:      http.MethodGet,
+			wantStatus:  []int{503, 200, 503, 200},
+			wantMetrics: ProxyMetrics{Requests: 4},
+		}, {
+			name:        "not_idempotent",
+			servers:     []*httptest.Server{failing, good},
+			retry:       &RetryPolicy{Backoff: time.Millisecond},
+			method:      http.MethodPost,
+			wantStatus:  []int{503, 200},
+			wantMetrics: ProxyMetrics{Requests: 2},
+		}, {
+			name:        "with_body",
+			servers:     []*httptest.Server{failing, good},
+			retry:       &RetryPolicy{Backoff: time.Millisecond},
+			method:      http.MethodPut,
+			body:        "data",
+			wantStatus:  []int{503, 200},
+			wantMetrics: ProxyMetrics{Requests: 2},
+		}, {
+			name:        "status_not_listed",
+			servers:     []*httptest.Server{failing, good},
+			retry:       &RetryPolicy{Statuses: []int{http.StatusBadGateway}, Backoff: time.Millisecond},
+			method:      http.MethodGet,
+			wantStatus:  []int{503, 200},
+			wantMetrics: ProxyMetrics{Requests: 2},
+		}, {
+			name:        "connect_error",
+			servers:     []*httptest.Server{closed},
+			retry:       &RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+			method:      http.MethodGet,
+			wantStatus:  []int{502},
+			wantMetrics: ProxyMetrics{Requests: 1, Retries: 2, Errors: 1},
+		}, {
+			name:        "all_failing",
+			servers:     []*httptest.Server{failing},
+			retry:       &RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+			method:      http.MethodGet,
+			wantStatus:  []int{503},
+			wantMetrics: ProxyMetrics{Requests: 1, Retries: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &UpstreamPool{Name: "test", MaxFails: -1}
+			for _, s := range tt.servers {
+				pool.Servers = append(pool.Servers, upstreamFor(s))
+			}
+			proxy := &EndpointProxy{Path: "/", Upstream: pool, Retry: tt.retry}
+			defer proxy.Close()
+
+			var got []int
+			for range tt.wantStatus {
+				w := httptest.NewRecorder()
+				proxy.Serve(w, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)), "")
+				got = append(got, w.Code)
+			}
+			for i := range got {
+				if got[i] != tt.wantStatus[i] {
+					t.Fatalf("Serve() statuses = %v, want %v", got, tt.wantStatus)
+				}
+			}
+			if m := proxy.Metrics(); m != tt.wantMetrics {
+				t.Errorf("Metrics() = %+v, want %+v", m, tt.wantMetrics)
+			}
+			for _, u := range pool.Servers {
+				if u.active.Load() != 0 {
+					t.Errorf("active = %v for %v after the requests finished", u.active.Load(), u.Host)
+				}
+			}
+		})
+	}
+}
+
+func TestEndpointProxy_Timeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		w.Write([]byte("done"))
+	}))
+	defer slow.Close()
+	proxy := proxyTo(slow, ProxyTransport{})
+	proxy.Timeout = 50 * time.Millisecond
+	defer proxy.Close()
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "slow")
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Serve() status = %v, want %v", w.Code, http.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Serve() took %v", elapsed)
+	}
+
+	// the timeout ends with the response headers, the body is not cut
+	w = httptest.NewRecorder()
+	proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "fast")
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("Serve() = %v %q, want 200 %q", w.Code, w.Body.String(), "done")
+	}
+	if m := proxy.Metrics(); m.Timeouts != 1 || m.Requests != 2 {
+		t.Errorf("Metrics() = %+v", m)
+	}
+}
+
+func TestEndpointProxy_CircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	proxy := proxyTo(server, ProxyTransport{})
+	proxy.CircuitBreaker = &CircuitBreaker{Failures: 2, OpenTimeout: 50 * time.Millisecond}
+	defer proxy.Close()
+
+	serve := func() int {
+		w := httptest.NewRecorder()
+		proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "")
+		return w.Code
+	}
+
+	failing.Store(true)
+	for i := 0; i < 2; i++ {
+		if code := serve(); code != http.StatusBadGateway {
+			t.Fatalf("Serve() status = %v before the breaker opened, want %v", code, http.StatusBadGateway)
+		}
+	}
+	if code := serve(); code != http.StatusServiceUnavailable || hits.Load() != 2 {
+		t.Fatalf("Serve() status = %v with %v upstream requests, want %v without a request", code, hits.Load(), http.StatusServiceUnavailable)
+	}
+	if state := proxy.CircuitBreaker.State(); state != BreakerOpen {
+		t.Errorf("State() = %v, want %v", state, BreakerOpen)
+	}
+
+	// the trial request fails and opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	if code := serve(); code != http.StatusBadGateway {
+		t.Fatalf("Serve() status = %v for the trial request, want %v", code, http.StatusBadGateway)
+	}
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Fatalf("Serve() status = %v after the trial failed, want %v", code, http.StatusServiceUnavailable)
+	}
+
+	failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("Serve() status = %v after the server recovered, want %v", code, http.StatusOK)
+		}
+	}
+	m := proxy.Metrics()
+	if m.Circuit != BreakerClosed || m.Rejected != 2 || m.Requests != 8 {
+		t.Errorf("Metrics() = %+v", m)
+	}
+}
+
+func TestCircuitBreaker_halfOpen(t *testing.T) {
+	b := &CircuitBreaker{Failures: 1, OpenTimeout: time.Millisecond}
+	b.record(errNoUpstream)
+	time.Sleep(5 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("allow() = false after the open timeout")
+	}
+	if b.allow() {
+		t.Fatal("allow() = true while the trial request is in progress")
+	}
+	// a trial abandoned by the client lets the next request try
+	b.abandon()
+	if !b.allow() {
+		t.Fatal("allow() = false after the trial was abandoned")
+	}
+	b.record(nil)
+	if state := b.State(); state != BreakerClosed {
+		t.Errorf("State() = %v after a success, want %v", state, BreakerClosed)
+	}
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProxyRewrite are the changes a proxy makes to the headers it passes
+type ProxyRewrite struct {
+	// Map Location and Content-Location of responses that point at the upstream from the path of
+	// the proxy to the location of the endpoint, so that redirects reach the endpoint again
+	RewriteLocation bool `json:"rewrite_location,omitempty"`
+	// Map the Path of cookies set by the upstream the same way, and replace their Domain if it
+	// is the host of the upstream
+	RewriteCookies bool `json:"rewrite_cookies,omitempty"`
+	// Domain of rewritten cookies, they are only sent to the host they came from if empty
+	CookieDomain string `json:"cookie_domain,omitempty"`
+	// Changes to the headers of requests sent to the upstream and responses sent to the client,
+	// made after the proxy set its own
+	RequestHeaders  *HeaderRewrite `json:"request_headers,omitempty"`
+	ResponseHeaders *HeaderRewrite `json:"response_headers,omitempty"`
+}
+
+// HeaderRewrite changes the headers of a message. Headers are removed first, then set and then
+// added
+type HeaderRewrite struct {
+	// Replace the values of the headers
+	Set map[string]string `json:"set,omitempty"`
+	// Add values to the ones the headers have
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+func (h *HeaderRewrite) apply(head http.Header) {
+	if h == nil {
+		return
+	}
+	for _, name := range h.Remove {
+		head.Del(name)
+	}
+	for name, value := range h.Set {
+		head.Set(name, value)
+	}
+	for name, value := range h.Add {
+		head.Add(name, value)
+	}
+}
+
+// Paths of the upstream and the endpoint that responses are mapped between, both end with a
+// slash. The location is what the request path had before the local path
+func (f *EndpointProxy) rewriteBases(r *http.Request, localPath string) (upstream string, location string) {
+	upstream, location = f.Path, strings.TrimSuffix(r.URL.Path, localPath)
+	if !strings.HasSuffix(upstream, "/") {
+		upstream += "/"
+	}
+	if !strings.HasSuffix(location, "/") {
+		location += "/"
+	}
+	return
+}
+
+// Map the path from the upstream to the endpoint, ok is false if it is outside of the proxy path
+func mapPath(path string, upstream string, location string) (string, bool) {
+	if path == strings.TrimSuffix(upstream, "/") {
+		return strings.TrimSuffix(location, "/"), true
+	}
+	if rest, ok := strings.CutPrefix(path, upstream); ok {
+		return location + rest, true
+	}
+	return path, false
+}
+
+// Change the headers of the upstream response as the proxy is set to
+func (f *EndpointProxy) rewriteResponse(resp *http.Response, r *http.Request, localPath string) {
+	if f.RewriteLocation || f.RewriteCookies {
+		upstream, location := f.rewriteBases(r, localPath)
+		if f.RewriteLocation {
+			for _, name := range []string{"Location", "Content-Location"} {
+				if v := resp.Header.Get(name); v != "" {
+					resp.Header.Set(name, rewriteLocation(v, resp.Request, upstream, location))
+				}
+			}
+		}
+		if f.RewriteCookies {
+			cookies := resp.Header.Values("Set-Cookie")
+			for i, c := range cookies {
+				cookies[i] = f.rewriteCookie(c, resp.Request, upstream, location)
+			}
+		}
+	}
+	f.ResponseHeaders.apply(resp.Header)
+}
+
+// Location that points at the upstream, by its path or its url, is mapped to the endpoint. Urls
+// of other hosts are left as they are
+func rewriteLocation(value string, out *http.Request, upstream string, location string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Opaque != "" || u.Path != "" && !strings.HasPrefix(u.Path, "/") {
+		return value
+	}
+	if u.Host != "" && !isUpstreamHost(u, out) {
+		return value
+	}
+	path, ok := mapPath(u.Path, upstream, location)
+	if !ok {
+		return value
+	}
+	// the client reaches the upstream through the host it already uses
+	mapped := &url.URL{Path: path, RawQuery: u.RawQuery, Fragment: u.Fragment}
+	return mapped.String()
+}
+
+// Whether the url is on the host the request was sent to, or the host the upstream was told
+func isUpstreamHost(u *url.URL, out *http.Request) bool {
+	for _, host := range []string{out.URL.Host, out.Host} {
+		if host != "" && strings.EqualFold(withoutDefaultPort(u.Host, u.Scheme), withoutDefaultPort(host, out.URL.Scheme)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Host without the port
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func withoutDefaultPort(host string, scheme string) string {
+	if h, port, err := net.SplitHostPort(host); err == nil &&
+		(port == "80" && scheme == "http" || port == "443" && scheme == "https") {
+		return h
+	}
+	return host
+}
+
+// Rewrite the Path and Domain attributes of a Set-Cookie value, the rest is kept as is
+func (f *EndpointProxy) rewriteCookie(cookie string, out *http.Request, upstream string, location string) string {
+	parts := strings.Split(cookie, ";")
+	kept := []string{parts[0]}
+	for _, attr := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch {
+		case strings.EqualFold(name, "Path"):
+			if path, ok := mapPath(value, upstream, location); ok {
+				attr = " Path=" + path
+			}
+		case strings.EqualFold(name, "Domain"):
+			domain := strings.TrimPrefix(value, ".")
+			if strings.EqualFold(domain, out.URL.Hostname()) || strings.EqualFold(domain, hostname(out.Host)) {
+				if f.CookieDomain == "" {
+					continue
+				}
+				attr = " Domain=" + f.CookieDomain
+			}
+		}
+		kept = append(kept, attr)
+	}
+	return strings.Join(kept, ";")
+}
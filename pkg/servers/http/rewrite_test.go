@@ -0,0 +1,146 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestEndpointProxy_rewriteResponse(t *testing.T) {
+	out := httptest.NewRequest(http.MethodGet, "http://backend:8080/api/items", nil)
+	out.Host = ""
+	tests := []struct {
+		name      string
+		proxyPath string
+		location  string
+		rewrite   ProxyRewrite
+		header    http.Header
+		want      http.Header
+	}{
+		{
+			name:      "location_path",
+			proxyPath: "/api/",
+			location:  "/v1/",
+			rewrite:   ProxyRewrite{RewriteLocation: true},
+			header:    http.Header{"Location": {"/api/login?next=%2F"}, "Content-Location": {"/api/items/1"}},
+			want:      http.Header{"Location": {"/v1/login?next=%2F"}, "Content-Location": {"/v1/items/1"}},
+		}, {
+			name:      "location_url",
+			proxyPath: "/api",
+			location:  "/v1",
+			rewrite:   ProxyRewrite{RewriteLocation: true},
+			header:    http.Header{"Location": {"http://backend:8080/api"}},
+			want:      http.Header{"Location": {"/v1"}},
+		}, {
+			name:      "location_default_port",
+			proxyPath: "/",
+			location:  "/app/",
+			rewrite:   ProxyRewrite{RewriteLocation: true},
+			header:    http.Header{"Location": {"http://BACKEND:8080/login#top"}},
+			want:      http.Header{"Location": {"/app/login#top"}},
+		}, {
+			name:      "location_other_host",
+			proxyPath: "/api/",
+			location:  "/v1/",
+			rewrite:   ProxyRewrite{RewriteLocation: true},
+			header:    http.Header{"Location": {"https://auth.example.com/api/login"}},
+			want:      http.Header{"Location": {"https://auth.example.com/api/login"}},
+		}, {
+			name:      "location_outside",
+			proxyPath: "/api/",
+			location:  "/v1/",
+			rewrite:   ProxyRewrite{RewriteLocation: true},
+			header:    http.Header{"Location": {"/static/a.css"}},
+			want:      http.Header{"Location": {"/static/a.css"}},
+		}, {
+			name:      "location_relative",
+			proxyPath: "/api/",
+			location:  "/v1/",
+			rewrite:   ProxyRewrite{RewriteLocation: true},
+			header:    http.Header{"Location": {"../login"}},
+			want:      http.Header{"Location": {"../login"}},
+		}, {
+			name:      "location_off",
+			proxyPath: "/api/",
+			location:  "/v1/",
+			header:    http.Header{"Location": {"/api/login"}},
+			want:      http.Header{"Location": {"/api/login"}},
+		}, {
+			name:      "cookies",
+			proxyPath: "/api/",
+			location:  "/v1/",
+			rewrite:   ProxyRewrite{RewriteCookies: true},
+			header: http.Header{"Set-Cookie": {
+				"a=1; Path=/api/; Domain=backend; HttpOnly",
+				"b=2; path=/; domain=.example.com",
+				"c=3",
+			}},
+			want: http.Header{"Set-Cookie": {
+				"a=1; Path=/v1/; HttpOnly",
+				"b=2; path=/; domain=.example.com",
+				"c=3",
+			}},
+		}, {
+			name:      "cookie_domain",
+			proxyPath: "/",
+			location:  "/",
+			rewrite:   ProxyRewrite{RewriteCookies: true, CookieDomain: "example.com"},
+			header:    http.Header{"Set-Cookie": {"a=1; Domain=.backend; Secure"}},
+			want:      http.Header{"Set-Cookie": {"a=1; Domain=example.com; Secure"}},
+		}, {
+			name:      "headers",
+			proxyPath: "/",
+			location:  "/",
+			rewrite: ProxyRewrite{ResponseHeaders: &HeaderRewrite{
+				Set:    map[string]string{"Cache-Control": "no-store"},
+				Add:    map[string]string{"Vary": "Cookie"},
+				Remove: []string{"Server", "Cache-Control"},
+			}},
+			header: http.Header{"Server": {"backend"}, "Cache-Control": {"max-age=60"}, "Vary": {"Accept"}},
+			want:   http.Header{"Cache-Control": {"no-store"}, "Vary": {"Accept", "Cookie"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := &EndpointProxy{Path: tt.proxyPath, ProxyRewrite: tt.rewrite}
+			r := httptest.NewRequest(http.MethodGet, tt.location+"items", nil)
+			resp := &http.Response{Header: tt.header, Request: out}
+			proxy.rewriteResponse(resp, r, "items")
+			if !reflect.DeepEqual(resp.Header, tt.want) {
+				t.Errorf("rewriteResponse() = %v, want %v", resp.Header, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointProxy_rewriteHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		http.Redirect(w, r, "/inner/done", http.StatusFound)
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	proxy := &EndpointProxy{Proto: "http", Host: u.Host, Path: "/inner/", ProxyRewrite: ProxyRewrite{
+		RewriteLocation: true,
+		RequestHeaders: &HeaderRewrite{
+			Set:    map[string]string{"X-Api-Key": "secret"},
+			Remove: []string{"Cookie"},
+		},
+	}}
+	defer proxy.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/outer/start", nil)
+	r.Header.Set("Cookie", "session=1")
+	r.Header.Set("X-Api-Key", "client")
+	w := httptest.NewRecorder()
+	proxy.Serve(w, r, "start")
+	if got.Get("X-Api-Key") != "secret" || got.Get("Cookie") != "" {
+		t.Errorf("upstream got headers %v", got)
+	}
+	if location := w.Header().Get("Location"); w.Code != http.StatusFound || location != "/outer/done" {
+		t.Errorf("Serve() = %v to %q, want %v to %q", w.Code, location, http.StatusFound, "/outer/done")
+	}
+}
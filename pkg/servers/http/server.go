@@ -1,22 +1,38 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Server is a host container for endpoints
 type Server struct {
-	Endpoints []Endpoint
+	Endpoints []Endpoint `json:"endpoints"`
+	// Pages sent with error responses, endpoints can override them
+	Errors ErrorPages `json:"errors,omitempty"`
+	// Redirect requests made over http to https, nil if they are served
+	HTTPSRedirect *HTTPSRedirect `json:"https_redirect,omitempty"`
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.HTTPSRedirect != nil && s.HTTPSRedirect.redirect(w, r) {
+		return
+	}
+	r = withErrorPages(r, s.Errors)
 	var path = r.URL.Path
 	for _, e := range s.Endpoints {
 		if strings.HasPrefix(path, e.Location) {
@@ -27,11 +43,98 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	respondWith404(w, r)
 }
 
+// Start background work of the server, like health checks of upstream pools. It runs until the
+// server is closed
+func (s *Server) Start() {
+	for _, pool := range s.upstreamPools() {
+		pool.startHealthChecks()
+	}
+}
+
+// Close connections that the server keeps open, like the ones of proxies, and stop health
+// checks. It is called when the server is replaced with a new config, requests that are being
+// served are finished first
+func (s *Server) Close() error {
+	for _, pool := range s.upstreamPools() {
+		pool.stopHealthChecks()
+	}
+	pages := []ErrorPages{s.Errors}
+	for _, e := range s.Endpoints {
+		if c, ok := e.Function.(io.Closer); ok {
+			c.Close()
+		}
+		pages = append(pages, e.Errors)
+	}
+	for _, p := range pages {
+		for _, page := range p {
+			if page.Proxy != nil {
+				page.Proxy.Close()
+			}
+		}
+	}
+	return nil
+}
+
+// UpstreamStatus is the health of the servers of all upstream pools
+func (s *Server) UpstreamStatus() []UpstreamStatus {
+	status := []UpstreamStatus{}
+	for _, pool := range s.upstreamPools() {
+		status = append(status, pool.Status()...)
+	}
+	return status
+}
+
+// ProxyMetrics are the counters of all proxy endpoints
+func (s *Server) ProxyMetrics() []ProxyMetrics {
+	metrics := []ProxyMetrics{}
+	for _, e := range s.Endpoints {
+		if proxy := endpointProxy(e.Function); proxy != nil {
+			m := proxy.Metrics()
+			m.Location = e.Location
+			if cache, ok := e.Function.(*EndpointCache); ok {
+				cm := cache.Metrics()
+				m.Cache = &cm
+			}
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// PurgeCache removes the responses stored by caches of the server like EndpointCache.Purge,
+// and returns how many there were
+func (s *Server) PurgeCache(host string, path string) int {
+	purged := 0
+	for _, e := range s.Endpoints {
+		if cache, ok := e.Function.(*EndpointCache); ok {
+			purged += cache.Purge(host, path)
+		}
+	}
+	return purged
+}
+
+// Pools used by proxies of the server, each pool once
+func (s *Server) upstreamPools() []*UpstreamPool {
+	var pools []*UpstreamPool
+	for _, e := range s.Endpoints {
+		if proxy := endpointProxy(e.Function); proxy != nil && proxy.Upstream != nil {
+			if !slices.Contains(pools, proxy.Upstream) {
+				pools = append(pools, proxy.Upstream)
+			}
+		}
+	}
+	return pools
+}
+
 // An endpoint is the main unit of routing inside the server. Incoming requests
 // are handled by corresponding EndpointFunction
 type Endpoint struct {
 	Location string
 	Function EndpointFunction
+	// Compression of responses, responses are sent as is if nil
+	Compress *Compression
+	// Pages sent with error responses of the endpoint, in addition to the ones of the server
+	Errors ErrorPages
 }
 
 func (ep *Endpoint) handle(w http.ResponseWriter, r *http.Request) {
@@ -39,6 +142,12 @@ func (ep *Endpoint) handle(w http.ResponseWriter, r *http.Request) {
 	// TODO: customize trimming
 	var localPath = strings.TrimPrefix(path, ep.Location)
 
+	r = withErrorPages(r, ep.Errors)
+	if ep.Compress != nil {
+		cw := ep.Compress.wrap(w, r)
+		defer cw.Close()
+		w = cw
+	}
 	ep.Function.Serve(w, r, localPath)
 }
 
@@ -48,55 +157,286 @@ type EndpointFunction interface {
 	Serve(w http.ResponseWriter, r *http.Request, localPath string)
 }
 
+// Proxy of the endpoint function, including the one wrapped by a cache. Nil if there is none
+func endpointProxy(fun EndpointFunction) *EndpointProxy {
+	switch f := fun.(type) {
+	case *EndpointProxy:
+		return f
+	case *EndpointCache:
+		return f.Proxy
+	}
+	return nil
+}
+
 // EndpointFiles is an endpoint function that serves files from local filesystem
 type EndpointFiles struct {
-	Source string // Path to the directory that the files will be served from
+	Source       string `json:"source"`        // Path to the directory that the files will be served from
+	CacheControl string `json:"cache_control"` // Cache-Control header sent with the files, none if empty
+	// Files served for directories, the first one that exists is used. Nil means index.html,
+	// an empty list disables index files
+	Index []string `json:"index"`
+	// File served for pages that don't exist, used by single page apps that route on the
+	// client. Paths are relative to the source
+	Fallback string `json:"fallback"`
+	// List contents of directories without an index file
+	Browse bool `json:"browse"`
+	// Path to a html/template file used for listings instead of the default page
+	BrowseTemplate string `json:"browse_template"`
+	// Encodings of precompressed siblings like 'file.js.br' that are served to clients
+	// accepting them, in order of preference. Names are keys of PrecompressedExtensions
+	Precompressed []string `json:"precompressed"`
+	// Whether symbolic links inside of the source are followed, one of SymlinkPolicies. Empty
+	// means always
+	FollowSymlinks string `json:"follow_symlinks"`
+	// Whether files whose names start with a dot are served, one of HiddenPolicies. Empty
+	// means unlisted
+	Hidden string `json:"hidden"`
+	// Patterns of paths that are never served, like '*.bak' or 'private/*'
+	Deny []string `json:"deny"`
 }
 
+var defaultIndex = []string{"index.html"}
+
 func (f *EndpointFiles) Serve(w http.ResponseWriter, r *http.Request, localPath string) {
+	localPath = strings.TrimPrefix(localPath, "/")
 	// only serve files from current subtree to prevent access to the whole filesystem
 	if !filepath.IsLocal(localPath) && localPath != "" {
 		respondWith404(w, r)
 		return
 	}
-	// TODO: use a setting
-	if localPath == "" {
-		localPath = "index.html"
+
+	info, err := f.stat(localPath)
+	if err != nil {
+		f.serveFallback(w, r)
+		return
+	}
+
+	// relative links in pages only work if directory urls end with a slash and file urls don't
+	isDirURL := strings.HasSuffix(r.URL.Path, "/")
+	if info.IsDir() && !isDirURL {
+		redirectToPath(w, r, r.URL.EscapedPath()+"/")
+		return
+	}
+	if !info.IsDir() && isDirURL {
+		redirectToPath(w, r, strings.TrimRight(r.URL.EscapedPath(), "/"))
+		return
 	}
 
-	// join will remove all excess separators
-	var fpath = filepath.Join(f.Source, localPath)
-	var file, err = os.OpenFile(fpath, os.O_RDONLY, 0)
+	if info.IsDir() {
+		index := f.Index
+		if index == nil {
+			index = defaultIndex
+		}
+		for _, name := range index {
+			name = path.Join(localPath, name)
+			if indexInfo, err := f.stat(name); err == nil && !indexInfo.IsDir() {
+				f.serveFile(w, r, name)
+				return
+			}
+		}
+		if f.Browse {
+			f.serveListing(w, r, localPath)
+			return
+		}
+		f.serveFallback(w, r)
+		return
+	}
+	f.serveFile(w, r, localPath)
+}
+
+// Serve the fallback file for pages, other requests like missing scripts or images get 404 so
+// that the errors are not hidden by a page
+func (f *EndpointFiles) serveFallback(w http.ResponseWriter, r *http.Request) {
+	if f.Fallback == "" || !isPageRequest(r) {
+		respondWith404(w, r)
+		return
+	}
+	f.serveFile(w, r, filepath.ToSlash(f.Fallback))
+}
+
+// Request is for a page if the browser navigates to it or if it has no file extension
+func isPageRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return true
+	}
+	return path.Ext(r.URL.Path) == ""
+}
+
+// Serve a file of the source by its slash separated path
+func (f *EndpointFiles) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	file, info, err := f.open(name)
 	if err != nil {
 		respondWith404(w, r)
 		return
 	}
 	defer file.Close()
-	var mime = mime.TypeByExtension(filepath.Ext(fpath))
-	w.Header().Set("Content-Type", mime)
-	io.Copy(w, file)
+
+	head := w.Header()
+	// without a type ServeContent will sniff it from the content. The requested name is used,
+	// not the target of a link
+	ctype := mime.TypeByExtension(path.Ext(name))
+	etag := fileETag(info)
+	content, contentInfo := file, info
+	if len(f.Precompressed) > 0 {
+		head.Add("Vary", "Accept-Encoding")
+		if encoding, cfile, cinfo, ok := f.openPrecompressed(r, name); ok {
+			defer cfile.Close()
+			// compressed content can't be sniffed, the type comes from the original file
+			if ctype == "" {
+				ctype = sniffContentType(file)
+			}
+			content, contentInfo = cfile, cinfo
+			head.Set("Content-Encoding", encoding)
+			// representations must have different tags, otherwise caches could mix them up
+			etag = strings.TrimSuffix(fileETag(cinfo), `"`) + "-" + encoding + `"`
+		}
+	}
+	if ctype != "" {
+		head.Set("Content-Type", ctype)
+	}
+	head.Set("ETag", etag)
+	if f.CacheControl != "" {
+		head.Set("Cache-Control", f.CacheControl)
+	}
+	// handles HEAD, Range and conditional requests with ETag and Last-Modified
+	http.ServeContent(w, r, path.Base(name), contentInfo.ModTime(), content)
 }
 
-// EndpointRedirect is an endpoint function that sends a redirect response
-type EndpointRedirect struct {
-	URL string
+// Redirect to another escaped path of the same request, keeping the query
+func redirectToPath(w http.ResponseWriter, r *http.Request, path string) {
+	// a path starting with '//' would be read as a url of another host
+	path = "/" + strings.TrimLeft(path, "/")
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, path, http.StatusMovedPermanently)
 }
 
-func (f *EndpointRedirect) Serve(w http.ResponseWriter, r *http.Request, localPath string) {
-	http.Redirect(w, r, f.URL, http.StatusMovedPermanently)
+// Strong ETag from the modification time and size of a file. It changes whenever the file is
+// replaced or written to, without reading the contents
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
 }
 
 type EndpointProxy struct {
-	Proto string
-	Host  string
-	Port  string
-	Path  string
+	Proto string `json:"proto"`
+	Host  string `json:"host"`
+	Path  string `json:"path"`
+	// Servers that requests are balanced between, Proto and Host are not used if it is set
+	Upstream *UpstreamPool `json:"upstream,omitempty"`
+	// Send the Host of the request instead of the host of the upstream
+	PreserveHost bool `json:"preserve_host"`
+	// Policy for forwarded headers of the request, ForwardedReplace if empty
+	Forwarded string `json:"forwarded,omitempty"`
+	// Clients whose forwarded headers are kept with ForwardedTrusted
+	TrustedProxies []netip.Prefix `json:"trusted_proxies,omitempty"`
+	ProxyTransport
+	ProxyRewrite
+	// Time the upstream has to respond including retries, requests that run out of it are
+	// answered with 504. No limit if zero
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Policy for sending failed requests again, requests are not retried if it is nil
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Breaker that rejects requests while the upstream keeps failing, nil if there is none
+	CircuitBreaker *CircuitBreaker `json:"circuit_breaker,omitempty"`
+	// Time after which written parts of the body are sent to the client, FlushImmediately sends
+	// them right away. Event streams are always sent right away. If zero, the body is sent as
+	// buffers fill up
+	FlushInterval time.Duration `json:"flush_interval,omitempty"`
+	// Time an upgraded connection like a WebSocket is kept open without data,
+	// DefaultUpgradeIdleTimeout if zero. Negative values disable the timeout
+	UpgradeIdleTimeout time.Duration `json:"upgrade_idle_timeout,omitempty"`
+
+	mu       sync.Mutex
+	pool     *proxyPool
+	closed   bool
+	counters proxyCounters
+}
+
+// Init creates the pool of connections to the upstream, it is used until the config is
+// replaced. It is called when the config is read so that the settings of the connections are
+// checked before the proxy is used, proxies that weren't initialized create it on the first
+// request
+func (f *EndpointProxy) Init() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.init()
+}
+
+func (f *EndpointProxy) init() error {
+	if f.pool != nil {
+		return nil
+	}
+	settings := f.ProxyTransport
+	if f.Upstream != nil && f.Upstream.TLS != nil {
+		settings.TLS = f.Upstream.TLS
+	}
+	pool, err := newProxyPool(settings)
+	if err != nil {
+		return err
+	}
+	f.pool = pool
+	if f.closed {
+		// requests that were routed before the config was replaced still get through
+		f.pool.retire()
+	}
+	return nil
+}
+
+// Connections to the upstream
+func (f *EndpointProxy) connections() (*proxyPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+	return f.pool, nil
+}
+
+// Close the connections to the upstream once the requests using them finish. Proxies that
+// weren't initialized have none
+func (f *EndpointProxy) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.pool != nil {
+		f.pool.retire()
+	}
+	return nil
 }
 
 func (f *EndpointProxy) Serve(w http.ResponseWriter, r *http.Request, localPath string) {
-	client := &http.Client{}
+	f.counters.requests.Add(1)
+	if f.CircuitBreaker != nil && !f.CircuitBreaker.allow() {
+		f.counters.rejected.Add(1)
+		respondWithStatus(w, r, http.StatusServiceUnavailable)
+		return
+	}
+	pool, err := f.connections()
+	if err != nil {
+		log.Printf("EndpointProxy: error loading TLS settings: %v", err)
+		respondWithStatus(w, r, http.StatusBadGateway)
+		return
+	}
+	pool.acquire()
+	defer pool.release()
 
-	r.RequestURI = ""
+	if r.Body != nil && r.ContentLength != 0 && r.ProtoMajor == 1 {
+		// the body is streamed to the upstream as the client sends it, the upstream can respond
+		// before it is finished
+		http.NewResponseController(w).EnableFullDuplex()
+	}
+
+	// the whole exchange until the response headers, retries included, is bounded by the timeout
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var timer *time.Timer
+	if f.Timeout > 0 {
+		timer = time.AfterFunc(f.Timeout, cancel)
+	}
 
 	// url.JoinPath removes the trailing slash if it was present
 	path := f.Path
@@ -107,33 +447,133 @@ func (f *EndpointProxy) Serve(w http.ResponseWriter, r *http.Request, localPath
 			path = strings.Join([]string{path, localPath}, "")
 		}
 	}
-	r.URL = &url.URL{
-		Scheme:   f.Proto,
-		User:     r.URL.User,
-		Host:     f.Host,
-		Path:     path,
-		RawQuery: r.URL.RawQuery,
-		Fragment: r.URL.Fragment,
-	}
-	log.Printf("Proxy request to url '%s'", r.URL.String())
+	var upstream *Upstream
+	defer func() {
+		if upstream != nil {
+			upstream.done()
+		}
+	}()
+	var tried []*Upstream
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		// the incoming request is kept as is for error responses
+		out := r.Clone(ctx)
+		out.RequestURI = ""
+		if r.ContentLength == 0 {
+			// the transport doesn't wait for a body that isn't there
+			out.Body = nil
+		}
+		// values of trailers are filled in when the body is read to the end
+		out.Trailer = r.Trailer
+		f.setRequestHeaders(out, r)
+		f.RequestHeaders.apply(out.Header)
+
+		proto, host := f.Proto, f.Host
+		if f.Upstream != nil {
+			upstream = f.Upstream.pick(r, tried...)
+			if upstream == nil {
+				log.Printf("EndpointProxy.Serve: no available servers in pool '%s'", f.Upstream.Name)
+				f.counters.rejected.Add(1)
+				f.recordBreaker(errNoUpstream)
+				respondWithStatus(w, r, http.StatusServiceUnavailable)
+				return
+			}
+			proto, host = upstream.Proto, upstream.Host
+		}
+		scheme, urlHost, outCtx := upstreamURL(out.Context(), proto, host)
+		out = out.WithContext(outCtx)
+		out.URL = &url.URL{
+			Scheme:   scheme,
+			User:     r.URL.User,
+			Host:     urlHost,
+			Path:     path,
+			RawQuery: r.URL.RawQuery,
+			Fragment: r.URL.Fragment,
+		}
+		if proto == "unix" {
+			log.Printf("Proxy request to socket '%s' path '%s'", host, path)
+		} else {
+			log.Printf("Proxy request to url '%s'", out.URL.String())
+		}
 
-	resp, err := client.Do(r)
+		resp, err = pool.roundTrip(out)
+		// requests canceled by the client say nothing about the server
+		if upstream != nil && r.Context().Err() == nil {
+			f.Upstream.report(upstream, upstreamError(resp, err))
+		}
+		if !f.Retry.retry(out, attempt, resp, err) {
+			break
+		}
+		if err != nil {
+			log.Printf("EndpointProxy.Serve: retrying after error connecting to remote: %v", err)
+		} else {
+			log.Printf("EndpointProxy.Serve: retrying after response %s", resp.Status)
+			resp.Body.Close()
+		}
+		if upstream != nil {
+			// the retry goes to another server if the pool has one
+			upstream.done()
+			tried = append(tried, upstream)
+			upstream = nil
+		}
+		f.counters.retries.Add(1)
+		if !f.Retry.wait(ctx, attempt) {
+			resp, err = nil, ctx.Err()
+			break
+		}
+	}
+	if timer != nil && !timer.Stop() {
+		// the timeout canceled the request, or came right after the response
+		if err == nil {
+			resp.Body.Close()
+		}
+		resp, err = nil, timeoutError("timeout awaiting response from upstream")
+	}
+	if r.Context().Err() != nil {
+		// the client is gone, there is no one to respond to and nothing is known of the upstream
+		if f.CircuitBreaker != nil {
+			f.CircuitBreaker.abandon()
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		return
+	}
+	f.recordBreaker(upstreamError(resp, err))
 	if err != nil {
+		if isTimeout(err) {
+			log.Printf("EndpointProxy.Serve: timeout waiting for remote: %v", err)
+			f.counters.timeouts.Add(1)
+			respondWithStatus(w, r, http.StatusGatewayTimeout)
+			return
+		}
 		log.Printf("EndpointProxy.Serve: error connecting to remote: %v", err)
-		respondWithError(w, r)
+		f.counters.errors.Add(1)
+		respondWithStatus(w, r, http.StatusBadGateway)
+		return
+	}
+	f.rewriteResponse(resp, r, localPath)
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		f.serveUpgrade(w, r, pool, resp)
 		return
 	}
 	defer resp.Body.Close()
 
-	head := w.Header()
-	for k, vs := range resp.Header {
-		for _, v := range vs {
-			head.Add(k, v)
-		}
-	}
-	w.WriteHeader(resp.StatusCode)
-	_, err = io.Copy(w, resp.Body)
+	err = f.copyResponse(w, resp)
 	if err != nil {
 		log.Printf("EndpointProxy.Serve: error in proxy transfer: %v", err)
+		// writers that keep the response, like the one of a cache, must not take it as whole
+		if aw, ok := w.(interface{ abort() }); ok {
+			aw.abort()
+		}
+	}
+}
+
+var errNoUpstream = errors.New("no available servers")
+
+// Record the result of the request in the circuit breaker if there is one
+func (f *EndpointProxy) recordBreaker(err error) {
+	if f.CircuitBreaker != nil {
+		f.CircuitBreaker.record(err)
 	}
 }
@@ -0,0 +1,275 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEndpointFiles_Serve(t *testing.T) {
+	dir := t.TempDir()
+	content := "0123456789abcdef"
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "file.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	ep := &EndpointFiles{Source: dir, CacheControl: "public, max-age=60"}
+
+	// ETag is taken from the first response so the test doesn't depend on its format
+	first := httptest.NewRecorder()
+	ep.Serve(first, httptest.NewRequest(http.MethodGet, "/file.txt", nil), "file.txt")
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Serve() sent no ETag")
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     map[string]string
+		wantStatus int
+		wantBody   string
+		wantHeader map[string]string
+	}{
+		{
+			name:       "get",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+			wantHeader: map[string]string{
+				"Content-Length": "16",
+				"Content-Type":   "text/plain; charset=utf-8",
+				"Last-Modified":  "Tue, 02 Jan 2024 03:04:05 GMT",
+				"Cache-Control":  "public, max-age=60",
+				"Accept-Ranges":  "bytes",
+			},
+		}, {
+			name:       "head",
+			method:     http.MethodHead,
+			path:       "file.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "",
+			wantHeader: map[string]string{"Content-Length": "16"},
+		}, {
+			name:       "range",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"Range": "bytes=4-7"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "4567",
+			wantHeader: map[string]string{"Content-Range": "bytes 4-7/16", "Content-Length": "4"},
+		}, {
+			name:       "range_suffix",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"Range": "bytes=-3"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "def",
+		}, {
+			name:       "range_unsatisfiable",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"Range": "bytes=100-"},
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+		}, {
+			name:       "if_range_match",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"Range": "bytes=0-1", "If-Range": etag},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "01",
+		}, {
+			name:       "if_range_changed",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"Range": "bytes=0-1", "If-Range": `"old"`},
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		}, {
+			name:       "if_none_match",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"If-None-Match": etag},
+			wantStatus: http.StatusNotModified,
+			wantHeader: map[string]string{"ETag": etag},
+		}, {
+			name:       "if_none_match_changed",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"If-None-Match": `"old"`},
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		}, {
+			name:       "if_modified_since",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"If-Modified-Since": "Tue, 02 Jan 2024 03:04:05 GMT"},
+			wantStatus: http.StatusNotModified,
+		}, {
+			name:       "if_modified_since_older",
+			method:     http.MethodGet,
+			path:       "file.txt",
+			header:     map[string]string{"If-Modified-Since": "Mon, 01 Jan 2024 00:00:00 GMT"},
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		}, {
+			name:       "directory",
+			method:     http.MethodGet,
+			path:       "sub/",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "outside_root",
+			method:     http.MethodGet,
+			path:       "../file.txt",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "missing",
+			method:     http.MethodGet,
+			path:       "missing.txt",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/"+tt.path, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			ep.Serve(w, r, tt.path)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Serve() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" || tt.method == http.MethodHead {
+				if got := w.Body.String(); got != tt.wantBody {
+					t.Errorf("Serve() body = %q, want %q", got, tt.wantBody)
+				}
+			}
+			for k, v := range tt.wantHeader {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("Serve() header %s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestEndpointFiles_IndexFallback(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"index.html":     "app",
+		"docs/index.htm": "docs",
+		"assets/app.js":  "js",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		ep         *EndpointFiles
+		url        string
+		accept     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "default_index",
+			ep:         &EndpointFiles{Source: dir},
+			url:        "/",
+			wantStatus: http.StatusOK,
+			wantBody:   "app",
+		}, {
+			name:       "default_index_subdir",
+			ep:         &EndpointFiles{Source: dir},
+			url:        "/docs/",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "index_list",
+			ep:         &EndpointFiles{Source: dir, Index: []string{"index.html", "index.htm"}},
+			url:        "/docs/",
+			wantStatus: http.StatusOK,
+			wantBody:   "docs",
+		}, {
+			name:       "index_disabled",
+			ep:         &EndpointFiles{Source: dir, Index: []string{}},
+			url:        "/",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "fallback_route",
+			ep:         &EndpointFiles{Source: dir, Fallback: "index.html"},
+			url:        "/users/42",
+			wantStatus: http.StatusOK,
+			wantBody:   "app",
+		}, {
+			name:       "fallback_navigation",
+			ep:         &EndpointFiles{Source: dir, Fallback: "index.html"},
+			url:        "/users/john.doe",
+			accept:     "text/html,application/xhtml+xml,*/*;q=0.8",
+			wantStatus: http.StatusOK,
+			wantBody:   "app",
+		}, {
+			name:       "fallback_directory",
+			ep:         &EndpointFiles{Source: dir, Fallback: "index.html"},
+			url:        "/assets/",
+			wantStatus: http.StatusOK,
+			wantBody:   "app",
+		}, {
+			name:       "missing_asset",
+			ep:         &EndpointFiles{Source: dir, Fallback: "index.html"},
+			url:        "/assets/main.css",
+			accept:     "text/css,*/*;q=0.1",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "existing_asset",
+			ep:         &EndpointFiles{Source: dir, Fallback: "index.html"},
+			url:        "/assets/app.js",
+			wantStatus: http.StatusOK,
+			wantBody:   "js",
+		}, {
+			name:       "missing_fallback",
+			ep:         &EndpointFiles{Source: dir, Fallback: "missing.html"},
+			url:        "/users/42",
+			wantStatus: http.StatusNotFound,
+		}, {
+			name:       "outside_root",
+			ep:         &EndpointFiles{Source: dir, Fallback: "index.html"},
+			url:        "/../secret",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.URL.Path = tt.url
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			tt.ep.Serve(w, r, tt.url)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Serve() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("Serve() body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
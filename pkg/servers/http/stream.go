@@ -0,0 +1,120 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Flush interval of proxies that sends every write to the client right away
+const FlushImmediately time.Duration = -1
+
+// Send the status, headers, body and trailers of the upstream response to the client. The body
+// is flushed on the interval of the proxy so that streams don't wait in buffers
+func (f *EndpointProxy) copyResponse(w http.ResponseWriter, resp *http.Response) error {
+	setResponseHeaders(w.Header(), resp)
+	// trailers are removed with hop headers, the ones the upstream announced are announced again
+	announced := len(resp.Trailer)
+	if announced > 0 {
+		names := make([]string, 0, announced)
+		for name := range resp.Trailer {
+			names = append(names, name)
+		}
+		w.Header().Add("Trailer", strings.Join(names, ", "))
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	var dst io.Writer = w
+	if interval := f.flushInterval(resp); interval != 0 {
+		fw := &flushWriter{w: w, flush: http.NewResponseController(w).Flush, interval: interval}
+		defer fw.stop()
+		dst = fw
+		if interval == FlushImmediately {
+			// headers of a stream are sent before the first event comes
+			fw.flush()
+		}
+	}
+	_, err := io.Copy(dst, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// values of trailers are known once the body is read
+	if len(resp.Trailer) == announced {
+		for name, values := range resp.Trailer {
+			w.Header()[name] = values
+		}
+		return nil
+	}
+	// the upstream sent trailers it didn't announce
+	for name, values := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+name] = values
+	}
+	return nil
+}
+
+// Interval of flushes of the response body, event streams are always flushed right away
+func (f *EndpointProxy) flushInterval(resp *http.Response) time.Duration {
+	if media, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); media == "text/event-stream" {
+		return FlushImmediately
+	}
+	return f.FlushInterval
+}
+
+// Writer that flushes what was written after the interval, or after every write if it is
+// negative
+type flushWriter struct {
+	w        io.Writer
+	flush    func() error
+	interval time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending bool
+}
+
+func (fw *flushWriter) Write(p []byte) (n int, err error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	n, err = fw.w.Write(p)
+	if err != nil {
+		return
+	}
+	if fw.interval < 0 {
+		fw.flush()
+		return
+	}
+	if fw.pending {
+		return
+	}
+	fw.pending = true
+	if fw.timer == nil {
+		fw.timer = time.AfterFunc(fw.interval, fw.delayedFlush)
+	} else {
+		fw.timer.Reset(fw.interval)
+	}
+	return
+}
+
+func (fw *flushWriter) delayedFlush() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if !fw.pending {
+		return
+	}
+	fw.flush()
+	fw.pending = false
+}
+
+// Stop the delayed flush, the rest of the body is sent when the response is finished
+func (fw *flushWriter) stop() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.pending = false
+	if fw.timer != nil {
+		fw.timer.Stop()
+	}
+}
@@ -0,0 +1,146 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Server that serves requests with the proxy
+func serveProxy(proxy *EndpointProxy) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.Serve(w, r, "")
+	}))
+}
+
+func TestEndpointProxy_Flush(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentType   string
+		flushInterval time.Duration
+		wantStreamed  bool
+	}{
+		{name: "event_stream", contentType: "text/event-stream; charset=utf-8", wantStreamed: true},
+		{name: "immediate", contentType: "application/x-ndjson", flushInterval: FlushImmediately, wantStreamed: true},
+		{name: "interval", contentType: "application/x-ndjson", flushInterval: 20 * time.Millisecond, wantStreamed: true},
+		{name: "buffered", contentType: "application/x-ndjson", wantStreamed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte("data: 1\n\n"))
+				http.NewResponseController(w).Flush()
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+				w.Write([]byte("data: 2\n\n"))
+			}))
+			defer upstream.Close()
+			proxy := proxyTo(upstream, ProxyTransport{})
+			proxy.FlushInterval = tt.flushInterval
+			defer proxy.Close()
+			server := serveProxy(proxy)
+			defer server.Close()
+
+			got := make(chan string, 1)
+			go func() {
+				resp, err := http.Get(server.URL)
+				if err != nil {
+					got <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+				got <- line
+			}()
+			select {
+			case line := <-got:
+				if !tt.wantStreamed || line != "data: 1\n" {
+					t.Errorf("first line = %q before the upstream finished, streamed %v", line, tt.wantStreamed)
+				}
+			case <-time.After(300 * time.Millisecond):
+				if tt.wantStreamed {
+					t.Error("first event didn't arrive before the upstream finished")
+				}
+			}
+			close(release)
+		})
+	}
+}
+
+func TestEndpointProxy_Trailers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("body"))
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "late")
+	}))
+	defer upstream.Close()
+	proxy := proxyTo(upstream, ProxyTransport{})
+	defer proxy.Close()
+	server := serveProxy(proxy)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	// trailers the upstream didn't announce are passed as well
+	want := http.Header{"X-Checksum": {"abc"}, "X-Late": {"late"}}
+	if !reflect.DeepEqual(resp.Trailer, want) {
+		t.Errorf("Trailer = %v, want %v", resp.Trailer, want)
+	}
+}
+
+// The request body reaches the upstream as the client sends it, and the response can start
+// before it is finished
+func TestEndpointProxy_StreamRequest(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).EnableFullDuplex()
+		br := bufio.NewReader(r.Body)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			w.Write([]byte("got " + line))
+			http.NewResponseController(w).Flush()
+		}
+	}))
+	defer upstream.Close()
+	proxy := proxyTo(upstream, ProxyTransport{})
+	proxy.FlushInterval = FlushImmediately
+	defer proxy.Close()
+	server := serveProxy(proxy)
+	defer server.Close()
+
+	body, send := io.Pipe()
+	defer send.Close()
+	timer := time.AfterFunc(2*time.Second, func() { send.CloseWithError(io.ErrUnexpectedEOF) })
+	defer timer.Stop()
+	// the upstream responds once it reads the first line
+	go send.Write([]byte("first\n"))
+	req, _ := http.NewRequest(http.MethodPost, server.URL, body)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	if line, err := br.ReadString('\n'); err != nil || line != "got first\n" {
+		t.Fatalf("read %q, %v, want %q", line, err, "got first\n")
+	}
+	go send.Write([]byte("second\n"))
+	if line, err := br.ReadString('\n'); err != nil || line != "got second\n" {
+		t.Fatalf("read %q, %v, want %q", line, err, "got second\n")
+	}
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// ProxyTLS are the settings of connections to https upstreams. Files are read by Load when the
+// config is read
+type ProxyTLS struct {
+	// PEM file of the authorities that certificates of upstreams are checked against, the
+	// system ones if empty
+	CA string `json:"ca,omitempty"`
+	// Name sent with SNI and checked in the certificate, the host of the upstream if empty
+	ServerName string `json:"server_name,omitempty"`
+	// PEM files of the certificate and key sent to upstreams that ask for one
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+
+	loaded *tls.Config
+}
+
+// Load reads the files of the settings, so that a missing or broken file is an error of the
+// config rather than of every proxied request
+func (t *ProxyTLS) Load() error {
+	config := &tls.Config{ServerName: t.ServerName, MinVersion: tls.VersionTLS12}
+	if t.CA != "" {
+		pem, err := os.ReadFile(t.CA)
+		if err != nil {
+			return err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in CA file '%s'", t.CA)
+		}
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	t.loaded = config
+	return nil
+}
+
+// Config of the connections, nil if there are no settings. Settings made without Load are
+// loaded here
+func (t *ProxyTLS) config() (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+	if t.loaded == nil {
+		if err := t.Load(); err != nil {
+			return nil, err
+		}
+	}
+	return t.loaded, nil
+}
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write the blocks to a PEM file in the directory
+func writePEM(t *testing.T, dir string, name string, blocks ...*pem.Block) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, pem.EncodeToMemory(b)...)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Self-signed client certificate and its key
+func newClientCert(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "switchman test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestEndpointProxy_TLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, clientKey := newClientCert(t)
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writePEM(t, dir, "client.pem", &pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Raw})
+	keyFile := writePEM(t, dir, "client-key.pem", &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.ServerName + " " + r.Proto))
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+	caFile := writePEM(t, dir, "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+
+	mtls := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mtls.StartTLS()
+	defer mtls.Close()
+	mtlsCA := writePEM(t, dir, "mtls-ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: mtls.Certificate().Raw})
+
+	tests := []struct {
+		name       string
+		server     *httptest.Server
+		settings   ProxyTransport
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "ca",
+			server:     upstream,
+			settings:   ProxyTransport{TLS: &ProxyTLS{CA: caFile}},
+			wantStatus: http.StatusOK,
+			wantBody:   " HTTP/1.1",
+		}, {
+			name:       "unknown_ca",
+			server:     upstream,
+			wantStatus: http.StatusBadGateway,
+		}, {
+			name:       "server_name",
+			server:     upstream,
+			settings:   ProxyTransport{TLS: &ProxyTLS{CA: caFile, ServerName: "example.com"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "example.com HTTP/1.1",
+		}, {
+			name:       "server_name_mismatch",
+			server:     upstream,
+			settings:   ProxyTransport{TLS: &ProxyTLS{CA: caFile, ServerName: "other.example.org"}},
+			wantStatus: http.StatusBadGateway,
+		}, {
+			name:       "http2",
+			server:     upstream,
+			settings:   ProxyTransport{HTTP2: true, TLS: &ProxyTLS{CA: caFile}},
+			wantStatus: http.StatusOK,
+			wantBody:   " HTTP/2.0",
+		}, {
+			name:       "client_cert",
+			server:     mtls,
+			settings:   ProxyTransport{TLS: &ProxyTLS{CA: mtlsCA, Cert: certFile, Key: keyFile}},
+			wantStatus: http.StatusOK,
+			wantBody:   "switchman test client",
+		}, {
+			name:       "client_cert_missing",
+			server:     mtls,
+			settings:   ProxyTransport{TLS: &ProxyTLS{CA: mtlsCA}},
+			wantStatus: http.StatusBadGateway,
+		}, {
+			name:       "ca_file_missing",
+			server:     upstream,
+			settings:   ProxyTransport{TLS: &ProxyTLS{CA: filepath.Join(dir, "missing.pem")}},
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.server.URL)
+			proxy := &EndpointProxy{Proto: "https", Host: u.Host, Path: "/", ProxyTransport: tt.settings}
+			defer proxy.Close()
+			w := httptest.NewRecorder()
+			proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "")
+			if w.Code != tt.wantStatus || tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("Serve() = %v %q, want %v %q", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestEndpointProxy_UnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	var sockets []string
+	for _, name := range []string{"a", "b"} {
+		name := name
+		socket := filepath.Join(dir, name+".sock")
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Skipf("unix sockets are not supported: %v", err)
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.Host + " " + r.URL.Path))
+		})}
+		go server.Serve(l)
+		defer server.Close()
+		sockets = append(sockets, socket)
+	}
+
+	proxy := &EndpointProxy{Proto: "unix", Host: sockets[0], Path: "/api/"}
+	defer proxy.Close()
+	w := httptest.NewRecorder()
+	proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/items", nil), "items")
+	if want := "a localhost /api/items"; w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("Serve() = %v %q, want 200 %q", w.Code, w.Body.String(), want)
+	}
+
+	// each socket of a pool gets its own connections
+	pool := &UpstreamPool{Name: "test", Servers: []*Upstream{
+		{Proto: "unix", Host: sockets[0]},
+		{Proto: "unix", Host: sockets[1]},
+	}}
+	balanced := &EndpointProxy{Path: "/", Upstream: pool, PreserveHost: true}
+	defer balanced.Close()
+	var got []string
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		balanced.Serve(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil), "")
+		got = append(got, w.Body.String())
+	}
+	want := []string{"a example.com /", "b example.com /", "a example.com /"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Serve() = %q, want %q", got, want)
+		}
+	}
+	if sockets := len(balanced.pool.sockets); sockets != 2 {
+		t.Errorf("pool has %d socket transports, want 2", sockets)
+	}
+}
@@ -0,0 +1,275 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Defaults of the connection settings of proxies
+const (
+	DefaultProxyKeepalive   = 32
+	DefaultProxyIdleTimeout = 90 * time.Second
+	DefaultProxyDialTimeout = 30 * time.Second
+)
+
+// Connection settings of a proxy, they are shared by all requests to the same endpoint
+type ProxyTransport struct {
+	// Idle connections kept open to the upstream, DefaultProxyKeepalive if zero. Negative
+	// values disable keepalive so that a connection is opened for every request
+	Keepalive int `json:"keepalive,omitempty"`
+	// Time an idle connection is kept open, DefaultProxyIdleTimeout if zero
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+	// Time to open a connection to the upstream, DefaultProxyDialTimeout if zero
+	DialTimeout time.Duration `json:"dial_timeout,omitempty"`
+	// Time to wait for the headers of the response after the request is sent, no limit if zero
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout,omitempty"`
+	// Talk to the upstream with HTTP/2, it must support it without an upgrade from HTTP/1.1.
+	// All requests share one connection so keepalive doesn't apply. Upstreams with https
+	// negotiate it and fall back to HTTP/1.1
+	HTTP2 bool `json:"http2,omitempty"`
+	// Settings of https connections, nil for the defaults
+	TLS *ProxyTLS `json:"tls,omitempty"`
+}
+
+// Pool of connections to the upstream of a proxy. It lives as long as the config it was created
+// with and is retired when the config is replaced: connections are closed once the requests
+// that were being proxied finish
+type proxyPool struct {
+	// Connections over the network
+	net *poolTransport
+	// Makes the transport of a unix socket
+	newSocket func(socket string) *poolTransport
+	// Only set for HTTP/2 without TLS, the transport has its own timeout
+	responseHeaderTimeout time.Duration
+
+	mu      sync.Mutex
+	active  int
+	retired bool
+	// transports of unix sockets by their paths, made on the first request to each socket
+	sockets map[string]*poolTransport
+	// upgraded connections, they are closed when the pool is retired
+	tunnels map[*tunnel]struct{}
+}
+
+// Transports with the connections to a single destination
+type poolTransport struct {
+	// HTTP/1.1 connections, and HTTP/2 ones over TLS if it is enabled
+	http *http.Transport
+	// HTTP/2 connections without TLS, nil if HTTP/2 is not enabled. Upgrades are done over
+	// HTTP/1.1 since HTTP/2 has none
+	h2c *http2.Transport
+}
+
+func newProxyPool(settings ProxyTransport) (*proxyPool, error) {
+	dialTimeout := settings.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = DefaultProxyDialTimeout
+	}
+	idleTimeout := settings.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = DefaultProxyIdleTimeout
+	}
+	keepalive := settings.Keepalive
+	if keepalive == 0 {
+		keepalive = DefaultProxyKeepalive
+	}
+	tlsConfig, err := settings.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+	newTransport := func(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *poolTransport {
+		t := &poolTransport{http: &http.Transport{
+			DialContext:           dial,
+			TLSClientConfig:       tlsConfig,
+			ForceAttemptHTTP2:     settings.HTTP2,
+			DisableKeepAlives:     keepalive < 0,
+			MaxIdleConns:          keepalive,
+			MaxIdleConnsPerHost:   keepalive,
+			IdleConnTimeout:       idleTimeout,
+			ResponseHeaderTimeout: settings.ResponseHeaderTimeout,
+			ExpectContinueTimeout: time.Second,
+			// the body is passed to the client as is, compression is done by the endpoint
+			DisableCompression: true,
+		}}
+		if settings.HTTP2 {
+			t.h2c = &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dial(ctx, network, addr)
+				},
+				IdleConnTimeout:    idleTimeout,
+				DisableCompression: true,
+			}
+		}
+		return t
+	}
+	d := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	p := &proxyPool{
+		net: newTransport(d.DialContext),
+		newSocket: func(socket string) *poolTransport {
+			return newTransport(func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", socket)
+			})
+		},
+	}
+	if settings.HTTP2 {
+		p.responseHeaderTimeout = settings.ResponseHeaderTimeout
+	}
+	return p, nil
+}
+
+// Transport of the destination of the request, the unix socket if it has one
+func (p *proxyPool) transport(r *http.Request) *poolTransport {
+	socket, ok := r.Context().Value(socketKey{}).(string)
+	if !ok {
+		return p.net
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.sockets[socket]
+	if !ok {
+		if p.sockets == nil {
+			p.sockets = map[string]*poolTransport{}
+		}
+		t = p.newSocket(socket)
+		p.sockets[socket] = t
+	}
+	return t
+}
+
+// Send the request to the upstream, the caller must close the body of the response
+func (p *proxyPool) roundTrip(r *http.Request) (*http.Response, error) {
+	t := p.transport(r)
+	if t.h2c == nil || r.URL.Scheme != "http" || upgradeType(r.Header) != "" {
+		return t.http.RoundTrip(r)
+	}
+	if p.responseHeaderTimeout == 0 {
+		return t.h2c.RoundTrip(r)
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	timer := time.AfterFunc(p.responseHeaderTimeout, cancel)
+	resp, err := t.h2c.RoundTrip(r.WithContext(ctx))
+	if !timer.Stop() {
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil, errResponseHeaderTimeout
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+var errResponseHeaderTimeout error = timeoutError("timeout awaiting response headers")
+
+// Error of a request whose response didn't come in time, it is a net.Error like the timeouts of
+// http.Transport
+type timeoutError string
+
+func (e timeoutError) Error() string   { return string(e) }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+// Body of a response that releases the context of its request when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Mark a request as using the pool, release must be called when it is finished
+func (p *proxyPool) acquire() {
+	p.mu.Lock()
+	p.active++
+	p.mu.Unlock()
+}
+
+func (p *proxyPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+	if p.retired && p.active == 0 {
+		p.closeIdle()
+	}
+}
+
+// Close idle connections now and the rest when the requests using them finish. Upgraded
+// connections don't finish on their own, they are closed right away
+func (p *proxyPool) retire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retired = true
+	if len(p.tunnels) > 0 {
+		log.Printf("Closing %d upgraded proxy connections of the replaced config", len(p.tunnels))
+	}
+	for t := range p.tunnels {
+		t.Close()
+	}
+	if p.active == 0 {
+		p.closeIdle()
+	}
+}
+
+// Keep the upgraded connection to close it when the pool is retired, false if it already is
+func (p *proxyPool) track(t *tunnel) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.retired {
+		return false
+	}
+	if p.tunnels == nil {
+		p.tunnels = map[*tunnel]struct{}{}
+	}
+	p.tunnels[t] = struct{}{}
+	return true
+}
+
+func (p *proxyPool) untrack(t *tunnel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tunnels, t)
+}
+
+// The lock must be held
+func (p *proxyPool) closeIdle() {
+	p.net.closeIdle()
+	for _, t := range p.sockets {
+		t.closeIdle()
+	}
+}
+
+func (t *poolTransport) closeIdle() {
+	t.http.CloseIdleConnections()
+	if t.h2c != nil {
+		t.h2c.CloseIdleConnections()
+	}
+}
+
+type socketKey struct{}
+
+// Scheme and host of the url of a request to the upstream, and the context of the request.
+// Requests to unix sockets are sent to localhost with the path of the socket in the context,
+// the pool dials it with a transport of its own
+func upstreamURL(ctx context.Context, proto string, host string) (string, string, context.Context) {
+	if proto == "unix" {
+		return "http", "localhost", context.WithValue(ctx, socketKey{}, host)
+	}
+	return proto, host, ctx
+}
@@ -0,0 +1,225 @@
+package http
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Upstream that counts the connections opened to it
+func newCountingServer(handler http.Handler) (*httptest.Server, *atomic.Int32) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	return server, &conns
+}
+
+func proxyTo(server *httptest.Server, settings ProxyTransport) *EndpointProxy {
+	u, _ := url.Parse(server.URL)
+	return &EndpointProxy{Proto: "http", Host: u.Host, Path: "/", ProxyTransport: settings}
+}
+
+func TestEndpointProxy_Connections(t *testing.T) {
+	tests := []struct {
+		name      string
+		settings  ProxyTransport
+		wantConns int32
+	}{
+		{name: "default", wantConns: 1},
+		{name: "keepalive_off", settings: ProxyTransport{Keepalive: -1}, wantConns: 10},
+		{name: "http2", settings: ProxyTransport{HTTP2: true}, wantConns: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, conns := newCountingServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Proto))
+			}), &http2.Server{}))
+			defer upstream.Close()
+			proxy := proxyTo(upstream, tt.settings)
+			defer proxy.Close()
+
+			wantProto := "HTTP/1.1"
+			if tt.settings.HTTP2 {
+				wantProto = "HTTP/2.0"
+			}
+			for i := 0; i < 10; i++ {
+				w := httptest.NewRecorder()
+				proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "")
+				if w.Code != http.StatusOK || w.Body.String() != wantProto {
+					t.Fatalf("Serve() = %v %q, want 200 %q", w.Code, w.Body.String(), wantProto)
+				}
+			}
+			if got := conns.Load(); got != tt.wantConns {
+				t.Errorf("connections = %v, want %v", got, tt.wantConns)
+			}
+		})
+	}
+}
+
+func TestEndpointProxy_ResponseHeaderTimeout(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		upstream := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}), &http2.Server{}))
+		proxy := proxyTo(upstream, ProxyTransport{ResponseHeaderTimeout: 50 * time.Millisecond, HTTP2: h2})
+
+		start := time.Now()
+		w := httptest.NewRecorder()
+		proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "")
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("Serve() http2 %v status = %v, want %v", h2, w.Code, http.StatusGatewayTimeout)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("Serve() http2 %v took %v", h2, elapsed)
+		}
+		proxy.Close()
+		upstream.Close()
+	}
+}
+
+// Connections of a replaced config are closed after the requests using them finish
+func TestEndpointProxy_Close(t *testing.T) {
+	release := make(chan struct{})
+	closed := make(chan struct{}, 1)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	upstream.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+	server := &Server{Endpoints: []Endpoint{{Location: "/", Function: proxyTo(upstream, ProxyTransport{})}}}
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		done <- w.Code
+	}()
+	// wait for the request to reach the upstream
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+
+	select {
+	case <-closed:
+		t.Fatal("connection closed while a request was using it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("ServeHTTP() status = %v, want %v", code, http.StatusOK)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("connection was not closed after the request finished")
+	}
+}
+
+// Proxies that got no requests have no connections to close, requests that come after Close
+// still get through on a retired pool
+func TestEndpointProxy_CloseUnused(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	proxy := proxyTo(upstream, ProxyTransport{})
+	proxy.Close()
+	if proxy.pool != nil {
+		t.Fatal("Close() created a pool")
+	}
+
+	w := httptest.NewRecorder()
+	proxy.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "")
+	if w.Code != http.StatusOK {
+		t.Errorf("Serve() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if !proxy.pool.retired {
+		t.Error("pool created after Close() is not retired")
+	}
+}
+
+func TestEndpointProxy_Init(t *testing.T) {
+	proxy := &EndpointProxy{Proto: "https", Host: "localhost:443", Path: "/"}
+	if err := proxy.Init(); err != nil || proxy.pool == nil {
+		t.Fatalf("Init() error = %v, pool = %v", err, proxy.pool)
+	}
+	pool := proxy.pool
+	proxy.Init()
+	if proxy.pool != pool {
+		t.Error("Init() replaced the pool")
+	}
+
+	missing := &EndpointProxy{Proto: "https", Host: "localhost:443", Path: "/", ProxyTransport: ProxyTransport{
+		TLS: &ProxyTLS{CA: filepath.Join(t.TempDir(), "missing.pem")},
+	}}
+	if err := missing.Init(); err == nil {
+		t.Error("Init() with a missing CA file succeeded")
+	}
+}
+
+// Compares a pool kept for the endpoint with a transport created for every request, as proxies
+// did before. Connections opened per request are reported as conns/op
+func BenchmarkEndpointProxy(b *testing.B) {
+	upstream, conns := newCountingServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	run := func(b *testing.B, proxy func() *EndpointProxy) {
+		conns.Store(0)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			p := proxy()
+			w := httptest.NewRecorder()
+			p.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "")
+			if w.Code != http.StatusOK {
+				b.Fatalf("Serve() status = %v", w.Code)
+			}
+		}
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+	}
+	b.Run("pooled", func(b *testing.B) {
+		pooled := proxyTo(upstream, ProxyTransport{})
+		defer pooled.Close()
+		run(b, func() *EndpointProxy { return pooled })
+	})
+	b.Run("per_request", func(b *testing.B) {
+		var last *EndpointProxy
+		run(b, func() *EndpointProxy {
+			if last != nil {
+				last.Close()
+			}
+			last = proxyTo(upstream, ProxyTransport{})
+			return last
+		})
+		last.Close()
+	})
+}
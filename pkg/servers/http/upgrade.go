@@ -0,0 +1,132 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time an upgraded connection is kept open without data in either direction
+const DefaultUpgradeIdleTimeout = 5 * time.Minute
+
+// Protocol that the request or the response switches to, like websocket. Empty if the message
+// is not an upgrade
+func upgradeType(h http.Header) string {
+	if !headerHasToken(h, "Connection", "upgrade") {
+		return ""
+	}
+	return h.Get("Upgrade")
+}
+
+// Pass data both ways between the client and the upstream after the upstream switched protocols.
+// The connections stay open until either side closes, they are idle for too long or the config
+// is replaced
+func (f *EndpointProxy) serveUpgrade(w http.ResponseWriter, r *http.Request, pool *proxyPool, resp *http.Response) {
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		log.Printf("EndpointProxy.Serve: upstream switched protocols without a writable connection")
+		respondWithStatus(w, r, http.StatusBadGateway)
+		return
+	}
+	defer backend.Close()
+	want, got := upgradeType(r.Header), upgradeType(resp.Header)
+	if !strings.EqualFold(want, got) {
+		log.Printf("EndpointProxy.Serve: upstream switched to protocol '%s' instead of '%s'", got, want)
+		respondWithStatus(w, r, http.StatusBadGateway)
+		return
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Printf("EndpointProxy.Serve: can't switch protocols of the client connection: %v", err)
+		respondWithStatus(w, r, http.StatusBadGateway)
+		return
+	}
+	defer conn.Close()
+	// timeouts of the server are meant for requests, not for upgraded connections
+	conn.SetDeadline(time.Time{})
+
+	head := http.Header{}
+	setResponseHeaders(head, resp)
+	head.Set("Connection", "Upgrade")
+	head.Set("Upgrade", got)
+	fmt.Fprintf(brw, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	head.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		log.Printf("EndpointProxy.Serve: error switching protocols: %v", err)
+		return
+	}
+
+	t := &tunnel{client: conn, backend: backend}
+	if !pool.track(t) {
+		// the config was replaced while the upstream was switching
+		return
+	}
+	defer pool.untrack(t)
+	log.Printf("Proxy switched to protocol '%s'", got)
+
+	idle := f.UpgradeIdleTimeout
+	if idle == 0 {
+		idle = DefaultUpgradeIdleTimeout
+	}
+	t.splice(brw.Reader, idle)
+}
+
+// Upgraded connection of a client and the upstream
+type tunnel struct {
+	client  net.Conn
+	backend io.ReadWriteCloser
+	once    sync.Once
+}
+
+// Close both connections, which ends the copying
+func (t *tunnel) Close() error {
+	t.once.Do(func() {
+		t.client.Close()
+		t.backend.Close()
+	})
+	return nil
+}
+
+// Copy data both ways until either side closes or no data passes for the idle time, no limit if
+// it is negative. Data the server read ahead from the client is in the reader
+func (t *tunnel) splice(client *bufio.Reader, idle time.Duration) {
+	var timer *time.Timer
+	if idle > 0 {
+		timer = time.AfterFunc(idle, func() { t.Close() })
+		defer timer.Stop()
+	}
+	done := make(chan struct{}, 2)
+	copyData := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, &activeReader{Reader: src, timer: timer, idle: idle})
+		done <- struct{}{}
+	}
+	go copyData(t.backend, client)
+	go copyData(t.client, t.backend)
+	<-done
+	// one side is finished, the other one can't be used alone
+	t.Close()
+	<-done
+}
+
+// Reader that restarts the idle timer whenever data passes
+type activeReader struct {
+	io.Reader
+	timer *time.Timer
+	idle  time.Duration
+}
+
+func (r *activeReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && r.timer != nil {
+		r.timer.Reset(r.idle)
+	}
+	return n, err
+}
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Upstream that switches to an echo protocol and sends back every line it gets
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upgradeType(r.Header) != "echo" {
+			w.WriteHeader(http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString("echo " + line)
+			brw.Flush()
+		}
+	}), &http2.Server{}))
+}
+
+// Open an upgraded connection through the server
+func dialUpgrade(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || upgradeType(resp.Header) != "echo" {
+		t.Fatalf("response = %v %v, want 101 with 'Upgrade: echo'", resp.Status, resp.Header)
+	}
+	return conn, br
+}
+
+// Whether the connection is closed by the other side in time
+func waitClosed(conn net.Conn, br *bufio.Reader, timeout time.Duration) bool {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err := br.ReadString('\n')
+	return err == io.EOF
+}
+
+func TestEndpointProxy_Upgrade(t *testing.T) {
+	upstream := newEchoServer()
+	defer upstream.Close()
+	for _, h2 := range []bool{false, true} {
+		proxy := proxyTo(upstream, ProxyTransport{HTTP2: h2})
+		proxy.UpgradeIdleTimeout = -1
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxy.Serve(w, r, "")
+		}))
+
+		conn, br := dialUpgrade(t, server)
+		for _, msg := range []string{"hello\n", "world\n"} {
+			io.WriteString(conn, msg)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if got, err := br.ReadString('\n'); err != nil || got != "echo "+msg {
+				t.Errorf("http2 %v read %q, %v, want %q", h2, got, err, "echo "+msg)
+			}
+		}
+		// replacing the config closes upgraded connections
+		proxy.Close()
+		if !waitClosed(conn, br, time.Second) {
+			t.Errorf("http2 %v connection open after Close()", h2)
+		}
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestEndpointProxy_UpgradeIdle(t *testing.T) {
+	upstream := newEchoServer()
+	defer upstream.Close()
+	proxy := proxyTo(upstream, ProxyTransport{})
+	proxy.UpgradeIdleTimeout = 100 * time.Millisecond
+	defer proxy.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.Serve(w, r, "")
+	}))
+	defer server.Close()
+
+	conn, br := dialUpgrade(t, server)
+	defer conn.Close()
+	// traffic keeps the connection open past the timeout
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(conn, "ping\n")
+		if _, err := br.ReadString('\n'); err != nil {
+			t.Fatalf("read after %v: %v", time.Duration(i+1)*50*time.Millisecond, err)
+		}
+	}
+	if !waitClosed(conn, br, time.Second) {
+		t.Error("idle connection was not closed")
+	}
+}
+
+func TestEndpointProxy_UpgradeRefused(t *testing.T) {
+	upstream := newEchoServer()
+	defer upstream.Close()
+	proxy := proxyTo(upstream, ProxyTransport{})
+	defer proxy.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "other")
+	w := httptest.NewRecorder()
+	proxy.Serve(w, r, "")
+	if w.Code != http.StatusUpgradeRequired || strings.Contains(w.Header().Get("Connection"), "Upgrade") {
+		t.Errorf("Serve() = %v %v, want %v", w.Code, w.Header(), http.StatusUpgradeRequired)
+	}
+}
@@ -10,6 +10,8 @@ const code = CodeMirror(codeAttach, {
 	theme: 'material-darker',
 	scrollbarStyle: "null",
 	autoIndent: false,
+	extraKeys: { "Ctrl-Space": "autocomplete" },
+	hintOptions: { hint: hintConfig, completeSingle: false },
 })
 const statusText = document.getElementById("status")
 
@@ -77,4 +79,106 @@ async function pressApply() {
 	}
 }
 
+let schema = null
+
+async function fetchSchema() {
+	let url = new URL("/schema", baseUrl)
+
+	let response = await fetch(url)
+	schema = await response.json()
+}
+
+// Suggest keywords, properties and endpoint types that can be written at the cursor,
+// following the blocks of the config up to it
+function hintConfig(cm) {
+	if (schema == null)
+		return null
+	const cursor = cm.getCursor()
+	const text = cm.getRange(CodeMirror.Pos(0, 0), cursor)
+	const tokens = (text.match(/"""[\s\S]*?(?:"""|$)|'''[\s\S]*?(?:'''|$)|`[^`]*`?|"(?:[^"\\\n]|\\.)*"?|'(?:[^'\\\n]|\\.)*'?|#.*|[{}:\[\],]|[^\s{}:\[\],"'`#]+/g) || [])
+		.filter(t => !t.startsWith("#"))
+
+	// the word being typed is not followed, it's replaced by the hint
+	let prefix = ""
+	if (/[^\s{}:\[\],]$/.test(text) && tokens.length > 0 && !/^[{}:\[\],]$/.test(tokens[tokens.length - 1]))
+		prefix = tokens.pop()
+
+	// null is the top level, undefined is a block that is not described by the schema
+	let stack = [null]
+	let state = "field"
+	let after = null
+	let block = undefined
+	for (const t of tokens) {
+		const top = stack[stack.length - 1]
+		if (t === "{") {
+			stack.push(state === "open" ? block : undefined)
+			state = "field"
+			continue
+		}
+		if (t === "}") {
+			if (stack.length > 1)
+				stack.pop()
+			state = "field"
+			continue
+		}
+		switch (state) {
+			case "field":
+				if (top === null) {
+					state = t === "server" ? "open" : "name"
+					block = t === "server" ? schema : undefined
+				} else if (t === "use") {
+					state = "name"
+					block = undefined
+				} else if (top && top.key) {
+					state = "separator"
+					after = "variant"
+				} else {
+					const prop = top && (top.properties || []).find(p => p.name === t)
+					state = prop && (prop.block || prop.type === "map") ? "open" : "separator"
+					block = prop ? prop.block : undefined
+					after = "value"
+				}
+				break
+			case "separator":
+				state = t === ":" ? after : "field"
+				break
+			case "variant":
+				block = (top.variants || []).find(v => v.name === t)
+				// some variants have a value instead of a block, like error pages
+				state = block && block.value ? "value" : "open"
+				break
+			case "name":
+				state = "open"
+				break
+			case "value":
+				state = t === "[" ? "list" : "field"
+				break
+			case "list":
+				if (t === "]")
+					state = "field"
+				break
+			default:
+				state = "field"
+		}
+	}
+
+	const top = stack[stack.length - 1]
+	let list = []
+	if (state === "field" && top === null)
+		list = ["server", "define"]
+	else if (state === "field" && top)
+		list = (top.properties || []).map(p => p.name).concat(["use"])
+	else if (state === "field")
+		list = ["use"]
+	else if (state === "variant")
+		list = (top.variants || []).map(v => v.name)
+
+	return {
+		list: list.filter(w => w.startsWith(prefix)),
+		from: CodeMirror.Pos(cursor.line, cursor.ch - prefix.length),
+		to: cursor,
+	}
+}
+
 fetchConfig()
+fetchSchema()
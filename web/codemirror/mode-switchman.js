@@ -1,10 +1,28 @@
 CodeMirror.defineSimpleMode("switchman", {
 	start: [
 		{ regex: /#.*/, token: "comment" },
+		{ regex: /"""/, token: "string", next: "tripleDouble" },
+		{ regex: /'''/, token: "string", next: "tripleSingle" },
+		{ regex: /`/, token: "string", next: "raw" },
+		{ regex: /(define|use)(\s+)(\w+)/, token: ["keyword", null, "def"] },
+		{ regex: /\$\w+/, token: "variable-2" },
 		{ regex: /(\w+)(\s*{)/, token: ["keyword", null] },
-		{ regex: /((?:"(?:[^"]|\\")*"|'(?:[^']|\\')*'|[^\s:{}"']+))(\s*:\s*)/, token: ["variable", "operator"] },
-		{ regex: /(?:"(?:[^"]|\\")*"|'(?:[^']|\\')*'|[^\s:{}"']+)/, token: "string" },
-		{ regex: /[^\s{}:]+/, token: "error" },
+		{ regex: /((?:"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|[^\s:{}\[\],"'`]+))(\s*:\s*)/, token: ["variable", "operator"] },
+		{ regex: /(?:"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|[^\s:{}\[\],"'`]+)/, token: "string" },
+		{ regex: /[\[\],]/, token: "bracket" },
+		{ regex: /[^\s{}:\[\],]+/, token: "error" },
+	],
+	tripleDouble: [
+		{ regex: /(?:[^"\\]|\\.|"(?!""))*"""/, token: "string", next: "start" },
+		{ regex: /.*/, token: "string" },
+	],
+	tripleSingle: [
+		{ regex: /(?:[^'\\]|\\.|'(?!''))*'''/, token: "string", next: "start" },
+		{ regex: /.*/, token: "string" },
+	],
+	raw: [
+		{ regex: /[^`]*`/, token: "string", next: "start" },
+		{ regex: /.*/, token: "string" },
 	],
 	meta: {
 		lineComment: "#",